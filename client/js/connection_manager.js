@@ -23,6 +23,26 @@ ConnectionManager.prototype.emit = function (event, data) {
     }
 };
 
+// Game servers count the votes of a session together, so each tab keeps one
+// session ID, across reconnects and reloads.
+ConnectionManager.prototype.session = function () {
+    var id;
+    try {
+        id = sessionStorage.getItem("session");
+    } catch (e) {
+        // Storage is disabled, so make up an ID for this page
+    }
+    if (!id) {
+        id = this.sessionID || Math.random().toString(36).slice(2) + Math.random().toString(36).slice(2);
+        try {
+            sessionStorage.setItem("session", id);
+        } catch (e) {
+        }
+    }
+    this.sessionID = id;
+    return id;
+};
+
 ConnectionManager.prototype.connectToGameServer = function (hostport) {
     var self = this;
     if ('WebSocket' in window) {
@@ -30,7 +50,10 @@ ConnectionManager.prototype.connectToGameServer = function (hostport) {
     } else {
         alert('WebSocket notch supported');
     }
-    var connectionString = 'ws://' + hostport + "/abc";
+    var scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+    // d2048 web serves settings.js with the path game servers use
+    var path = typeof WEBSOCKET_PATH === "string" ? WEBSOCKET_PATH : "/abc";
+    var connectionString = scheme + hostport + path + "?session=" + encodeURIComponent(this.session());
     console.log('connection string is' + connectionString);
     console.log('this is ' + this)
     this.connection = new WebSocket(connectionString);
@@ -48,6 +71,16 @@ ConnectionManager.prototype.connectToGameServer = function (hostport) {
         console.log("message received from gameserver");
         var data = JSON && JSON.parse(e.data) || $.parseJSON(e.data);
         console.log(data);
+        if (data.Hint !== undefined) {
+            self.emit("hint", data);
+            return;
+        }
+        if (data.Reconnect) {
+            // The game server is shutting down, so ask for another one
+            console.log("game server is shutting down, reconnecting");
+            self.connection.close();
+            return;
+        }
         if (!self.boardHasBeenSet) {
         self.boardHasBeenSet = true;
         $(".load-wrapper").css( "display", "none" );
@@ -1,4 +1,6 @@
-var CENTRAL_HOSTPORT = "http://localhost:25340/"
+// Served over https when the cluster runs with TLS, so ask the central
+// server the same way
+var CENTRAL_HOSTPORT = (location.protocol === "https:" ? "https" : "http") + "://localhost:25340/"
 
 function GameManager(size, InputManager, Actuator, StorageManager, ConnManager) {
   this.size           = size; // Size of the grid
@@ -13,9 +15,11 @@ function GameManager(size, InputManager, Actuator, StorageManager, ConnManager)
 
   this.inputManager.on("restart", this.restart.bind(this));
   this.inputManager.on("keepPlaying", this.keepPlaying.bind(this));
+  this.inputManager.on("hint", this.requestHint.bind(this));
 
   this.connManager.on("connectionMade", this.setup.bind(this));
   this.connManager.on("update", this.update.bind(this));
+  this.connManager.on("hint", this.showHint.bind(this));
 
 
   this.connManager.getConnectionFromCServ()
@@ -119,6 +123,15 @@ GameManager.prototype.move = function (dir) {
     this.connManager.connection.send(JSON.stringify(message));
 };
 
+GameManager.prototype.requestHint = function () {
+    this.connManager.connection.send(JSON.stringify({Hint: true}));
+};
+
+GameManager.prototype.showHint = function (data) {
+    var names = ["Up", "Right", "Down", "Left"];
+    $("#hint").text(names[data.Hint]);
+};
+
 GameManager.prototype.update = function (data) {
     console.log("updating");
     this.score = data.Score;
@@ -140,10 +153,15 @@ GameManager.prototype.update = function (data) {
         console.log("lost game");
         this.over = true;
         this.actuate();
+    } else if (this.over) {
+        // The servers have agreed on a new game
+        this.over = false;
+        this.actuator.continueGame();
     }
     console.log(this.grid);
     this.actuate();
     $("#theirmove").text(data.Consensus);
+    $("#hint").text("");
     return;
 };
 
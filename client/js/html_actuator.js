@@ -58,11 +58,12 @@ HTMLActuator.prototype.addTile = function (tile) {
   var classes = ["tile", "tile-" + tile.value, positionClass];
 
   if (tile.value > 2048) classes.push("tile-super");
+  if (tile.value < 0) classes.push("tile-blocker");
 
   this.applyClasses(wrapper, classes);
 
   inner.classList.add("tile-inner");
-  inner.textContent = tile.value;
+  inner.textContent = tile.value < 0 ? "" : tile.value;
 
   if (tile.previousPosition) {
     // Make sure that the tile gets rendered in the previous position first
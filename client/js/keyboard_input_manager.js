@@ -80,6 +80,11 @@ KeyboardInputManager.prototype.listen = function () {
     if (!modifiers && event.which === 82) {
       self.restart.call(self, event);
     }
+
+    // T key asks the server for a hint
+    if (!modifiers && event.which === 84) {
+      self.emit("hint");
+    }
   });
 
   // Respond to swipe events
@@ -12,43 +12,25 @@ else
 fi
 
 # Params
-CENTRAL_SERVER_PKG="distributed2048/runners/cservrunner"
-GAME_SERVER_PKG="distributed2048/runners/grunner"
-CLIENT_SERVER_PKG="distributed2048/runners/crunner"
-CENTRAL_HOSTNAME=localhost
-CENTRAL_PORT=25340
-GAME_SERVER_PORT=15551
+CONFIG="$(dirname "$0")/d2048.toml" # the ports and other settings of every process
+D2048_PKG="distributed2048/runners/d2048"
 NUM_GAME_SERVERS=$1
 
-# Build and install the Central Server binary
-go install ${CENTRAL_SERVER_PKG}
-if [ $? -ne 0 ]; then
-   echo "FAIL: code does not compile"
-   exit $?
-fi
-
-# Build and install the Game Server binary
-go install ${GAME_SERVER_PKG}
-if [ $? -ne 0 ]; then
-   echo "FAIL: code does not compile"
-   exit $?
-fi
-
-# Build and install the Game Server binary
-go install ${CLIENT_SERVER_PKG}
+# Build and install the d2048 binary
+go install ${D2048_PKG}
 if [ $? -ne 0 ]; then
    echo "FAIL: code does not compile"
    exit $?
 fi
 
 # Commands
-CENTRAL_SERVER=$GOPATH/bin/cservrunner
-GAME_SERVER=$GOPATH/bin/grunner
-CLIENT_SERVER=$GOPATH/bin/crunner
+CENTRAL_SERVER="$GOPATH/bin/d2048 central"
+GAME_SERVER="$GOPATH/bin/d2048 game"
+CLIENT_SERVER="$GOPATH/bin/d2048 web"
 
-echo "SCRIPT STARTING CENTRAL SERVER ON PORT ${CENTRAL_PORT}"
-${CENTRAL_SERVER} -port=${CENTRAL_PORT} -gameservers=${NUM_GAME_SERVERS} &
-${CLIENT_SERVER} &
+echo "SCRIPT STARTING CENTRAL SERVER"
+${CENTRAL_SERVER} -config=${CONFIG} -gameservers=${NUM_GAME_SERVERS} &
+${CLIENT_SERVER} -config=${CONFIG} &
 sleep 2
 
 FIRST_PORT=$(((RANDOM % 10000) + 10000))
@@ -57,7 +39,7 @@ do
     # Pick random ports between [10000, 20000).
     GAME_SERVER_PORT=$(($FIRST_PORT + $i))
     echo "SCRIPT STARTING GAME SERVER ON PORT ${GAME_SERVER_PORT}"
-    ${GAME_SERVER} -port=${GAME_SERVER_PORT} -central=${CENTRAL_HOSTNAME}:${CENTRAL_PORT} -hostname=${HOSTNAME} &
+    ${GAME_SERVER} -config=${CONFIG} -port=${GAME_SERVER_PORT} -hostname=${HOSTNAME} &
     GAME_SERVER_PID[$i]=$!
     GAME_SERVER_PORTS[$i]=$GAME_SERVER_PORT
     sleep 1
@@ -0,0 +1,216 @@
+package centralserver
+
+import (
+	"distributed2048/libtls"
+	"distributed2048/rpc/adminrpc"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const ADMIN_TIMEOUT = 1 * time.Second // default for AdminTimeout
+
+// AdminTimeout is how long each RPC to a game server's GameServerAdmin service
+// may take. It may only be changed before the central server starts.
+var AdminTimeout = ADMIN_TIMEOUT
+
+// GameServerInfo is what the admin API shows of a game server: what the
+// central server knows of it, and the status it reported, if it answered.
+type GameServerInfo struct {
+	ID       uint32
+	HostPort string
+	Assigned int  // clients sent to it
+	Draining bool // whether clients are no longer sent to it
+	Healthy  bool // whether it answered in time
+	Error    string
+	Status   *adminrpc.GameServerStatus
+}
+
+// ClusterInfo is what GET /admin/api/cluster replies with.
+type ClusterInfo struct {
+	NumGameServers int // expected in the ring
+	GameServers    []GameServerInfo
+}
+
+// callGameServer makes an RPC to a game server on a new connection, which is
+// closed when it returns, giving up after AdminTimeout. The args are signed
+// with the central server's secret.
+func (cs *centralServer) callGameServer(hostport, serviceMethod string, args, reply interface{}) error {
+	if err := cs.secret.Sign(serviceMethod, args); err != nil {
+		return err
+	}
+	conn, err := cs.tls.Dial(hostport, AdminTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(AdminTimeout))
+	client, err := libtls.NewRPCClient(conn)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call(serviceMethod, args, reply)
+}
+
+// gameServerInfos asks every registered game server for its status, at the
+// same time, and returns what they said, sorted by ID.
+func (cs *centralServer) gameServerInfos() []GameServerInfo {
+	cs.gameServersLock.Lock()
+	infos := make([]GameServerInfo, 0, len(cs.gameServers))
+	for _, gs := range cs.gameServers {
+		infos = append(infos, GameServerInfo{
+			ID:       gs.info.ID,
+			HostPort: gs.info.HostPort,
+			Assigned: gs.clientCount,
+			Draining: gs.draining,
+		})
+	}
+	cs.gameServersLock.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+
+	var wg sync.WaitGroup
+	for i := range infos {
+		wg.Add(1)
+		go func(info *GameServerInfo) {
+			defer wg.Done()
+			var reply adminrpc.GetStatusReply
+			if err := cs.callGameServer(info.HostPort, "GameServerAdmin.GetStatus", &adminrpc.GetStatusArgs{nil}, &reply); err != nil {
+				info.Error = err.Error()
+				return
+			}
+			info.Healthy = true
+			info.Status = &reply.GameServer
+		}(&infos[i])
+	}
+	wg.Wait()
+	return infos
+}
+
+// drain stops clients from being sent to the game server with the given ID,
+// and tells it to turn away new ones, or to shut down gracefully.
+func (cs *centralServer) drain(id uint32, shutdown bool) error {
+	cs.gameServersLock.Lock()
+	gs, ok := cs.gameServers[id]
+	if ok {
+		gs.draining = true
+	}
+	cs.gameServersLock.Unlock()
+	if !ok {
+		return errors.New("no game server with ID " + strconv.FormatUint(uint64(id), 10))
+	}
+	log.Info("draining game server", "node", id, "hostport", gs.info.HostPort, "shutdown", shutdown)
+	var reply adminrpc.DrainReply
+	return cs.callGameServer(gs.info.HostPort, "GameServerAdmin.Drain", &adminrpc.DrainArgs{shutdown, nil}, &reply)
+}
+
+// newGame asks a game server to propose a new game with the given seed to the
+// others, trying each in turn until one answers. It returns the number of
+// the game proposed.
+func (cs *centralServer) newGame(seed uint32) (uint32, error) {
+	cs.gameServersLock.Lock()
+	hostports := make([]string, 0, len(cs.gameServers))
+	for _, gs := range cs.gameServers {
+		hostports = append(hostports, gs.info.HostPort)
+	}
+	cs.gameServersLock.Unlock()
+
+	err := errors.New("no game server has registered")
+	for _, hostport := range hostports {
+		var reply adminrpc.NewGameReply
+		if err = cs.callGameServer(hostport, "GameServerAdmin.NewGame", &adminrpc.NewGameArgs{seed, nil}, &reply); err == nil {
+			log.Info("new game proposed", "game", reply.GameNumber, "seed", seed, "by", hostport)
+			return reply.GameNumber, nil
+		}
+	}
+	return 0, err
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("could not write reply", "err", err)
+	}
+}
+
+// registerAdmin serves the admin API under /admin/api/, and the dashboard
+// that uses it at /admin/, to those that libauth.RequireAdmin lets through:
+// those that give the cluster's secret as their password, or only those on
+// the same machine if authentication is off.
+//
+//	GET  /admin/api/cluster        every game server, with its status
+//	POST /admin/api/drain?id=<id>  stop sending clients to a game server, and
+//	                               shut it down gracefully if shutdown=true
+//	POST /admin/api/reset          start a new game, as at the end of one
+//	POST /admin/api/seed?seed=<n>  start a new game with seed n, or a random one
+func (cs *centralServer) registerAdmin(mux *http.ServeMux) {
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, cs.secret.RequireAdmin(handler))
+	}
+	post := func(handler func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	handle("/admin/api/cluster", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, ClusterInfo{cs.numGameServers, cs.gameServerInfos()})
+	})
+	handle("/admin/api/drain", post(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.FormValue("id"), 10, 32)
+		if err != nil {
+			http.Error(w, "bad game server ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		shutdown, _ := strconv.ParseBool(r.FormValue("shutdown"))
+		if err := cs.drain(uint32(id), shutdown); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]uint32{"Drained": uint32(id)})
+	}))
+	newGame := func(w http.ResponseWriter, seed uint32) {
+		gameNumber, err := cs.newGame(seed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]uint32{"GameNumber": gameNumber, "Seed": seed})
+	}
+	handle("/admin/api/reset", post(func(w http.ResponseWriter, r *http.Request) {
+		newGame(w, 0)
+	}))
+	handle("/admin/api/seed", post(func(w http.ResponseWriter, r *http.Request) {
+		var seed uint32
+		if s := r.FormValue("seed"); s != "" {
+			n, err := strconv.ParseUint(s, 10, 32)
+			if err != nil || n == 0 {
+				http.Error(w, "the seed must be a number from 1 to 4294967295", http.StatusBadRequest)
+				return
+			}
+			seed = uint32(n)
+		}
+		for seed == 0 {
+			seed = rand.Uint32()
+		}
+		newGame(w, seed)
+	}))
+	handle("/admin/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, dashboardHTML)
+	})
+}
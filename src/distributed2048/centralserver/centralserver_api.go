@@ -20,4 +20,17 @@ type CentralServer interface {
 	// game servers have joined, it replies with OK and a list of all the game
 	// servers in the ring.
 	RegisterGameServer(args *centralrpc.RegisterGameServerArgs, reply *centralrpc.RegisterGameServerReply) error
+
+	// DrainGameServer stops clients from being sent to a game server, which
+	// calls it when it starts shutting down. It replies with NotFound if no
+	// game server has registered with that host:port. A game server that
+	// registers again is sent clients again.
+	DrainGameServer(args *centralrpc.DrainGameServerArgs, reply *centralrpc.DrainGameServerReply) error
+
+	// HostPort returns the address that the central server listens on.
+	HostPort() string
+
+	// Close stops the central server from serving game clients and game
+	// servers.
+	Close() error
 }
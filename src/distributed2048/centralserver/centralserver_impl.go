@@ -1,9 +1,12 @@
 package centralserver
 
 import (
+	"distributed2048/libauth"
+	"distributed2048/liblog"
+	"distributed2048/libmetrics"
+	"distributed2048/libtls"
 	"distributed2048/rpc/centralrpc"
 	"distributed2048/rpc/paxosrpc"
-	"distributed2048/util"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,21 +14,15 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
-	"os"
 	"sync"
 )
 
-const (
-	ERROR_LOG bool = true
-	DEBUG_LOG bool = false
-)
-
-var LOGV = util.NewLogger(DEBUG_LOG, "DEBUG", os.Stdout)
-var LOGE = util.NewLogger(ERROR_LOG, "ERROR", os.Stderr)
+var log = liblog.Logger(liblog.CENTRALSERVER)
 
 type gameServer struct {
 	info        paxosrpc.Node
 	clientCount int
+	draining    bool // whether clients are no longer sent to it
 }
 
 type centralServer struct {
@@ -35,10 +32,36 @@ type centralServer struct {
 	hostPortToGameServer map[string]*gameServer
 	gameServersSlice     []paxosrpc.Node
 	numGameServers       int
+	httpServer           *http.Server
+	hostport             string
+	tls                  *libtls.Config // nil if TLS is off
+	secret               libauth.Secret // nil if authentication is off
+
+	metrics     *libmetrics.Registry
+	assignments *libmetrics.Counter // clients sent to each game server
+	registered  *libmetrics.Gauge   // game servers that have registered
 }
 
-func NewCentralServer(port, numGameServers int) (CentralServer, error) {
-	LOGV.Println("New Central Server is starting up")
+// NewCentralServer starts a central server on port, for a ring of
+// numGameServers game servers. It serves everything over TLS if tlsConfig is
+// not nil, and only lets game servers that hold secret register or drain,
+// unless it is nil.
+func NewCentralServer(port, numGameServers int, tlsConfig *libtls.Config, secret libauth.Secret) (CentralServer, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	cs, err := NewCentralServerWithListener(l, numGameServers, tlsConfig, secret)
+	if err != nil {
+		l.Close()
+	}
+	return cs, err
+}
+
+// NewCentralServerWithListener is like NewCentralServer, but serves both
+// game clients and game servers on l, which it takes ownership of.
+func NewCentralServerWithListener(l net.Listener, numGameServers int, tlsConfig *libtls.Config, secret libauth.Secret) (CentralServer, error) {
+	log.Info("central server starting", "hostport", l.Addr().String(), "gameservers", numGameServers)
 	if numGameServers < 1 {
 		return nil, errors.New("numGameServers must be at least 1")
 	}
@@ -48,24 +71,40 @@ func NewCentralServer(port, numGameServers int) (CentralServer, error) {
 		gameServers:          make(map[uint32]*gameServer),
 		hostPortToGameServer: make(map[string]*gameServer),
 		gameServersSlice:     nil,
+		hostport:             l.Addr().String(),
+		tls:                  tlsConfig,
+		secret:               secret,
+		metrics:              libmetrics.NewRegistry(),
 	}
+	cs.assignments = cs.metrics.NewCounter("centralserver_assignments_total", "Clients assigned to each game server.", "game_server")
+	cs.registered = cs.metrics.NewGauge("centralserver_game_servers", "Game servers that have registered.")
 
-	// Serve up information for the game client
-
-	http.HandleFunc("/", cs.gameClientViewHandler)
-	go http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
-
-	rpc.RegisterName("CentralServer", centralrpc.Wrap(cs))
-	rpc.HandleHTTP()
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
+	// Serve up information for the game client, and RPCs for the game
+	// servers, on the same port
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("CentralServer", centralrpc.Wrap(cs)); err != nil {
 		return nil, err
 	}
-	go http.Serve(l, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", cs.gameClientViewHandler)
+	mux.Handle(rpc.DefaultRPCPath, tlsConfig.RequirePeer(rpcServer))
+	mux.Handle("/metrics", libmetrics.Handler(cs.metrics))
+	mux.Handle("/debug/log", cs.secret.RequireAdmin(liblog.AdminHandler()))
+	cs.registerAdmin(mux)
+	cs.httpServer = &http.Server{Handler: mux}
+	go cs.httpServer.Serve(tlsConfig.Listener(l))
 
 	return cs, nil
 }
 
+func (cs *centralServer) HostPort() string {
+	return cs.hostport
+}
+
+func (cs *centralServer) Close() error {
+	return cs.httpServer.Close()
+}
+
 func (cs *centralServer) GetGameServerForClient(args *centralrpc.GetGameServerForClientArgs, reply *centralrpc.GetGameServerForClientReply) error {
 	cs.gameServersLock.Lock()
 	if len(cs.gameServers) < cs.numGameServers {
@@ -76,6 +115,7 @@ func (cs *centralServer) GetGameServerForClient(args *centralrpc.GetGameServerFo
 		cs.gameServers[id].clientCount++
 		reply.Status = centralrpc.OK
 		reply.HostPort = cs.gameServers[id].info.HostPort
+		cs.assignments.Inc(reply.HostPort)
 	}
 	cs.gameServersLock.Unlock()
 
@@ -83,6 +123,10 @@ func (cs *centralServer) GetGameServerForClient(args *centralrpc.GetGameServerFo
 }
 
 func (cs *centralServer) RegisterGameServer(args *centralrpc.RegisterGameServerArgs, reply *centralrpc.RegisterGameServerReply) error {
+	if err := cs.secret.Verify("CentralServer.RegisterGameServer", args); err != nil {
+		log.Warn("rejected a game server that is not authenticated", "hostport", args.HostPort, "err", err)
+		return err
+	}
 	cs.gameServersLock.Lock()
 
 	var id uint32
@@ -90,7 +134,7 @@ func (cs *centralServer) RegisterGameServer(args *centralrpc.RegisterGameServerA
 
 		// Check if we hit the limit
 		if len(cs.gameServers) >= cs.numGameServers {
-			LOGE.Println("Received request to register game server when ring is FULL")
+			log.Error("game server tried to register when the ring is full", "hostport", args.HostPort)
 			reply.Status = centralrpc.Full
 			cs.gameServersLock.Unlock()
 			return nil
@@ -104,11 +148,13 @@ func (cs *centralServer) RegisterGameServer(args *centralrpc.RegisterGameServerA
 		hostport := args.HostPort
 
 		// Add new server object to map
-		gs = &gameServer{paxosrpc.Node{id, hostport}, 0}
+		gs = &gameServer{paxosrpc.Node{id, hostport}, 0, false}
 		cs.gameServers[id] = gs
 		cs.hostPortToGameServer[hostport] = gs
+		cs.registered.Set(float64(len(cs.gameServers)))
 	} else {
 		id = gs.info.ID
+		gs.draining = false
 	}
 
 	// Check if all the game servers in the ring have registered. If they
@@ -131,33 +177,55 @@ func (cs *centralServer) RegisterGameServer(args *centralrpc.RegisterGameServerA
 		reply.Servers = cs.gameServersSlice
 	}
 
-	LOGV.Printf("Received registration request from %d, reply was %d\n", id, reply.Status)
+	log.Debug("registration request", "node", id, "hostport", args.HostPort, "status", reply.Status)
 
 	cs.gameServersLock.Unlock()
 
 	return nil
 }
 
+func (cs *centralServer) DrainGameServer(args *centralrpc.DrainGameServerArgs, reply *centralrpc.DrainGameServerReply) error {
+	if err := cs.secret.Verify("CentralServer.DrainGameServer", args); err != nil {
+		log.Warn("rejected a drain that is not authenticated", "hostport", args.HostPort, "err", err)
+		return err
+	}
+	cs.gameServersLock.Lock()
+	defer cs.gameServersLock.Unlock()
+	gs, ok := cs.hostPortToGameServer[args.HostPort]
+	if !ok {
+		log.Warn("unknown game server asked to be drained", "hostport", args.HostPort)
+		reply.Status = centralrpc.NotFound
+		return nil
+	}
+	if !gs.draining {
+		log.Info("game server is shutting down, draining it", "node", gs.info.ID, "hostport", args.HostPort)
+	}
+	gs.draining = true
+	reply.Status = centralrpc.OK
+	return nil
+}
+
 type HttpReply struct {
 	Status   string
 	Hostport string
 }
 
 func (cs *centralServer) gameClientViewHandler(w http.ResponseWriter, r *http.Request) {
-	LOGV.Println("a new request was made with URI " + r.RequestURI)
+	log.Debug("client asked for a game server", "uri", r.RequestURI)
 	reply := HttpReply{}
 	cs.gameServersLock.Lock()
 	if len(cs.gameServers) < cs.numGameServers {
 		// Not all game servers have connected to the ring, so reply with NotReady
-		LOGV.Println("Not all game servers have connected - replying not ready...")
+		log.Debug("not all game servers have registered, replying not ready")
 		reply.Status = "NotReady"
 		reply.Hostport = ""
 	} else {
-		LOGV.Println("Games servers have connected - replying with OK")
 		id := cs.getGameServerIDMinClients()
+		log.Debug("assigned client a game server", "node", id)
 		cs.gameServers[id].clientCount++
 		reply.Status = "OK"
 		reply.Hostport = cs.gameServers[id].info.HostPort
+		cs.assignments.Inc(reply.Hostport)
 	}
 	cs.gameServersLock.Unlock()
 	buf, err := json.Marshal(reply)
@@ -167,15 +235,23 @@ func (cs *centralServer) gameClientViewHandler(w http.ResponseWriter, r *http.Re
 		w.Header().Set("Connection", "Keep-Alive")
 		_, err = w.Write(buf)
 	} else {
-		LOGE.Printf("Error with marshalling reply: " + err.Error())
+		log.Error("could not marshal reply", "err", err)
 	}
 }
 
 func (cs *centralServer) getGameServerIDMinClients() uint32 {
-	// Must be called with the LOCK acquired
+	// Must be called with the LOCK acquired. Game servers being drained are
+	// skipped, unless every one of them is.
+	allDraining := true
+	for _, gs := range cs.gameServers {
+		allDraining = allDraining && gs.draining
+	}
 	min := math.MaxInt32
 	var resultID uint32
 	for _, gs := range cs.gameServers {
+		if gs.draining && !allDraining {
+			continue
+		}
 		if gs.clientCount < min {
 			min = gs.clientCount
 			resultID = gs.info.ID
@@ -0,0 +1,151 @@
+package centralserver
+
+// dashboardHTML is the admin dashboard, which polls /admin/api/cluster and
+// calls the admin actions.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Distributed 2048 admin</title>
+<style>
+	body { font-family: sans-serif; margin: 2em; color: #333; }
+	table { border-collapse: collapse; margin-bottom: 1.5em; }
+	th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+	th { background: #eee; }
+	td.text { text-align: left; }
+	.bad { color: #c00; }
+	.draining { color: #c80; }
+	#board td { width: 48px; height: 48px; text-align: center; font-weight: bold; background: #eee4da; }
+	#board td.empty { background: #cdc1b4; }
+	#message { margin: 1em 0; }
+</style>
+</head>
+<body>
+<h1>Distributed 2048</h1>
+
+<h2>Game servers</h2>
+<table>
+	<thead>
+		<tr><th>ID</th><th>Address</th><th>Health</th><th>Clients</th><th>Assigned</th><th>Decided slots</th><th>Queue</th><th>Unproposed votes</th><th></th><th></th></tr>
+	</thead>
+	<tbody id="servers"></tbody>
+</table>
+
+<h2>Game <span id="game"></span></h2>
+<p id="score"></p>
+<table id="board"></table>
+<p>Votes being counted: <span id="tally"></span></p>
+<p>
+	<button onclick="act('reset')">Reset game</button>
+	<input id="seed" placeholder="seed (random if empty)">
+	<button onclick="act('seed?seed=' + encodeURIComponent(document.getElementById('seed').value))">New game with seed</button>
+</p>
+<p id="message"></p>
+
+<script>
+function text(s) {
+	return document.createTextNode(s);
+}
+
+function cell(row, value, className) {
+	var td = row.insertCell();
+	td.appendChild(typeof value === 'object' ? value : text(value));
+	if (className) {
+		td.className = className;
+	}
+	return td;
+}
+
+function act(action) {
+	fetch('/admin/api/' + action, {method: 'POST'}).then(function(resp) {
+		return resp.text().then(function(body) {
+			document.getElementById('message').textContent = (resp.ok ? 'Done: ' : 'Failed: ') + body;
+			refresh();
+		});
+	});
+}
+
+function showServers(servers) {
+	var tbody = document.getElementById('servers');
+	tbody.innerHTML = '';
+	servers.forEach(function(gs) {
+		var row = tbody.insertRow();
+		var st = gs.Status || {};
+		cell(row, gs.ID);
+		cell(row, gs.HostPort, 'text');
+		if (!gs.Healthy) {
+			cell(row, 'down: ' + gs.Error, 'text bad');
+		} else {
+			var state = st.ShuttingDown ? 'shutting down' : gs.Draining || st.Draining ? 'draining' : 'up';
+			cell(row, state, state === 'up' ? 'text' : 'text draining');
+		}
+		cell(row, gs.Healthy ? st.Clients : '');
+		cell(row, gs.Assigned);
+		cell(row, gs.Healthy ? st.DecidedSlots : '');
+		cell(row, gs.Healthy ? st.QueueDepth : '');
+		cell(row, gs.Healthy ? st.Buffered : '');
+		var button = document.createElement('button');
+		button.textContent = 'Drain';
+		button.disabled = gs.Draining;
+		button.onclick = function() { act('drain?id=' + gs.ID); };
+		cell(row, button);
+		var stop = document.createElement('button');
+		stop.textContent = 'Shut down';
+		stop.disabled = !gs.Healthy || st.ShuttingDown;
+		stop.onclick = function() { act('drain?shutdown=true&id=' + gs.ID); };
+		cell(row, stop);
+	});
+}
+
+function showGame(servers) {
+	// Show the game of the game server furthest along
+	var st = null;
+	servers.forEach(function(gs) {
+		if (gs.Healthy && gs.Status.Game && (!st || gs.Status.Game.Seq > st.Game.Seq)) {
+			st = gs.Status;
+		}
+	});
+	var board = document.getElementById('board');
+	board.innerHTML = '';
+	if (!st) {
+		document.getElementById('game').textContent = '(not started)';
+		document.getElementById('score').textContent = '';
+		document.getElementById('tally').textContent = '';
+		return;
+	}
+	var game = st.Game;
+	document.getElementById('game').textContent = st.GameNumber + ' (seed ' + game.Options.Seed + ')';
+	document.getElementById('score').textContent = 'Score ' + game.Score + ', ' + game.MoveCount + ' moves' +
+		(game.Won ? ', won' : '') + (game.Over ? ', over' : '');
+	game.Grid.forEach(function(values) {
+		var row = board.insertRow();
+		values.forEach(function(v) {
+			cell(row, v ? v : '', v ? '' : 'empty');
+		});
+	});
+	var tally = [];
+	['Up', 'Down', 'Left', 'Right'].forEach(function(dir) {
+		tally.push(dir + ' ' + (st.Tally[dir] || 0));
+	});
+	document.getElementById('tally').textContent = tally.join(', ');
+}
+
+function refresh() {
+	fetch('/admin/api/cluster').then(function(resp) {
+		return resp.json();
+	}).then(function(cluster) {
+		var servers = cluster.GameServers || [];
+		if (servers.length < cluster.NumGameServers) {
+			document.getElementById('message').textContent = servers.length + ' of ' + cluster.NumGameServers + ' game servers registered';
+		}
+		showServers(servers);
+		showGame(servers);
+	});
+}
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
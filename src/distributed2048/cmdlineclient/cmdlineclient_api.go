@@ -2,11 +2,63 @@
 package cmdlineclient
 
 import (
+	"context"
 	"distributed2048/lib2048"
+	"distributed2048/libai"
+	"distributed2048/util"
+	"time"
 )
 
+// Snapshot is a copy of everything the client knows about the game and its
+// connection at one point in time. It shares no memory with the client.
+type Snapshot struct {
+	util.Game2048State
+	// Server is the host:port of the game server that the client is
+	// connected to, or an empty string while it is reconnecting.
+	Server    string
+	Connected bool
+}
+
+// Cclient is safe for concurrent use. It runs until the context it was
+// created with is cancelled, it is closed, or it cannot reconnect to any game
+// server.
 type Cclient interface {
+	// InputMove queues a move to be sent on the next tick. Only the last move
+	// input between two ticks is sent.
 	InputMove(move lib2048.Direction)
+	// SetBot puts the client in bot mode: whenever no move has been input
+	// since the last tick, it plays the move suggested by bot instead. A nil
+	// bot turns bot mode off.
+	SetBot(bot libai.Advisor)
+	// GetGameState returns a copy of the latest game, which the caller is
+	// free to modify.
 	GetGameState() lib2048.Game2048
-	Close()
+	GetSnapshot() Snapshot
+	// Subscribe returns a channel that receives a snapshot whenever the game
+	// state or the connection changes. Snapshots are not queued up: a reader
+	// that falls behind only gets the latest one. The channel is closed once
+	// ctx is done or the client stops.
+	Subscribe(ctx context.Context) <-chan Snapshot
+	// WaitForState blocks until pred holds for the client's snapshot, and
+	// returns that snapshot. If timeout passes or the client stops first, it
+	// returns the last snapshot seen and an error.
+	WaitForState(pred func(Snapshot) bool, timeout time.Duration) (Snapshot, error)
+	// WaitForMoveCount waits until at least n moves have changed the board
+	// in the current game. A move that the cluster agreed on but that left
+	// the board as it was is not counted, so waiting for the count to go up
+	// after one blocks until another move changes the board: use WaitForSeq
+	// to wait for any move to be applied.
+	WaitForMoveCount(n int, timeout time.Duration) (Snapshot, error)
+	// WaitForSeq waits until the client has received the server's state
+	// with sequence number seq, or a later one.
+	WaitForSeq(seq uint64, timeout time.Duration) (Snapshot, error)
+	// RecordStates makes the client keep every game state it receives from
+	// now on, starting with the current one, for RecordedStates to return.
+	// It is meant for tests: the states are kept for as long as the client
+	// runs.
+	RecordStates()
+	RecordedStates() []util.Game2048State
+	// Close stops the client and waits for it to finish. It returns the error
+	// that stopped the client if it stopped by itself, and nil otherwise.
+	Close() error
 }
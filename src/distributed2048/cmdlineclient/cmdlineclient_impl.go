@@ -2,257 +2,494 @@ package cmdlineclient
 
 import (
 	"code.google.com/p/go.net/websocket"
+	"context"
+	"crypto/rand"
 	"distributed2048/centralserver"
 	"distributed2048/lib2048"
+	"distributed2048/libai"
+	"distributed2048/liblog"
+	"distributed2048/libtls"
+	"distributed2048/libtrace"
 	"distributed2048/util"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
-	"net/http"
-	"os"
-	"strconv"
+	"log/slog"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type cclient struct {
-	conn       *websocket.Conn
-	game       lib2048.Game2048
-	movelist   []lib2048.Direction
-	quitchan   chan int
-	stophandler chan int
-	stopsender chan int
-	stopreceiver chan int
-	moveQueue  chan util.ClientMove
-	cserv string
+	cserv     string
+	session   string         // sent to game servers, which count its votes together
+	tls       *libtls.Config // nil if TLS is off
+	interval  time.Duration
+	cancel    context.CancelFunc
+	ready     chan struct{} // closed once the first game state arrives
+	readyOnce sync.Once
+	done      chan struct{} // closed once the client has stopped
+	err       error         // why the client stopped, only read after done is closed
+
+	// mutex guards everything below
+	mutex    sync.Mutex
+	game     lib2048.Game2048
+	snapshot Snapshot
+	movelist []lib2048.Direction
+	bot      libai.Advisor // if set, plays a move every tick
+	subs     map[chan Snapshot]struct{}
+	stopped  bool
+
+	recording bool                 // whether received states are kept
+	recorded  []util.Game2048State // states received since RecordStates
+
+	log *slog.Logger
+
+	tracer   *libtrace.Tracer
+	voting   *libtrace.Span   // the vote being made, from its first input until it is sent
+	awaiting []*libtrace.Span // votes sent, until the next state arrives
 }
 
-var LOGV = util.NewLogger(false, "CMDLINECLIENT", os.Stdout)
-var LOGE = util.NewLogger(true, "CMDLINECLIENT", os.Stderr)
+const FIRST_STATE_TIMEOUT = 10 * time.Second
+
+// WebsocketPath is the path that game servers serve clients on. It may only
+// be changed before any client connects.
+var WebsocketPath = util.WSPATTERN
+
+// nextClientID numbers the clients of this process in their logs.
+var nextClientID int32
+
+// errReconnect is returned by receive when the game server is shutting down
+// and asks its clients to reconnect elsewhere.
+var errReconnect = errors.New("game server is shutting down")
 
-func NewCClient(cservAddr string, gameServHostPort string, interval int) (Cclient, error) {
-	ws, err := doConnect(cservAddr, gameServHostPort)
+// NewCClient connects to the given game server, or to one assigned by the
+// central server if none is given, and waits for the first game state. The
+// client runs until ctx is cancelled or Close is called. If tlsConfig is not
+// nil, it connects over TLS, trusting the cluster's CA.
+func NewCClient(ctx context.Context, cservAddr string, gameServHostPort string, interval int, tlsConfig *libtls.Config) (Cclient, error) {
+	id := atomic.AddInt32(&nextClientID, 1)
+	log := liblog.Logger(liblog.CLIENT).With("client", id)
+	session := newSession()
+	ws, server, err := doConnect(ctx, log, tlsConfig, cservAddr, gameServHostPort, session)
 	if err != nil {
 		return nil, err
 	}
-	game := lib2048.NewGame2048()
+	ctx, cancel := context.WithCancel(ctx)
 	cc := &cclient{
-		ws,
-		game,
-		make([]lib2048.Direction, 0),
-		make(chan int),
-		make(chan int),
-		make(chan int),
-		make(chan int),
-		make(chan util.ClientMove),
-		cservAddr,
+		cserv:    cservAddr,
+		session:  session,
+		tls:      tlsConfig,
+		interval: time.Duration(interval) * time.Millisecond,
+		cancel:   cancel,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+		game:     lib2048.NewGame2048(),
+		snapshot: Snapshot{Server: server, Connected: true},
+		subs:     make(map[chan Snapshot]struct{}),
+		log:      log,
+		tracer:   libtrace.NewTracer(fmt.Sprintf("client-%d", id)),
+	}
+	go cc.run(ctx, ws)
+
+	// The game (and its seed) is only known once the servers send it
+	select {
+	case <-cc.ready:
+		return cc, nil
+	case <-cc.done:
+		if cc.err == nil {
+			return nil, ctx.Err()
+		}
+		return nil, cc.err
+	case <-time.After(FIRST_STATE_TIMEOUT):
+		cc.Close()
+		return nil, errors.New("timed out waiting for the game state")
 	}
-	// Fire the ticker
-	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
-	go cc.tickHandler(ticker)
-	go cc.websocketHandler()
-	return cc, nil
 }
 
-func doConnect(cservAddr string, gameServHostPort string) (*websocket.Conn, error) {
+// newSession returns a random session ID, which the client keeps when it
+// reconnects.
+func newSession() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// doConnect opens a websocket connection for the given session to the given
+// game server, or to one assigned by the central server if none is given. It
+// returns the connection and the host:port of the game server.
+func doConnect(ctx context.Context, log *slog.Logger, tlsConfig *libtls.Config, cservAddr string, gameServHostPort string, session string) (*websocket.Conn, string, error) {
 	if gameServHostPort == "" {
 		// Get server addr from central server
 		isReady := false
 		hostport := ""
 		for !isReady {
-			resp, err := http.Get(cservAddr)
+			resp, err := tlsConfig.HTTPClient(0).Get(cservAddr)
 			if err != nil {
-				LOGV.Println("Could not connect to central server.")
-				return nil, err
+				log.Debug("could not reach the central server", "err", err)
+				return nil, "", err
 			}
 			data, err := ioutil.ReadAll(resp.Body)
 			resp.Body.Close()
 			if err != nil {
-				LOGV.Println("Your mother phat")
-				return nil, err
+				log.Debug("could not read the central server's reply", "err", err)
+				return nil, "", err
 			}
-			LOGV.Println("received data from cserv")
 			unpacked := &centralserver.HttpReply{}
 			err = json.Unmarshal(data, &unpacked)
 			if err != nil {
-				LOGV.Println("Your mother phat")
-				return nil, err
+				log.Debug("could not parse the central server's reply", "err", err)
+				return nil, "", err
 			}
 			isReady = unpacked.Status == "OK"
 			if isReady {
 				hostport = unpacked.Hostport
 				gameServHostPort = hostport
 				// Connect to the server
-				origin := "http://localhost/"
-				url := "ws://" + gameServHostPort + "/abc"
-				ws, err := websocket.Dial(url, "", origin)
+				ws, err := dialGameServer(tlsConfig, gameServHostPort, session)
 				if err != nil {
-					LOGV.Println("Could not open websocket connection to server")
+					log.Debug("could not connect to game server", "server", gameServHostPort, "err", err)
 					isReady = false
 				} else {
-					LOGE.Println("Connection has been established with server " + gameServHostPort)
-					return ws, nil
+					log.Info("connected to game server", "server", gameServHostPort)
+					return ws, gameServHostPort, nil
 				}
 			}
-			time.Sleep(250 * time.Millisecond)
+			select {
+			case <-ctx.Done():
+				return nil, "", ctx.Err()
+			case <-time.After(250 * time.Millisecond):
+			}
 		}
 	}
 
 	// Connect to the server
-	origin := "http://localhost/"
-	url := "ws://" + gameServHostPort + "/abc"
-	ws, err := websocket.Dial(url, "", origin)
+	ws, err := dialGameServer(tlsConfig, gameServHostPort, session)
 	if err != nil {
-		LOGV.Println("Could not open websocket connection to server")
-		return nil, err
+		log.Debug("could not connect to game server", "server", gameServHostPort, "err", err)
+		return nil, "", err
 	} else {
-		LOGE.Println("Connection has been established with server " + gameServHostPort)
-		return ws, nil
+		log.Info("connected to game server", "server", gameServHostPort)
+		return ws, gameServHostPort, nil
+	}
+}
+
+// dialGameServer opens a websocket connection for session to the game server
+// at hostport, over TLS if tlsConfig is not nil.
+func dialGameServer(tlsConfig *libtls.Config, hostport string, session string) (*websocket.Conn, error) {
+	location := tlsConfig.WebsocketURL(hostport, WebsocketPath) + "?session=" + url.QueryEscape(session)
+	config, err := websocket.NewConfig(location, tlsConfig.URL("localhost/"))
+	if err != nil {
+		return nil, err
 	}
+	config.TlsConfig = tlsConfig.ClientConfig()
+	return websocket.DialConfig(config)
 }
 
-// Ticker Function
-func (c *cclient) tickHandler(ticker *time.Ticker) {
-	defer LOGV.Println("client has stopped ticking.")
+// run owns the websocket connection: it sends moves on every tick, and
+// reconnects through the central server whenever the connection fails. It
+// stops when ctx is done or reconnecting fails.
+func (c *cclient) run(ctx context.Context, ws *websocket.Conn) {
+	defer c.stop()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		recvDone := make(chan error, 1)
+		go func(ws *websocket.Conn) {
+			recvDone <- c.receive(ws)
+		}(ws)
+		err := c.serve(ctx, ws, ticker, recvDone)
+		ws.Close()
+		if err == nil {
+			// Stopped on purpose, so wait for the receiver to notice the
+			// closed connection
+			<-recvDone
+			return
+		}
+
+		if err == errReconnect {
+			c.log.Info("game server is shutting down, reconnecting")
+		} else {
+			c.log.Warn("lost connection to game server, reconnecting", "err", err)
+		}
+		c.setConnection("", false)
+		var server string
+		ws, server, err = doConnect(ctx, c.log, c.tls, c.cserv, "", c.session)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.log.Error("could not reconnect, shutting down", "err", err)
+				c.err = err
+			}
+			return
+		}
+		c.setConnection(server, true)
+	}
+}
+
+// serve sends moves over ws until ctx is done, which returns nil, or the
+// connection fails, which returns the error. Once serve has returned an
+// error, the receiver has exited.
+func (c *cclient) serve(ctx context.Context, ws *websocket.Conn, ticker *time.Ticker, recvDone <-chan error) error {
 	for {
 		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-recvDone:
+			return err
 		case <-ticker.C:
-			length := len(c.movelist)
-			if length > 0 {
-				LOGV.Println("movelist is length " + strconv.Itoa(length))
-				var translatedMove int
-				switch c.movelist[length-1] {
-				case lib2048.Up:
-					translatedMove = 0
-				case lib2048.Right:
-					translatedMove = 1
-				case lib2048.Down:
-					translatedMove = 2
-				case lib2048.Left:
-					translatedMove = 3
-				}
-				move := util.ClientMove{translatedMove}
-				c.moveQueue <- move
-				c.movelist = c.movelist[0:0]
-//				websocket.JSON.Send(c.conn, move)
+			move, ok := c.nextMove()
+			if !ok {
+				continue
+			}
+			if err := websocket.JSON.Send(ws, move); err != nil {
+				ws.Close()
+				<-recvDone
+				return err
 			}
-		case <-c.quitchan:
-			ticker.Stop()
-			close(c.quitchan)
-			return
 		}
 	}
 }
 
-func (c *cclient) Close() {
-	LOGV.Println("closing...")
-	c.quitchan <- 1
-	c.stophandler <- 1
-	c.conn.Close()
+// nextMove takes the move to send on this tick, if there is one.
+func (c *cclient) nextMove() (util.ClientMove, bool) {
+	c.botMove()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	length := len(c.movelist)
+	if length == 0 {
+		return util.ClientMove{}, false
+	}
+	dir := c.movelist[length-1]
+	c.log.Debug("sending vote", "direction", dir, "inputs", length)
+	var trace *libtrace.SpanContext
+	if c.voting != nil {
+		c.voting.SetAttr("direction", dir)
+		c.voting.SetAttr("inputs", length)
+		sc := c.voting.Context()
+		trace = &sc
+		c.awaiting = append(c.awaiting, c.voting)
+		c.voting = nil
+	}
+	move := util.ClientMove{util.DirectionToClient(dir), false, trace}
+	c.movelist = c.movelist[0:0]
+	return move, true
+}
+
+// botMove queues the bot's move, if there is a bot and no move is queued.
+// The bot searches a copy of the game without holding the mutex, so that
+// states keep being received meanwhile; its move is dropped if one arrives.
+func (c *cclient) botMove() {
+	c.mutex.Lock()
+	if c.bot == nil || len(c.movelist) != 0 {
+		c.mutex.Unlock()
+		return
+	}
+	bot := c.bot
+	seq := c.snapshot.Seq
+	game := lib2048.NewGame2048()
+	game.CloneFrom(c.game)
+	c.mutex.Unlock()
+
+	dir, ok := bot.SuggestMove(game)
+	if !ok {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.snapshot.Seq == seq && len(c.movelist) == 0 {
+		c.startVote()
+		c.movelist = append(c.movelist, dir)
+	}
+}
+
+// receive applies the game states sent over ws until the connection fails.
+func (c *cclient) receive(ws *websocket.Conn) error {
+	defer c.log.Debug("receiver stopped")
+	for {
+		var s []byte
+		if err := websocket.Message.Receive(ws, &s); err != nil {
+			return err
+		}
+		var reconnect util.ReconnectMessage
+		if json.Unmarshal(s, &reconnect) == nil && reconnect.Reconnect {
+			return errReconnect
+		}
+		newState := util.Game2048State{}
+		if err := json.Unmarshal(s, &newState); err != nil {
+			c.log.Warn("could not parse game state", "err", err)
+			continue
+		}
+		c.log.Debug("received game state", "seq", newState.Seq, "score", newState.Score)
+
+		c.mutex.Lock()
+		if err := c.game.SetState(newState.State); err != nil {
+			c.mutex.Unlock()
+			c.log.Warn("could not apply game state", "seq", newState.Seq, "err", err)
+			continue
+		}
+		c.snapshot.Game2048State = newState
+		for _, span := range c.awaiting {
+			span.SetAttr("seq", newState.Seq)
+			span.SetAttr("consensus", newState.Consensus)
+			span.End()
+		}
+		c.awaiting = nil
+		if c.recording {
+			c.recorded = append(c.recorded, newState)
+		}
+		c.publish()
+		c.mutex.Unlock()
+		c.readyOnce.Do(func() { close(c.ready) })
+	}
+}
+
+// setConnection records which game server the client is connected to, if
+// any.
+func (c *cclient) setConnection(server string, connected bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.snapshot.Server = server
+	c.snapshot.Connected = connected
+	c.publish()
+}
+
+// publish sends the current snapshot to every subscriber, replacing any
+// snapshot they have not read yet. It must be called with the mutex held.
+func (c *cclient) publish() {
+	for ch := range c.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- c.snapshot
+	}
+}
+
+// stop marks the client as stopped and closes every subscription.
+func (c *cclient) stop() {
+	c.mutex.Lock()
+	c.stopped = true
+	c.snapshot.Server = ""
+	c.snapshot.Connected = false
+	for ch := range c.subs {
+		close(ch)
+	}
+	c.subs = nil
+	c.mutex.Unlock()
+	close(c.done)
+}
+
+func (c *cclient) Close() error {
+	c.cancel()
+	<-c.done
+	return c.err
 }
 
 func (c *cclient) InputMove(move lib2048.Direction) {
-	LOGV.Println("client has input move: " + strconv.Itoa(int(move)))
+	c.log.Debug("input move", "direction", move)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.startVote()
 	c.movelist = append(c.movelist, move)
 }
 
+// startVote starts the span of the next vote, at its first input, if it has
+// not started yet. A vote's span ends when the client receives the state
+// after it was sent. It must be called with the mutex held.
+func (c *cclient) startVote() {
+	if c.voting == nil {
+		c.voting = c.tracer.Start("client.vote", libtrace.SpanContext{})
+	}
+}
+
+func (c *cclient) SetBot(bot libai.Advisor) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.bot = bot
+}
+
 func (c *cclient) GetGameState() lib2048.Game2048 {
-	return c.game
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	game := lib2048.NewGame2048()
+	game.CloneFrom(c.game)
+	return game
 }
 
-func (c *cclient) sender() (chan<- util.ClientMove, chan error) {
-	ch, errCh := make(chan util.ClientMove), make(chan error)
-	go func() {
-		defer LOGV.Println("sender has died")
-		for {
-			select {
-			case <-c.stopsender:
-				close(ch)
-				return
-			case s := <-ch:
-				if err := websocket.JSON.Send(c.conn, s); err != nil {
-					errCh <- err
-					close(ch)
-					return
-				}
-			}
-		}
-	}()
-	return ch, errCh
+func (c *cclient) RecordStates() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.recording {
+		c.recording = true
+		c.recorded = append(c.recorded, c.snapshot.Game2048State)
+	}
+}
+
+func (c *cclient) RecordedStates() []util.Game2048State {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]util.Game2048State(nil), c.recorded...)
 }
 
-func (c *cclient) receiver() (<-chan []byte, chan error) {
-	ch, errCh := make(chan []byte), make(chan error)
+func (c *cclient) GetSnapshot() Snapshot {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.snapshot
+}
+
+func (c *cclient) Subscribe(ctx context.Context) <-chan Snapshot {
+	ch := make(chan Snapshot, 1)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopped {
+		close(ch)
+		return ch
+	}
+	c.subs[ch] = struct{}{}
 	go func() {
-		defer LOGV.Println("receiver has died")
-		for {
-			select {
-			case <-c.stopreceiver:
-				close(ch)
-				return
-			default:
-				var s []byte
-				if err := websocket.Message.Receive(c.conn, &s); err != nil {
-					errCh <- err
-					close(ch)
-					return
-				}
-				ch <- s
-			}
+		select {
+		case <-ctx.Done():
+		case <-c.done:
+			return
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if _, ok := c.subs[ch]; ok {
+			delete(c.subs, ch)
+			close(ch)
 		}
 	}()
-	return ch, errCh
+	return ch
 }
 
-func (c *cclient) websocketHandler() {
-	send, sendErr := c.sender()
-	recv, recvErr := c.receiver()
-	for {
-		select {
-		case s := <-c.moveQueue:
-			send <- s
-		case s := <-recv:
-			newState := &util.Game2048State{}
-			err := json.Unmarshal(s, newState)
-			if err != nil {
-				LOGE.Println(err)
+func (c *cclient) WaitForState(pred func(Snapshot) bool, timeout time.Duration) (Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Subscribe before looking at the current snapshot, so that no update
+	// can slip in between
+	updates := c.Subscribe(ctx)
+	snapshot := c.GetSnapshot()
+	for !pred(snapshot) {
+		next, ok := <-updates
+		if !ok {
+			if ctx.Err() != nil {
+				return snapshot, fmt.Errorf("timed out after %s waiting for the game state", timeout)
 			}
-			LOGV.Print("Trying to set the board to: ")
-			LOGV.Print(newState.Grid)
-			c.game.SetGrid(newState.Grid)
-			c.game.SetScore(newState.Score)
-		case err := <-sendErr:
-			LOGE.Println("Communication error with server while sending move: " + err.Error())
-			c.stopreceiver <- 1
-			LOGE.Println("Attempting Reconnect")
-			ws, err := doConnect(c.cserv, "")
-			LOGE.Println("Reconnect complete")
-			if err != nil {
-				LOGE.Println("Unable to reconnect to server. Shutting down..")
-				go c.Close()
-			}
-			c.conn = ws
-			send, sendErr = c.sender()
-			recv, recvErr = c.receiver()
-		case err := <-recvErr:
-			LOGE.Println("Communication error with server while receiving state")
-			c.stopsender <- 1
-			LOGE.Println("Attempting Reconnect")
-			ws, err := doConnect(c.cserv, "")
-			LOGE.Println("Reconnect complete")
-
-			if err != nil {
-				LOGE.Println("Unable to reconnect to server. Shutting down..")
-				go c.Close()
-			}
-			c.conn = ws
-			send, sendErr = c.sender()
-			recv, recvErr = c.receiver()
-		case <-c.stophandler:
-			<-c.stopreceiver
-			<-c.stopsender
-			close(c.stopreceiver)
-			close(c.stopsender)
-			close(c.stophandler)
-			return
+			return snapshot, errors.New("client stopped while waiting for the game state")
 		}
+		snapshot = next
 	}
+	return snapshot, nil
+}
+
+func (c *cclient) WaitForMoveCount(n int, timeout time.Duration) (Snapshot, error) {
+	return c.WaitForState(func(s Snapshot) bool { return s.MoveCount >= n }, timeout)
+}
+
+func (c *cclient) WaitForSeq(seq uint64, timeout time.Duration) (Snapshot, error) {
+	return c.WaitForState(func(s Snapshot) bool { return s.Seq >= seq }, timeout)
 }
@@ -0,0 +1,208 @@
+package gameserver
+
+import (
+	"code.google.com/p/go.net/websocket"
+	"context"
+	"distributed2048/rpc/adminrpc"
+	"distributed2048/rpc/centralrpc"
+	"distributed2048/util"
+	"encoding/json"
+	"errors"
+)
+
+func (gs *gameServer) Status() adminrpc.GameServerStatus {
+	paxosStatus := gs.libpaxos.Status()
+	status := adminrpc.GameServerStatus{
+		ID:           gs.id,
+		HostPort:     gs.hostport,
+		DecidedSlots: paxosStatus.DecidedSlots,
+		QueueDepth:   paxosStatus.QueueDepth,
+		Tally:        make(map[string]int),
+	}
+
+	gs.clientsMutex.Lock()
+	status.Clients = len(gs.clients)
+	status.Draining = gs.draining
+	status.ShuttingDown = gs.shuttingDown
+	gs.clientsMutex.Unlock()
+
+	gs.gameMutex.Lock()
+	status.GameNumber = gs.gameNumber
+	if gs.game2048 != nil {
+		status.Game = gs.getWrappedState(nil)
+	}
+	for dir, votes := range gs.tally {
+		status.Tally[dir.String()] = votes
+	}
+	status.Buffered = gs.buffered
+	gs.gameMutex.Unlock()
+	return status
+}
+
+func (gs *gameServer) Drain() {
+	gs.clientsMutex.Lock()
+	if !gs.draining {
+		gs.log.Info("draining, turning away new clients")
+	}
+	gs.draining = true
+	gs.clientsMutex.Unlock()
+}
+
+func (gs *gameServer) Shutdown(ctx context.Context) error {
+	gs.shutdownOnce.Do(func() {
+		gs.shutdownErr = gs.shutdown(ctx)
+		if gs.shutdownErr != nil {
+			gs.log.Error("could not shut down gracefully", "err", gs.shutdownErr)
+		}
+		close(gs.drained)
+	})
+	return gs.shutdownErr
+}
+
+func (gs *gameServer) Drained() <-chan struct{} {
+	return gs.drained
+}
+
+func (gs *gameServer) shutdown(ctx context.Context) error {
+	defer gs.Close()
+
+	gs.clientsMutex.Lock()
+	gs.draining = true
+	gs.shuttingDown = true
+	clients := make([]*client, 0, len(gs.clients))
+	for _, c := range gs.clients {
+		clients = append(clients, c)
+	}
+	gs.clientsMutex.Unlock()
+	gs.log.Info("shutting down", "clients", len(clients))
+
+	if err := gs.drainFromCentral(); err != nil {
+		// The clients may be sent back here, but will be turned away
+		gs.log.Warn("could not tell the central server to stop sending clients", "err", err)
+	}
+
+	// Send the clients elsewhere, and wait for their handlers to hand over
+	// the votes they read
+	buf, _ := json.Marshal(util.ReconnectMessage{true})
+	for _, c := range clients {
+		if err := websocket.Message.Send(c.conn, string(buf)); err != nil {
+			gs.log.Debug("could not tell client to reconnect", "client", c.id, "err", err)
+		}
+		c.conn.Close()
+	}
+	handlersDone := make(chan struct{})
+	go func() {
+		gs.clientsWG.Wait()
+		close(handlersDone)
+	}()
+	select {
+	case <-handlersDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// Propose the votes that are left, and wait for everything proposed to
+	// be decided
+	flushed := make(chan struct{})
+	select {
+	case gs.flushCh <- flushed:
+	case <-gs.closing:
+		return errors.New("game server has been closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := gs.libpaxos.Flush(ctx); err != nil {
+		return err
+	}
+	gs.log.Info("every proposal decided, stopping")
+	return nil
+}
+
+// drainFromCentral tells the central server to stop sending clients here.
+func (gs *gameServer) drainFromCentral() error {
+	c, err := gs.tls.DialRPC(gs.centralHostPort)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	args := &centralrpc.DrainGameServerArgs{gs.hostport, nil}
+	if err := gs.secret.Sign("CentralServer.DrainGameServer", args); err != nil {
+		return err
+	}
+	var reply centralrpc.DrainGameServerReply
+	if err := c.Call("CentralServer.DrainGameServer", args, &reply); err != nil {
+		return err
+	}
+	if reply.Status != centralrpc.OK {
+		return errors.New("the central server does not know this game server")
+	}
+	return nil
+}
+
+func (gs *gameServer) NewGame(seed uint32) uint32 {
+	gs.gameMutex.Lock()
+	gameNumber := gs.gameNumber + 1
+	gs.gameMutex.Unlock()
+
+	options := gs.gameOptions
+	if seed != 0 {
+		options.Seed = seed
+	}
+	gs.log.Info("new game requested", "game", gameNumber, "seed", seed)
+	gs.proposeGame(gameNumber, options)
+	return gameNumber
+}
+
+// admin serves the GameServerAdmin RPC service for the central server's
+// admin API. Calls that are not signed with the game server's secret are
+// turned away, if authentication is on.
+type admin struct {
+	gs *gameServer
+}
+
+func (a *admin) verify(method string, args interface{}) error {
+	err := a.gs.secret.Verify(method, args)
+	if err != nil {
+		a.gs.log.Warn("rejected an admin call that is not authenticated", "method", method)
+	}
+	return err
+}
+
+func (a *admin) GetStatus(args *adminrpc.GetStatusArgs, reply *adminrpc.GetStatusReply) error {
+	if err := a.verify("GameServerAdmin.GetStatus", args); err != nil {
+		return err
+	}
+	reply.Status = adminrpc.OK
+	reply.GameServer = a.gs.Status()
+	return nil
+}
+
+func (a *admin) Drain(args *adminrpc.DrainArgs, reply *adminrpc.DrainReply) error {
+	if err := a.verify("GameServerAdmin.Drain", args); err != nil {
+		return err
+	}
+	a.gs.Drain()
+	if args.Shutdown {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), DrainTimeout)
+			defer cancel()
+			a.gs.Shutdown(ctx)
+		}()
+	}
+	reply.Status = adminrpc.OK
+	return nil
+}
+
+func (a *admin) NewGame(args *adminrpc.NewGameArgs, reply *adminrpc.NewGameReply) error {
+	if err := a.verify("GameServerAdmin.NewGame", args); err != nil {
+		return err
+	}
+	reply.GameNumber = a.gs.NewGame(args.Seed)
+	reply.Status = adminrpc.OK
+	return nil
+}
@@ -3,10 +3,49 @@
 package gameserver
 
 import (
+	"context"
+	"distributed2048/libhistory"
 	"distributed2048/libpaxos"
+	"distributed2048/rpc/adminrpc"
 )
 
 type GameServer interface {
 	ListenForClients()
 	GetLibpaxos() libpaxos.Libpaxos
+	// ServeFaultAdmin lets faults be injected into the game server's Paxos
+	// messages through the FaultInjector RPC service, on the same address as
+	// the game server. See faultrpc.
+	ServeFaultAdmin() error
+	// ID returns the ID that the central server gave the game server, which
+	// its Paxos node goes by.
+	ID() uint32
+	// HostPort returns the address that the game server registered with the
+	// central server.
+	HostPort() string
+	// History returns what the game server did with the values decided for
+	// the last HISTORY_LENGTH slots, in slot order.
+	History() []libhistory.Entry
+	// Status describes the game server, its Paxos node and its game, as the
+	// GameServerAdmin RPC service does.
+	Status() adminrpc.GameServerStatus
+	// Drain makes the game server turn away new clients. The clients it has
+	// keep playing.
+	Drain()
+	// Shutdown stops the game server gracefully: it turns away new clients,
+	// tells the central server to stop sending it clients, tells the clients
+	// it has to reconnect elsewhere, proposes the votes they sent, and closes
+	// once every value it proposed has been decided. If ctx is done first, it
+	// closes straight away and returns ctx.Err().
+	Shutdown(ctx context.Context) error
+	// Drained is closed once the game server has shut down gracefully,
+	// whether Shutdown was called here or through the GameServerAdmin RPC
+	// service.
+	Drained() <-chan struct{}
+	// NewGame ends the current game, and proposes a new one with the given
+	// seed to every game server. If seed is 0, the new game is proposed as it
+	// is at the end of a game. It returns the number of the game proposed.
+	NewGame(seed uint32) uint32
+	// Close stops the game server as if it had crashed: it hangs up on its
+	// clients and the other game servers, and stops taking part in Paxos.
+	Close() error
 }
@@ -3,36 +3,73 @@ package gameserver
 import (
 	"code.google.com/p/go.net/websocket"
 	"distributed2048/lib2048"
+	"distributed2048/libai"
+	"distributed2048/libauth"
+	"distributed2048/libhistory"
+	"distributed2048/liblog"
+	"distributed2048/libmetrics"
 	"distributed2048/libpaxos"
+	"distributed2048/libreplay"
+	"distributed2048/libtls"
+	"distributed2048/libtrace"
+	"distributed2048/rpc/adminrpc"
 	"distributed2048/rpc/centralrpc"
+	"distributed2048/rpc/faultrpc"
 	"distributed2048/rpc/paxosrpc"
 	"distributed2048/util"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/rpc"
-	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
-	LOG_TO_FILE bool = false
-	ERROR_LOG   bool = true
-	DEBUG_LOG   bool = false
-
-	REGISTER_RETRY_INTERVAL = 500
-	CLIENT_UPDATE_INTERVAL  = 350
+	REGISTER_RETRY_INTERVAL = 500   // default for RegisterRetryInterval, in milliseconds
+	CLIENT_UPDATE_INTERVAL  = 350   // default for ProposeInterval, in milliseconds
+	HISTORY_LENGTH          = 10000 // number of decided slots kept for History
+	DRAIN_TIMEOUT           = 30    // default for DrainTimeout, in seconds
 )
 
-var LOGV, LOGE *log.Logger
+// These may only be changed before any game server starts.
+var (
+	// RegisterRetryInterval is how long a game server waits between attempts
+	// to register with the central server, until the cluster is complete.
+	RegisterRetryInterval = REGISTER_RETRY_INTERVAL * time.Millisecond
+	// ProposeInterval is how often a game server proposes the votes it
+	// received since the last time.
+	ProposeInterval = CLIENT_UPDATE_INTERVAL * time.Millisecond
+	// DrainTimeout is how long a game server shut down through the
+	// GameServerAdmin RPC service waits for its votes to be decided.
+	DrainTimeout = DRAIN_TIMEOUT * time.Second
+)
 
 type client struct {
-	id   int
-	conn *websocket.Conn
+	id      int
+	conn    *websocket.Conn
+	session string // given by the client, or shared by those at its IP that gave none
+	ip      string
+}
+
+// vote is a vote from a client, waiting to be proposed, with the span of its
+// wait if it is traced.
+type vote struct {
+	move lib2048.Move
+	span *libtrace.Span
+}
+
+// pendingBucket is a decided value whose votes have not all been counted, with
+// the span of its wait if it is traced.
+type pendingBucket struct {
+	span *libtrace.Span
+	left int // votes not yet counted
 }
 
 type gameServer struct {
@@ -57,25 +94,110 @@ type gameServer struct {
 	totalNumGameServers int
 	game2048            lib2048.Game2048
 	stateBroadcastCh    chan *util.Game2048State
-	clientMoveCh        chan *lib2048.Move
+	clientMoveCh        chan *vote
+	recorder            *libreplay.Recorder
+
+	gameMutex   sync.Mutex
+	gameNumber  uint32          // number of the game being played, 0 before the first
+	seq         uint64          // number of state changes applied, sent to clients
+	gameOptions lib2048.Options // options for every new game, a 0 seed is picked at random
+
+	advisor libai.Advisor // suggests moves to clients that ask for a hint
+	hint    hint          // the advisor's suggestion for the current board
+
+	mux        *http.ServeMux // serves both websocket clients and Paxos RPCs
+	httpServer *http.Server
+	listener   *util.TrackingListener // hangs up on clients and game servers on Close
+	closing    chan struct{}          // closed when Close is called
+	closeOnce  sync.Once
+
+	history  *libhistory.History // what was done with each decided slot
+	nextSlot uint32              // slot of the next decided value, only used by processMoves
+
+	rpcServer *rpc.Server // serves Paxos RPCs, and fault injection if enabled
+
+	metrics    *gameServerMetrics
+	roundStart time.Time // when the first vote of this round arrived, guarded by gameMutex
+
+	log *slog.Logger
+
+	draining bool                      // whether new clients are turned away, guarded by clientsMutex
+	tally    map[lib2048.Direction]int // decided votes not yet counted, guarded by gameMutex
+	buffered int                       // votes not yet proposed, guarded by gameMutex
+
+	tracer *libtrace.Tracer
+
+	centralHostPort string             // told when the game server shuts down
+	clientsWG       sync.WaitGroup     // client handlers running, added to with clientsMutex held
+	flushCh         chan chan struct{} // asks clientMasterHandler to propose its votes now
+	shuttingDown    bool               // guarded by clientsMutex
+	shutdownOnce    sync.Once
+	shutdownErr     error
+	drained         chan struct{} // closed once Shutdown is done
+
+	limiter *voteLimiter // keeps clients to VotesPerClient and VotesPerIP
+
+	tls    *libtls.Config // nil if TLS is off
+	secret libauth.Secret // nil if authentication is off
 }
 
 // NewGameServer creates an instance of a Game Server. It does not return
 // until it has successfully joined the cluster of game servers and started
-// its libpaxos service.
-func NewGameServer(centralServerHostPort, hostname string, port int, pattern string) (GameServer, error) {
+// its libpaxos service. If replayDir is not empty, a replay of every game
+// played is recorded into that directory. New games are proposed with the
+// given options. If their seed is 0, a random one is proposed instead, and
+// the servers agree on one for each new game through Paxos. Everything is
+// served and dialled over TLS if tlsConfig is not nil, and calls to the
+// central server and the other game servers are signed with secret unless it
+// is nil.
+func NewGameServer(centralServerHostPort, hostname string, port int, pattern string, replayDir string, gameOptions lib2048.Options, tlsConfig *libtls.Config, secret libauth.Secret) (GameServer, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", hostname, port))
+	if err != nil {
+		return nil, err
+	}
+	gs, err := NewGameServerWithListener(l, centralServerHostPort, hostname, pattern, replayDir, gameOptions, tlsConfig, secret)
+	if err != nil {
+		l.Close()
+	}
+	return gs, err
+}
+
+// NewGameServerWithListener is like NewGameServer, but serves both clients
+// and the other game servers on l, which it takes ownership of. It registers
+// with the central server as hostname, on the port that l listens on.
+func NewGameServerWithListener(l net.Listener, centralServerHostPort, hostname string, pattern string, replayDir string, gameOptions lib2048.Options, tlsConfig *libtls.Config, secret libauth.Secret) (GameServer, error) {
+	if err := lib2048.ValidateOptions(gameOptions); err != nil {
+		return nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+
+	// Start serving straight away, so that the other game servers can reach
+	// this one as soon as they know about it
+	rpcServer := rpc.NewServer()
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, tlsConfig.RequirePeer(rpcServer))
+	httpServer := &http.Server{Handler: mux}
+	listener := util.NewTrackingListener(l)
+	go httpServer.Serve(tlsConfig.Listener(listener))
+
 	// RPC Dial to the central server to join the ring
-	c, err := rpc.DialHTTP("tcp", centralServerHostPort)
+	c, err := tlsConfig.DialRPC(centralServerHostPort)
 	if err != nil {
 		fmt.Println("Could not connect to central server host port via RPC")
 		fmt.Println(err)
+		httpServer.Close()
 		return nil, err
 	}
+	defer c.Close()
 
 	// Register myself with the central server, obtaining my ID, and a
 	// complete list of all servers in the ring.
 	gshostport := fmt.Sprintf("%s:%d", hostname, port)
-	args := &centralrpc.RegisterGameServerArgs{gshostport}
+	args := &centralrpc.RegisterGameServerArgs{gshostport, nil}
+	if err := secret.Sign("CentralServer.RegisterGameServer", args); err != nil {
+		httpServer.Close()
+		return nil, err
+	}
 	var reply centralrpc.RegisterGameServerReply
 	reply.Status = centralrpc.NotReady
 	for reply.Status != centralrpc.OK {
@@ -83,19 +205,22 @@ func NewGameServer(centralServerHostPort, hostname string, port int, pattern str
 		if err != nil {
 			fmt.Println("Could not RPC call method CentralServer.RegisterGameServer")
 			fmt.Println(err)
+			httpServer.Close()
 			return nil, err
 		}
 		if reply.Status == centralrpc.Full {
+			httpServer.Close()
 			return nil, errors.New("Could not register with central server, ring FULL")
 		}
-		time.Sleep(REGISTER_RETRY_INTERVAL * time.Millisecond)
+		time.Sleep(RegisterRetryInterval)
 	}
 
 	// Start the libpaxos service
-	newlibpaxos, err := libpaxos.NewLibpaxos(reply.GameServerID, gshostport, reply.Servers)
+	newlibpaxos, err := libpaxos.NewLibpaxosOnServer(reply.GameServerID, gshostport, reply.Servers, rpcServer, tlsConfig, secret)
 	if err != nil {
 		fmt.Println("Could not start libpaxos")
 		fmt.Println(err)
+		httpServer.Close()
 		return nil, err
 	}
 
@@ -106,23 +231,7 @@ func NewGameServer(centralServerHostPort, hostname string, port int, pattern str
 	doneCh := make(chan bool)
 	errCh := make(chan error)
 
-	// Debugging setup
-	var vOut, eOut io.Writer
-	if LOG_TO_FILE {
-		now := time.Now().String()
-		var err1, err2 error
-		vOut, err1 = os.Create(fmt.Sprintf("%d_debug_%s.log", reply.GameServerID, now))
-		eOut, err2 = os.Create(fmt.Sprintf("%d_error_%s.log", reply.GameServerID, now))
-		if err1 != nil || err2 != nil {
-			panic(err)
-		}
-	} else {
-		vOut = os.Stdout
-		eOut = os.Stderr
-	}
-	LOGV = util.NewLogger(DEBUG_LOG, "DEBUG", vOut)
-	LOGE = util.NewLogger(ERROR_LOG, "ERROR", eOut)
-
+	metrics := newGameServerMetrics()
 	gs := &gameServer{
 		reply.GameServerID,
 		hostname,
@@ -139,18 +248,70 @@ func NewGameServer(centralServerHostPort, hostname string, port int, pattern str
 		0,
 		make(chan *paxosrpc.ProposalValue, 1000),
 		len(reply.Servers),
-		lib2048.NewGame2048(),
+		nil,
 		make(chan *util.Game2048State, 1000),
-		make(chan *lib2048.Move, 1000),
+		make(chan *vote, 1000),
+		nil,
+		sync.Mutex{},
+		0,
+		0,
+		gameOptions,
+		libai.NewExpectimax(libai.DefaultDepth),
+		hint{},
+		mux,
+		httpServer,
+		listener,
+		make(chan struct{}),
+		sync.Once{},
+		libhistory.NewHistory(HISTORY_LENGTH),
+		0,
+		rpcServer,
+		metrics,
+		time.Time{},
+		liblog.Logger(liblog.GAMESERVER).With("node", reply.GameServerID),
+		false,
+		nil,
+		0,
+		libtrace.NewTracer(fmt.Sprintf("gameserver-%d", reply.GameServerID)),
+		centralServerHostPort,
+		sync.WaitGroup{},
+		make(chan chan struct{}),
+		false,
+		sync.Once{},
+		nil,
+		make(chan struct{}),
+		newVoteLimiter(metrics),
+		tlsConfig,
+		secret,
+	}
+	if replayDir != "" {
+		gs.recorder, err = libreplay.NewRecorder(replayDir)
+		if err != nil {
+			fmt.Println("Could not start replay recorder")
+			fmt.Println(err)
+			newlibpaxos.Close()
+			httpServer.Close()
+			return nil, err
+		}
+	}
+	if err := rpcServer.RegisterName("GameServerAdmin", adminrpc.Wrap(&admin{gs})); err != nil {
+		newlibpaxos.Close()
+		httpServer.Close()
+		return nil, err
 	}
 	gs.libpaxos.DecidedHandler(gs.handleDecided)
-	LOGV.Printf("GS node %d loaded libpaxos\n", reply.GameServerID)
+	mux.Handle("/metrics", libmetrics.Handler(gs.metrics.registry, newlibpaxos.Metrics()))
+	mux.Handle("/debug/log", secret.RequireAdmin(liblog.AdminHandler()))
+	gs.log.Info("game server started", "hostport", gshostport)
 
 	go gs.ListenForClients()
 	go gs.processMoves()
 	go gs.clientTasker()
 	go gs.clientMasterHandler()
 
+	// The game does not start until everybody agrees on its seed
+	gs.proposeNewGame(1)
+
 	return gs, nil
 }
 
@@ -158,7 +319,40 @@ func (gs *gameServer) GetLibpaxos() libpaxos.Libpaxos {
 	return gs.libpaxos
 }
 
-func (gs *gameServer) clientListenRead(ws *websocket.Conn) {
+func (gs *gameServer) History() []libhistory.Entry {
+	return gs.history.Entries()
+}
+
+func (gs *gameServer) ServeFaultAdmin() error {
+	return gs.rpcServer.RegisterName("FaultInjector", faultrpc.Wrap(libpaxos.NewFaultAdmin(gs.libpaxos.Faults(), gs.secret)))
+}
+
+func (gs *gameServer) ID() uint32 {
+	return gs.id
+}
+
+func (gs *gameServer) HostPort() string {
+	return gs.hostport
+}
+
+func (gs *gameServer) Close() error {
+	gs.closeOnce.Do(func() { close(gs.closing) })
+	err := gs.httpServer.Close()
+	gs.listener.CloseConns()
+
+	if perr := gs.libpaxos.Close(); err == nil {
+		err = perr
+	}
+	if gs.recorder != nil {
+		if rerr := gs.recorder.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+func (gs *gameServer) clientListenRead(c *client, log *slog.Logger) {
+	ws := c.conn
 	defer func() {
 		ws.Close()
 	}()
@@ -171,46 +365,100 @@ func (gs *gameServer) clientListenRead(ws *websocket.Conn) {
 			if err == io.EOF {
 				return
 				// EOF!
+			} else if _, ok := err.(*json.SyntaxError); ok {
+				log.Warn("could not read move", "err", err)
 			} else if err != nil {
-				LOGE.Println(err)
+				// The connection is broken, or was hung up by Close
+				log.Info("lost connection to client", "err", err)
+				return
+			} else if move.Hint {
+				gs.sendHint(ws, log)
 			} else {
-				var dir lib2048.Direction
-				switch move.Direction {
-				case 0:
-					dir = lib2048.Up
-				case 1:
-					dir = lib2048.Right
-				case 2:
-					dir = lib2048.Down
-				case 3:
-					dir = lib2048.Left
+				dir := util.DirectionFromClient(move.Direction)
+				log.Debug("received vote", "direction", dir)
+				switch gs.limiter.vote(c) {
+				case voteDownweighted:
+					log.Debug("vote down-weighted")
+					continue
+				case voteOverClientLimit, voteOverIPLimit:
+					log.Debug("vote over the limit", "session", c.session, "ip", c.ip)
+					if gs.limiter.penalty == PenaltyDrop {
+						log.Info("dropping client over its vote limit", "session", c.session, "ip", c.ip)
+						gs.metrics.clientsDropped.Inc()
+						return
+					}
+					continue
+				}
+				var parent libtrace.SpanContext
+				if move.Trace != nil {
+					parent = *move.Trace
 				}
-				LOGV.Println("Received", dir, "from web client")
-				move := lib2048.NewMove(dir)
-				gs.clientMoveCh <- move
+				span := gs.tracer.Start("gameserver.buffer", parent)
+				span.SetAttr("direction", dir)
+				gs.clientMoveCh <- &vote{*lib2048.NewMove(dir), span}
 			}
 		}
 	}
 }
 
 func (gs *gameServer) clientMasterHandler() {
-	ticker := time.NewTicker(CLIENT_UPDATE_INTERVAL * time.Millisecond) // send proposals every interval
+	ticker := time.NewTicker(ProposeInterval) // send proposals every interval
 	moves := make([]lib2048.Move, 0)
+	var spans []*libtrace.Span // of the votes in moves, which are nil if not traced
+	defer ticker.Stop()
+	buffer := func(v *vote) {
+		moves = append(moves, v.move)
+		spans = append(spans, v.span)
+		gs.metrics.votes.Inc(v.move.Direction.String())
+		gs.gameMutex.Lock()
+		if gs.roundStart.IsZero() {
+			gs.roundStart = time.Now()
+		}
+		gs.buffered = len(moves)
+		gs.gameMutex.Unlock()
+	}
+	propose := func() {
+		if len(moves) == 0 {
+			return
+		}
+		// The votes are proposed together, in a trace of their own
+		span := gs.tracer.Start("gameserver.propose", libtrace.SpanContext{})
+		span.SetAttr("votes", len(moves))
+		for _, s := range spans {
+			span.Link(s.Context())
+			s.End()
+		}
+		gs.libpaxos.Propose(&paxosrpc.ProposalValue{moves, nil, span.Context()})
+		span.End()
+		moves = make([]lib2048.Move, 0)
+		spans = nil
+		gs.gameMutex.Lock()
+		gs.buffered = 0
+		gs.gameMutex.Unlock()
+	}
 	for {
 		select {
-		case move := <-gs.clientMoveCh:
-			moves = append(moves, *move)
+		case <-gs.closing:
+			return
+		case v := <-gs.clientMoveCh:
+			buffer(v)
 		case <-ticker.C:
-			if len(moves) > 0 {
-				gs.libpaxos.Propose(&paxosrpc.ProposalValue{moves})
-				moves = make([]lib2048.Move, 0)
+			propose()
+			gs.limiter.nextRound()
+		case done := <-gs.flushCh:
+			// Every client handler has stopped, so the votes still in the
+			// channel are the last ones
+			for len(gs.clientMoveCh) > 0 {
+				buffer(<-gs.clientMoveCh)
 			}
+			propose()
+			gs.limiter.nextRound()
+			close(done)
 		}
 	}
 }
 
 func (gs *gameServer) handleDecided(proposalValue *paxosrpc.ProposalValue) {
-	LOGV.Println(gs.id, "obtained decided proposal.")
 	gs.newMovesCh <- proposalValue
 }
 
@@ -219,13 +467,48 @@ func (gs *gameServer) handleDecided(proposalValue *paxosrpc.ProposalValue) {
 func (gs *gameServer) processMoves() {
 	sizeQueue := make([]int, 0)
 	pendingMoves := make([]lib2048.Move, 0)
+	pendingBuckets := make([]pendingBucket, 0) // the values pendingMoves came from
 	currentBucketSize := 0
 	for {
 		select {
+		case <-gs.closing:
+			return
 		case proposal := <-gs.newMovesCh:
+			// Values are decided in slot order, starting from slot 0
+			gs.log.Debug("applying decided value", "slot", gs.nextSlot, "value", describeValue(proposal))
+			gs.history.Add(libhistory.Entry{Slot: gs.nextSlot, Value: describeValue(proposal)})
+			gs.nextSlot++
+			if proposal.NewGame != nil {
+				if gs.startGame(proposal.NewGame) {
+					// Votes left over from the previous game are dropped
+					sizeQueue = make([]int, 0)
+					pendingMoves = make([]lib2048.Move, 0)
+					for _, b := range pendingBuckets {
+						b.span.SetAttr("outcome", "dropped for a new game")
+						b.span.End()
+					}
+					pendingBuckets = make([]pendingBucket, 0)
+					currentBucketSize = 0
+					gs.gameMutex.Lock()
+					gs.roundStart = time.Time{}
+					gs.tally = nil
+					gs.gameMutex.Unlock()
+				}
+				continue
+			}
+			if !gs.isPlaying() {
+				continue // no game to vote on until the next one is agreed upon
+			}
 			moves := proposal.Moves
+			bucket := gs.tracer.StartChild("gameserver.bucket", proposal.Trace)
+			bucket.SetAttr("slot", gs.nextSlot-1)
+			bucket.SetAttr("votes", len(moves))
+			pendingBuckets = append(pendingBuckets, pendingBucket{bucket, len(moves)})
 
 			pendingMoves = append(pendingMoves, moves...)
+			gs.gameMutex.Lock()
+			gs.tally = countVotes(pendingMoves)
+			gs.gameMutex.Unlock()
 			if currentBucketSize == 0 {
 				currentBucketSize = len(moves)
 			} else {
@@ -242,13 +525,24 @@ func (gs *gameServer) processMoves() {
 				dirVotes[lib2048.Right] = 0
 				pendingMovesSubset := pendingMoves[:requiredMoves]
 				pendingMoves = pendingMoves[requiredMoves:]
+
+				// The move follows from every value whose votes it counts
+				moveSpan := gs.tracer.Start("gameserver.move", libtrace.SpanContext{})
+				for counted := requiredMoves; counted > 0 && len(pendingBuckets) > 0; {
+					b := &pendingBuckets[0]
+					moveSpan.Link(b.span.Context())
+					if b.left > counted {
+						b.left -= counted
+						break
+					}
+					counted -= b.left
+					b.span.End()
+					pendingBuckets = pendingBuckets[1:]
+				}
 				for _, move := range pendingMovesSubset {
 					dirVotes[move.Direction]++
 				}
 
-				LOGV.Println("GAME SERVER", gs.id, "currentBucketSize", currentBucketSize)
-				LOGV.Println("GAME SERVER", gs.id, "pendingMovesSubset", util.MovesString(pendingMovesSubset))
-
 				var majorityDir lib2048.Direction
 				maxVotes := 0
 				for dir, votes := range dirVotes {
@@ -260,18 +554,35 @@ func (gs *gameServer) processMoves() {
 					}
 				}
 
-				LOGV.Println("GAME SERVER", gs.id, "got majority direction:", majorityDir)
+				gs.log.Debug("votes counted", "direction", majorityDir, "votes", len(pendingMovesSubset), "bucket", currentBucketSize)
 
 				// Update the 2048 state
+				gs.gameMutex.Lock()
 				gs.game2048.MakeMove(majorityDir)
-				if gs.game2048.IsGameOver() {
-					gs.game2048 = lib2048.NewGame2048()
+				gs.tally = countVotes(pendingMoves)
+				gs.seq++
+				gs.recordMove(majorityDir, dirVotes)
+				gs.history.AddChange(libhistory.Change{gs.seq, majorityDir, gs.game2048.GetState()})
+				gs.metrics.moves.Inc()
+				if !gs.roundStart.IsZero() {
+					gs.metrics.voteRounds.Observe(time.Since(gs.roundStart).Seconds())
+					gs.roundStart = time.Time{}
 				}
-
 				state := gs.getWrappedState(&majorityDir)
-
+				gameOver := gs.game2048.IsGameOver()
+				gameNumber := gs.gameNumber
+				gs.gameMutex.Unlock()
+
+				moveSpan.SetAttr("direction", majorityDir)
+				moveSpan.SetAttr("seq", state.Seq)
+				moveSpan.SetAttr("votes", len(pendingMovesSubset))
+				moveSpan.End()
 				gs.stateBroadcastCh <- state
 
+				if gameOver {
+					gs.proposeNewGame(gameNumber + 1)
+				}
+
 				// Update the bucket size
 				if len(sizeQueue) > 0 {
 					currentBucketSize = sizeQueue[0]
@@ -287,14 +598,16 @@ func (gs *gameServer) processMoves() {
 func (gs *gameServer) clientTasker() {
 	for {
 		select {
+		case <-gs.closing:
+			return
 		case state := <-gs.stateBroadcastCh:
 			buf, _ := json.Marshal(*state)
-			LOGV.Printf("GAME SERVER %d sending to client\n%s\n", gs.id, state.String())
+			gs.log.Debug("sending state to clients", "seq", state.Seq, "score", state.Score)
 			gs.clientsMutex.Lock()
 			for _, c := range gs.clients {
 				err := websocket.Message.Send(c.conn, string(buf))
 				if err != nil {
-					LOGE.Println(err)
+					gs.log.Warn("could not send state", "client", c.id, "err", err)
 				}
 			}
 			gs.clientsMutex.Unlock()
@@ -303,46 +616,198 @@ func (gs *gameServer) clientTasker() {
 }
 
 func (gs *gameServer) ListenForClients() {
-	LOGV.Println("Listening for connection from new clients")
 
 	// websocket handler
 	onConnected := func(ws *websocket.Conn) {
-		LOGV.Println("Client has connected")
-
 		// client has been connected: add the client to the list
 		gs.clientsMutex.Lock()
-		c := &client{gs.numClients, ws}
+		if gs.draining {
+			gs.clientsMutex.Unlock()
+			gs.log.Debug("turning away client while draining", "addr", ws.Request().RemoteAddr)
+			ws.Close()
+			return
+		}
+		session, ip := clientIdentity(ws.Request().URL.Query().Get("session"), ws.Request().RemoteAddr)
+		c := &client{gs.numClients, ws, session, ip}
 		gs.clients[gs.numClients] = c
+		gs.clientsWG.Add(1)
+		defer gs.clientsWG.Done()
 		id := gs.numClients
+		log := gs.log.With("client", id)
+		log.Debug("client connected", "addr", ws.Request().RemoteAddr)
+
+		// A session votes from its newest connection only
+		if old := gs.limiter.connect(c); old != nil {
+			log.Info("session connected again, hanging up on its old connection", "session", session, "old", old.id)
+			old.conn.Close()
+		}
 
 		// Remove from map when dead
 		defer func() {
-			LOGV.Println("Lost connection to client", id)
+			log.Debug("client disconnected")
+			gs.limiter.disconnect(c)
 			gs.clientsMutex.Lock()
 			delete(gs.clients, id)
+			gs.metrics.clients.Set(float64(len(gs.clients)))
 			gs.clientsMutex.Unlock()
 		}()
 
 		gs.numClients += 1
+		gs.metrics.clients.Set(float64(len(gs.clients)))
 		gs.clientsMutex.Unlock()
 
-		// Send it the state
-		state := gs.getWrappedState(nil)
-		buf, _ := json.Marshal(*state)
-		err := websocket.Message.Send(ws, string(buf))
-		if err != nil {
-			LOGE.Println(err)
+		// Send it the state, if the first game has started
+		gs.gameMutex.Lock()
+		var state *util.Game2048State
+		if gs.game2048 != nil {
+			state = gs.getWrappedState(nil)
+		}
+		gs.gameMutex.Unlock()
+		if state != nil {
+			buf, _ := json.Marshal(*state)
+			err := websocket.Message.Send(ws, string(buf))
+			if err != nil {
+				log.Warn("could not send state", "err", err)
+			}
 		}
 
-		gs.clientListenRead(ws)
+		gs.clientListenRead(c, log)
+	}
+	gs.mux.Handle(gs.pattern, websocket.Handler(onConnected))
+}
+
+// hint is the move the advisor suggested for the board at seq. It is worked
+// out once for each board, however many clients ask for it.
+type hint struct {
+	mutex sync.Mutex // held while the advisor works it out
+	seq   uint64     // 0 before the first hint
+	dir   lib2048.Direction
+	ok    bool // whether any move changes the board
+}
+
+// sendHint tells a client which move the AI would make on the current board.
+func (gs *gameServer) sendHint(ws *websocket.Conn, log *slog.Logger) {
+	gs.gameMutex.Lock()
+	if gs.game2048 == nil {
+		gs.gameMutex.Unlock()
+		return
+	}
+	seq := gs.seq
+	game := lib2048.NewGame2048()
+	game.CloneFrom(gs.game2048)
+	gs.gameMutex.Unlock()
+
+	gs.hint.mutex.Lock()
+	if gs.hint.seq < seq {
+		gs.hint.dir, gs.hint.ok = gs.advisor.SuggestMove(game)
+		gs.hint.seq = seq
+		gs.metrics.hints.Inc()
+	}
+	dir, ok, seq := gs.hint.dir, gs.hint.ok, gs.hint.seq
+	gs.hint.mutex.Unlock()
+	if !ok {
+		return
+	}
+	buf, _ := json.Marshal(util.HintMessage{util.DirectionToClient(dir), seq})
+	if err := websocket.Message.Send(ws, string(buf)); err != nil {
+		log.Warn("could not send hint", "err", err)
+	}
+}
+
+// proposeNewGame suggests a seed for the given game number to the other game
+// servers. Every server proposes one, and the first to be decided is used.
+func (gs *gameServer) proposeNewGame(gameNumber uint32) {
+	gs.proposeGame(gameNumber, gs.gameOptions)
+}
+
+// proposeGame proposes the given options for the given game number, picking
+// a random seed if theirs is 0.
+func (gs *gameServer) proposeGame(gameNumber uint32, options lib2048.Options) {
+	if options.Seed == 0 {
+		options.Seed = rand.New(rand.NewSource(time.Now().UnixNano())).Uint32()
+	}
+	gs.log.Debug("proposing new game", "game", gameNumber, "seed", options.Seed)
+	gs.libpaxos.Propose(&paxosrpc.ProposalValue{NewGame: &paxosrpc.NewGameProposal{gameNumber, options}})
+}
+
+// startGame replaces the current game with a new one, if the decided
+// proposal is for the next game. It returns whether a new game was started.
+func (gs *gameServer) startGame(newGame *paxosrpc.NewGameProposal) bool {
+	gs.gameMutex.Lock()
+	if newGame.GameNumber != gs.gameNumber+1 {
+		// Somebody else's seed for this game was decided first
+		gs.gameMutex.Unlock()
+		return false
+	}
+	gs.gameNumber = newGame.GameNumber
+	gs.game2048 = lib2048.NewGame2048WithOptions(newGame.Options)
+	gs.seq++
+	gs.recordNewGame()
+	gs.history.AddChange(libhistory.Change{gs.seq, 0, gs.game2048.GetState()})
+	state := gs.getWrappedState(nil)
+	gs.gameMutex.Unlock()
+
+	gs.log.Info("started game", "game", newGame.GameNumber, "seed", newGame.Options.Seed)
+	gs.stateBroadcastCh <- state
+	return true
+}
+
+// isPlaying returns whether there is a game in progress that votes can be
+// applied to.
+func (gs *gameServer) isPlaying() bool {
+	gs.gameMutex.Lock()
+	defer gs.gameMutex.Unlock()
+	return gs.game2048 != nil && !gs.game2048.IsGameOver()
+}
+
+// recordNewGame starts a new replay file for the current game, if replays are
+// being recorded.
+func (gs *gameServer) recordNewGame() {
+	if gs.recorder == nil {
+		return
+	}
+	if err := gs.recorder.StartGame(gs.id, gs.game2048); err != nil {
+		gs.log.Error("could not start replay", "err", err)
+	}
+}
+
+// recordMove adds the direction that was just applied to the current game to
+// its replay, if replays are being recorded.
+func (gs *gameServer) recordMove(dir lib2048.Direction, votes map[lib2048.Direction]int) {
+	if gs.recorder == nil {
+		return
+	}
+	if err := gs.recorder.RecordMove(dir, votes, gs.game2048); err != nil {
+		gs.log.Error("could not record move", "err", err)
+	}
+}
+
+// countVotes counts the given votes by direction.
+func countVotes(moves []lib2048.Move) map[lib2048.Direction]int {
+	votes := make(map[lib2048.Direction]int)
+	for _, move := range moves {
+		votes[move.Direction]++
+	}
+	return votes
+}
+
+// describeValue describes a decided value for the history.
+func describeValue(value *paxosrpc.ProposalValue) string {
+	if value.NewGame != nil {
+		return fmt.Sprintf("new game %d (seed %d)", value.NewGame.GameNumber, value.NewGame.Options.Seed)
+	}
+	dirs := make([]string, len(value.Moves))
+	for i, move := range value.Moves {
+		dirs[i] = move.Direction.String()
 	}
-	http.Handle(gs.pattern, websocket.Handler(onConnected))
+	return "votes [" + strings.Join(dirs, " ") + "]"
 }
 
 func (gs *gameServer) TestAddVote(moves []lib2048.Move) {
-	gs.libpaxos.Propose(&paxosrpc.ProposalValue{moves})
+	gs.libpaxos.Propose(&paxosrpc.ProposalValue{Moves: moves})
 }
 
+// getWrappedState must be called with the gameMutex held.
 func (gs *gameServer) getWrappedState(dir *lib2048.Direction) *util.Game2048State {
 	tomove := ""
 	if dir != nil {
@@ -359,10 +824,10 @@ func (gs *gameServer) getWrappedState(dir *lib2048.Direction) *util.Game2048Stat
 		}
 	}
 	return &util.Game2048State{
+		State: gs.game2048.GetState(),
 		Won:   gs.game2048.IsGameWon(),
 		Over:  gs.game2048.IsGameOver(),
-		Grid:  gs.game2048.GetBoard(),
-		Score: gs.game2048.GetScore(),
 		Consensus: tomove,
+		Seq: gs.seq,
 	}
 }
@@ -0,0 +1,280 @@
+package gameserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VOTES_PER_CLIENT   = 1    // default for VotesPerClient
+	VOTES_PER_IP       = 20   // default for VotesPerIP
+	DOWNWEIGHT         = 0.25 // default for DownweightFactor
+	PENALTY_TIME       = 600  // default for PenaltyTime, in seconds
+	MAX_SESSION_LENGTH = 64   // of the session IDs that clients give
+)
+
+// Penalty is what happens to a client that goes over its vote limit, besides
+// its extra votes being ignored.
+type Penalty int
+
+const (
+	PenaltyNone       Penalty = iota // nothing else
+	PenaltyDrop                      // it is hung up on
+	PenaltyDownweight                // its later votes count for DownweightFactor of a vote each
+)
+
+func (p Penalty) String() string {
+	switch p {
+	case PenaltyNone:
+		return "none"
+	case PenaltyDrop:
+		return "drop"
+	case PenaltyDownweight:
+		return "downweight"
+	}
+	return fmt.Sprintf("Penalty(%d)", int(p))
+}
+
+func ParsePenalty(s string) (Penalty, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return PenaltyNone, nil
+	case "drop":
+		return PenaltyDrop, nil
+	case "downweight":
+		return PenaltyDownweight, nil
+	}
+	return PenaltyNone, fmt.Errorf("unknown penalty %q, expected none, drop or downweight", s)
+}
+
+// These may only be changed before any game server starts.
+var (
+	// VotesPerClient is how many votes a client may make in each round, that
+	// is between two proposals of its game server, or 0 for no limit. The
+	// connections of a session share its limit.
+	VotesPerClient = VOTES_PER_CLIENT
+	// VotesPerIP is how many votes the clients at one IP address may make
+	// between them in each round, or 0 for no limit. Since a client may give
+	// any session it likes, this is what limits a client with many
+	// connections.
+	VotesPerIP = VOTES_PER_IP
+	// LimitPenalty is what happens to clients that go over a limit.
+	LimitPenalty = PenaltyNone
+	// DownweightFactor is what each vote of a client counts for once it has
+	// gone over a limit, if LimitPenalty is PenaltyDownweight. Only enough of
+	// its votes to add up to whole votes are proposed.
+	DownweightFactor = DOWNWEIGHT
+	// PenaltyTime is how long a session stays down-weighted after it last
+	// went over a limit, whether or not it stays connected.
+	PenaltyTime = PENALTY_TIME * time.Second
+)
+
+// verdict is what a vote limiter decides to do with a vote.
+type verdict int
+
+const (
+	voteAccepted     verdict = iota
+	voteDownweighted         // counted for less than a vote, and not proposed
+	voteOverClientLimit
+	voteOverIPLimit
+)
+
+// reason returns the label that rejected votes are counted under.
+func (v verdict) reason() string {
+	if v == voteOverIPLimit {
+		return "ip_limit"
+	}
+	return "client_limit"
+}
+
+// voter counts the votes of a session, or of an IP address, in a round.
+type voter struct {
+	conns  int     // connections open
+	newest *client // of a session
+	round  uint64  // that votes were last counted in
+	votes  int     // in that round
+}
+
+// penalty is kept for a client that is down-weighted.
+type penalty struct {
+	until  time.Time // when it expires
+	credit float64   // weight of the down-weighted votes not yet proposed
+}
+
+func (v *voter) count(round uint64) int {
+	if v.round != round {
+		v.round, v.votes = round, 0
+	}
+	return v.votes
+}
+
+// voteLimiter keeps clients to their vote limits, and notices sessions that
+// connect more than once.
+type voteLimiter struct {
+	perClient   int
+	perIP       int
+	penalty     Penalty
+	weight      float64
+	penaltyTime time.Duration
+	metrics     *gameServerMetrics
+
+	mutex     sync.Mutex
+	round     uint64
+	sessions  map[string]*voter
+	ips       map[string]*voter
+	penalties map[string]*penalty // by penaltyKey, outliving connections
+}
+
+func newVoteLimiter(metrics *gameServerMetrics) *voteLimiter {
+	return &voteLimiter{
+		perClient:   VotesPerClient,
+		perIP:       VotesPerIP,
+		penalty:     LimitPenalty,
+		weight:      DownweightFactor,
+		penaltyTime: PenaltyTime,
+		metrics:     metrics,
+		sessions:    make(map[string]*voter),
+		ips:         make(map[string]*voter),
+		penalties:   make(map[string]*penalty),
+	}
+}
+
+// clientIdentity returns the session and the IP address of a client that
+// connected with the given session ID and remote address. Clients that give
+// no valid session share one session with the others at their IP address, so
+// that opening more connections gets them no more votes.
+func clientIdentity(session, remoteAddr string) (string, string) {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	if session == "" || len(session) > MAX_SESSION_LENGTH || anonymous(session) {
+		session = "#" + ip
+	}
+	return session, ip
+}
+
+// anonymous returns whether session is shared by the clients at an IP
+// address that gave none.
+func anonymous(session string) bool {
+	return strings.HasPrefix(session, "#")
+}
+
+// penaltyKey returns what the penalty of c is kept under: its session, so
+// that it cannot get away from one by connecting again, or, for an anonymous
+// session, its connection, so that one client does not get every other
+// client behind the same NAT penalized.
+func penaltyKey(c *client) string {
+	if anonymous(c.session) {
+		return fmt.Sprintf("%s/%d", c.session, c.id)
+	}
+	return c.session
+}
+
+// nextRound starts a new round, once the votes of the last one are proposed,
+// and forgets the penalties that have expired.
+func (l *voteLimiter) nextRound() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.round++
+	now := time.Now()
+	for key, p := range l.penalties {
+		if now.After(p.until) {
+			delete(l.penalties, key)
+		}
+	}
+}
+
+// connect counts a new connection, and returns the connection of the same
+// session that it replaces, if any, which the caller hangs up on. The
+// connections of an anonymous session do not replace each other.
+func (l *voteLimiter) connect(c *client) *client {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	s, ok := l.sessions[c.session]
+	if !ok {
+		s = &voter{}
+		l.sessions[c.session] = s
+	}
+	var replaced *client
+	if !anonymous(c.session) {
+		replaced, s.newest = s.newest, c
+	}
+	s.conns++
+	if replaced != nil {
+		l.metrics.duplicateSessions.Inc()
+	}
+	ip, ok := l.ips[c.ip]
+	if !ok {
+		ip = &voter{}
+		l.ips[c.ip] = ip
+	}
+	ip.conns++
+	return replaced
+}
+
+// disconnect forgets a connection, and the session and the IP address it
+// was from if it was their last.
+func (l *voteLimiter) disconnect(c *client) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if s := l.sessions[c.session]; s != nil {
+		if s.newest == c {
+			s.newest = nil
+		}
+		if s.conns--; s.conns == 0 {
+			delete(l.sessions, c.session)
+		}
+	}
+	if ip := l.ips[c.ip]; ip != nil {
+		if ip.conns--; ip.conns == 0 {
+			delete(l.ips, c.ip)
+		}
+	}
+	// Nobody else has the penalty key of an anonymous connection
+	if anonymous(c.session) {
+		delete(l.penalties, penaltyKey(c))
+	}
+}
+
+// vote decides what to do with a vote from c, and counts it if it is within
+// the limits.
+func (l *voteLimiter) vote(c *client) verdict {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	s, ip := l.sessions[c.session], l.ips[c.ip]
+	result := voteAccepted
+	if l.perClient > 0 && s.count(l.round) >= l.perClient {
+		result = voteOverClientLimit
+	} else if l.perIP > 0 && ip.count(l.round) >= l.perIP {
+		result = voteOverIPLimit
+	}
+	if result != voteAccepted {
+		l.metrics.votesRejected.Inc(result.reason())
+		if l.penalty == PenaltyDownweight {
+			key := penaltyKey(c)
+			if l.penalties[key] == nil {
+				l.penalties[key] = &penalty{}
+			}
+			l.penalties[key].until = time.Now().Add(l.penaltyTime)
+		}
+		return result
+	}
+
+	s.count(l.round)
+	ip.count(l.round)
+	s.votes++
+	ip.votes++
+	if p := l.penalties[penaltyKey(c)]; p != nil {
+		p.credit += l.weight
+		if p.credit < 1 {
+			l.metrics.votesDownweighted.Inc()
+			return voteDownweighted
+		}
+		p.credit--
+	}
+	return voteAccepted
+}
@@ -0,0 +1,38 @@
+package gameserver
+
+import (
+	"distributed2048/libmetrics"
+)
+
+type gameServerMetrics struct {
+	registry   *libmetrics.Registry
+	clients    *libmetrics.Gauge
+	votes      *libmetrics.Counter
+	moves      *libmetrics.Counter
+	voteRounds *libmetrics.Histogram
+
+	votesRejected     *libmetrics.Counter
+	votesDownweighted *libmetrics.Counter
+	duplicateSessions *libmetrics.Counter
+	clientsDropped    *libmetrics.Counter
+
+	hints *libmetrics.Counter
+}
+
+func newGameServerMetrics() *gameServerMetrics {
+	r := libmetrics.NewRegistry()
+	return &gameServerMetrics{
+		registry:   r,
+		clients:    r.NewGauge("gameserver_websocket_clients", "Websocket clients connected."),
+		votes:      r.NewCounter("gameserver_votes_total", "Votes received from this game server's clients.", "direction"),
+		moves:      r.NewCounter("gameserver_moves_total", "Moves the cluster agreed on and this game server applied."),
+		voteRounds: r.NewHistogram("gameserver_vote_round_seconds", "Time from the first vote this game server received in a round until the move it led to was applied.", libmetrics.DEFAULT_BUCKETS),
+
+		votesRejected:     r.NewCounter("gameserver_votes_rejected_total", "Votes ignored for going over a vote limit.", "reason"),
+		votesDownweighted: r.NewCounter("gameserver_votes_downweighted_total", "Votes of clients over a vote limit that were counted for less than a vote, and not proposed."),
+		duplicateSessions: r.NewCounter("gameserver_duplicate_sessions_total", "Connections from a session that was already connected, which replaced its old connection."),
+		clientsDropped:    r.NewCounter("gameserver_clients_dropped_total", "Clients hung up on for going over a vote limit."),
+
+		hints: r.NewCounter("gameserver_hints_computed_total", "Boards the advisor worked out a hint for, once each however many clients asked."),
+	}
+}
@@ -24,18 +24,20 @@ func NewMove(dir Direction) *Move {
 	return &Move{time.Now(), dir}
 }
 
-func (m *Move) String() string {
-	var moveString string
-	switch m.Direction {
+func (d Direction) String() string {
+	switch d {
 	case Up:
-		moveString = "Up"
+		return "Up"
 	case Down:
-		moveString = "Down"
+		return "Down"
 	case Left:
-		moveString = "Left"
+		return "Left"
 	case Right:
-		moveString = "Right"
+		return "Right"
 	}
+	return ""
+}
 
-	return fmt.Sprintf("'%s': %s", m.Time.Format(layout), moveString)
+func (m *Move) String() string {
+	return fmt.Sprintf("'%s': %s", m.Time.Format(layout), m.Direction.String())
 }
@@ -12,13 +12,22 @@ const (
 	InitialTileCount         = 2
 	InitialTileDoublePercent = 10
 	EachTurnNewTileCount     = 1
+	DefaultSeed              = 15440
 )
 
 type Game2048 interface {
 	MakeMove(dir Direction)
+	// Slide returns the board and score that moving in dir would give
+	// before a new tile is added, and whether any tile would move. The game
+	// itself is not changed.
+	Slide(dir Direction) (Grid, int, bool)
 	GetScore() int
 	GetBoard() Grid
-	GetRand() *libsimplerand.SimpleRand
+	GetRand() libsimplerand.Rand
+	GetSeed() uint32
+	GetOptions() Options
+	// GetMoveCount returns how many moves have changed the board.
+	GetMoveCount() int
 	IsGameOver() bool
 	IsGameWon() bool
 	String() string
@@ -26,4 +35,23 @@ type Game2048 interface {
 	SetGrid(grid Grid)
 	SetScore(score int)
 	CloneFrom(game Game2048)
+
+	// GetState takes a complete snapshot of the game, from which it can be
+	// restored exactly with SetState or NewGame2048FromState.
+	GetState() State
+	SetState(state State) error
+
+	// The binary and JSON encodings hold the same snapshot as GetState, and
+	// are what should be used to move games between processes.
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+	MarshalJSON() ([]byte, error)
+	UnmarshalJSON(data []byte) error
+}
+
+// Options are chosen when a game is created, and never change afterwards.
+type Options struct {
+	Seed    uint32
+	Rand    libsimplerand.Kind // generator the tiles are drawn from
+	Variant Variant            // rules the game is played with
 }
@@ -10,21 +10,65 @@ import (
 type Grid [BoardLen][BoardLen]int
 
 type game struct {
-	grid  Grid
-	score int
-	r     *libsimplerand.SimpleRand
+	grid      Grid
+	score     int
+	options   Options
+	moveCount int
+	r         libsimplerand.Rand
 }
 
 func NewGame2048() Game2048 {
+	return NewSeededGame2048(DefaultSeed)
+}
+
+// NewSeededGame2048 starts a new game whose tiles are drawn from a random
+// number generator initialized with seed. Two games with the same seed that
+// are given the same sequence of moves always end up in the same state.
+func NewSeededGame2048(seed uint32) Game2048 {
+	return NewGame2048WithOptions(Options{Seed: seed})
+}
+
+// NewGame2048WithOptions starts a new game with the given options. It panics
+// if they name an unknown random number generator; use ValidateOptions to
+// check options that come from outside.
+func NewGame2048WithOptions(options Options) Game2048 {
+	r, err := libsimplerand.New(options.Rand, options.Seed)
+	if err != nil {
+		panic(err)
+	}
 	g := &game{
-		score: 0,
-		r:     libsimplerand.NewSimpleRand(15440),
+		score:   0,
+		options: options,
+		r:       r,
 	}
 	g.reset()
+	g.placeBlockers()
 	g.newRound(InitialTileCount)
 	return g
 }
 
+// NewGame2048FromState restores a game from a snapshot taken with GetState.
+func NewGame2048FromState(state State) (Game2048, error) {
+	g := &game{}
+	if err := g.SetState(state); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// ValidateOptions checks that a game can be created with the given options.
+func ValidateOptions(options Options) error {
+	if _, err := libsimplerand.New(options.Rand, options.Seed); err != nil {
+		return err
+	}
+	for _, v := range variants {
+		if options.Variant == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown game variant %d", int(options.Variant))
+}
+
 func (g *game) MakeMove(dir Direction) {
 	if !g.canMove() {
 		return
@@ -34,11 +78,25 @@ func (g *game) MakeMove(dir Direction) {
 	c := g.move(dir)
 
 	if a || b || c {
+		g.moveCount++
 		g.newRound(EachTurnNewTileCount)
 	}
 
 }
 
+func (g *game) Slide(dir Direction) (Grid, int, bool) {
+	return Slide(g.options, g.grid, dir)
+}
+
+// Slide is the same as Game2048.Slide, for a board on its own.
+func Slide(options Options, grid Grid, dir Direction) (Grid, int, bool) {
+	g := &game{grid: grid, options: options}
+	a := g.move(dir)
+	b := g.merge(dir)
+	c := g.move(dir)
+	return g.grid, g.score, a || b || c
+}
+
 func (g *game) GetScore() int {
 	return g.score
 }
@@ -47,16 +105,28 @@ func (g *game) GetBoard() Grid {
 	return g.grid
 }
 
-func (g *game) GetRand() *libsimplerand.SimpleRand {
+func (g *game) GetRand() libsimplerand.Rand {
 	return g.r
 }
 
+func (g *game) GetSeed() uint32 {
+	return g.options.Seed
+}
+
+func (g *game) GetOptions() Options {
+	return g.options
+}
+
+func (g *game) GetMoveCount() int {
+	return g.moveCount
+}
+
 func (g *game) IsGameOver() bool {
 	return g.IsGameWon() || !g.canMove()
 }
 
 func (g *game) IsGameWon() bool {
-	return g.getLargest() == 2048
+	return g.getLargest() >= g.rules().winningTile
 }
 
 func (g *game) String() string {
@@ -109,7 +179,10 @@ func (g *game) CloneFrom(other Game2048) {
 		}
 	}
 	g.score = other.GetScore()
-	g.r = other.GetRand()
+	g.options = other.GetOptions()
+	g.moveCount = other.GetMoveCount()
+	g.r, _ = libsimplerand.New(g.options.Rand, g.options.Seed)
+	g.r.SetState(other.GetRand().GetState())
 }
 
 func (g *game) newRound(numNewTiles int) {
@@ -118,9 +191,9 @@ func (g *game) newRound(numNewTiles int) {
 		if x == -1 && y == -1 {
 			return // Board full
 		}
-		g.grid[y][x] = FirstTileValue
+		g.grid[y][x] = g.rules().firstTile
 		if g.shouldInitialValueBeDouble() {
-			g.grid[y][x] = FirstTileValue * 2
+			g.grid[y][x] = g.rules().bonusTile
 		}
 	}
 }
@@ -134,6 +207,21 @@ func (g *game) reset() {
 	}
 }
 
+func (g *game) rules() *rules {
+	return rulesFor(g.options.Variant)
+}
+
+// Puts the variant's blockers on empty cells
+func (g *game) placeBlockers() {
+	for i := 0; i < g.rules().blockerCount; i++ {
+		y, x := g.randomEmptyPos()
+		if x == -1 && y == -1 {
+			return
+		}
+		g.grid[y][x] = Blocker
+	}
+}
+
 func (g *game) isOutside(row, col int) bool {
 	return row < 0 || col < 0 || row >= BoardLen || col >= BoardLen
 }
@@ -147,20 +235,21 @@ func (g *game) canMove() bool {
 			}
 
 			value := g.grid[row][col]
+			merges := g.rules().merges
 			// NORTH
-			if g.get(row-1, col) == value {
+			if merges(value, g.get(row-1, col)) {
 				return true
 			}
 			// SOUTH
-			if g.get(row+1, col) == value {
+			if merges(value, g.get(row+1, col)) {
 				return true
 			}
 			// EAST
-			if g.get(row, col+1) == value {
+			if merges(value, g.get(row, col+1)) {
 				return true
 			}
 			// WEST
-			if g.get(row, col-1) == value {
+			if merges(value, g.get(row, col-1)) {
 				return true
 			}
 		}
@@ -211,8 +300,8 @@ func (g *game) move(dir Direction) bool {
 	case Down:
 		for x := 0; x < BoardLen; x++ {
 			for y := BoardLen - 1; y >= 0; y-- {
-				// Don't move empty spaces
-				if g.grid[y][x] == 0 {
+				// Don't move empty spaces or blockers
+				if g.grid[y][x] == 0 || g.grid[y][x] == Blocker {
 					continue
 				}
 
@@ -234,8 +323,8 @@ func (g *game) move(dir Direction) bool {
 	case Up:
 		for x := 0; x < BoardLen; x++ {
 			for y := 0; y < BoardLen; y++ {
-				// Don't move empty spaces
-				if g.grid[y][x] == 0 {
+				// Don't move empty spaces or blockers
+				if g.grid[y][x] == 0 || g.grid[y][x] == Blocker {
 					continue
 				}
 
@@ -257,8 +346,8 @@ func (g *game) move(dir Direction) bool {
 	case Left:
 		for x := 0; x < BoardLen; x++ {
 			for y := 0; y < BoardLen; y++ {
-				// Don't move empty spaces
-				if g.grid[y][x] == 0 {
+				// Don't move empty spaces or blockers
+				if g.grid[y][x] == 0 || g.grid[y][x] == Blocker {
 					continue
 				}
 
@@ -279,8 +368,8 @@ func (g *game) move(dir Direction) bool {
 	case Right:
 		for x := BoardLen - 1; x >= 0; x-- {
 			for y := 0; y < BoardLen; y++ {
-				// Don't move empty spaces
-				if g.grid[y][x] == 0 {
+				// Don't move empty spaces or blockers
+				if g.grid[y][x] == 0 || g.grid[y][x] == Blocker {
 					continue
 				}
 
@@ -318,8 +407,8 @@ func (g *game) merge(dir Direction) bool {
 				nextY, value := y+1, g.grid[y][x]
 				merge_value := g.get(nextY, x)
 
-				if value == merge_value {
-					new_value := value + value
+				if g.rules().merges(value, merge_value) {
+					new_value := g.rules().merged(value, merge_value)
 
 					g.grid[y][x] = 0
 					g.grid[nextY][x] = new_value
@@ -344,8 +433,8 @@ func (g *game) merge(dir Direction) bool {
 				value := g.grid[y][x]
 				merge_value := g.get(nextY, x)
 
-				if value == merge_value {
-					new_value := value + value
+				if g.rules().merges(value, merge_value) {
+					new_value := g.rules().merged(value, merge_value)
 
 					g.grid[y][x] = 0
 					g.grid[nextY][x] = new_value
@@ -370,8 +459,8 @@ func (g *game) merge(dir Direction) bool {
 				value := g.grid[y][x]
 				merge_value := g.get(y, nextX)
 
-				if value == merge_value {
-					new_value := value + value
+				if g.rules().merges(value, merge_value) {
+					new_value := g.rules().merged(value, merge_value)
 
 					g.grid[y][x] = 0
 					g.grid[y][nextX] = new_value
@@ -396,8 +485,8 @@ func (g *game) merge(dir Direction) bool {
 				value := g.grid[y][x]
 				merge_value := g.get(y, nextX)
 
-				if value == merge_value {
-					new_value := value + value
+				if g.rules().merges(value, merge_value) {
+					new_value := g.rules().merged(value, merge_value)
 
 					g.grid[y][x] = 0
 					g.grid[y][nextX] = new_value
@@ -428,9 +517,9 @@ func (g *game) randomEmptyPos() (int, int) {
 }
 
 func (g *game) randPos() int {
-	return g.r.Int() % BoardLen
+	return g.r.Intn(BoardLen)
 }
 
 func (g *game) shouldInitialValueBeDouble() bool {
-	return g.r.Int()%100 < InitialTileDoublePercent
+	return g.r.Intn(100) < g.rules().bonusPercent
 }
@@ -0,0 +1,25 @@
+package lib2048
+
+import (
+	"testing"
+)
+
+func TestMoveCountOnlyCountsMoves(t *testing.T) {
+	g := NewSeededGame2048(28)
+	g.SetGrid(Grid{
+		{2, 4, 8, 16},
+		{0, 0, 0, 0},
+		{0, 0, 0, 0},
+		{0, 0, 0, 0},
+	})
+	for i := 0; i < 3; i++ {
+		g.MakeMove(Up)
+	}
+	if n := g.GetMoveCount(); n != 0 {
+		t.Errorf("Moves that changed nothing counted as %d moves", n)
+	}
+	g.MakeMove(Down)
+	if n := g.GetMoveCount(); n != 1 {
+		t.Errorf("One move counted as %d", n)
+	}
+}
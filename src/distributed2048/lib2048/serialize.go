@@ -0,0 +1,140 @@
+package lib2048
+
+import (
+	"bytes"
+	"distributed2048/libsimplerand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// StateVersion is bumped every time the contents of a snapshot change, so
+// that a snapshot taken by an older server can be told apart.
+const StateVersion = 1
+
+var binaryMagic = [4]byte{'2', '0', '4', '8'}
+
+// State is a complete snapshot of a game: restoring it gives back a game
+// that behaves identically from then on, including the tiles it will draw.
+type State struct {
+	Version   int
+	Options   Options
+	Grid      Grid
+	Score     int
+	Rand      uint64 // current state of the random number generator
+	MoveCount int
+}
+
+// binaryState is the layout of a binary snapshot. All values are
+// stored big endian.
+type binaryState struct {
+	Magic     [4]byte
+	Version   uint8
+	BoardLen  uint8
+	RandKind  uint8
+	Variant   uint8
+	Seed      uint32
+	Grid      [BoardLen][BoardLen]int32
+	Score     int64
+	Rand      uint64
+	MoveCount uint64
+}
+
+func (g *game) GetState() State {
+	return State{
+		Version:   StateVersion,
+		Options:   g.options,
+		Grid:      g.grid,
+		Score:     g.score,
+		Rand:      g.r.GetState(),
+		MoveCount: g.moveCount,
+	}
+}
+
+func (g *game) SetState(state State) error {
+	if state.Version != StateVersion {
+		return fmt.Errorf("unsupported game state version %d", state.Version)
+	}
+	if err := ValidateOptions(state.Options); err != nil {
+		return err
+	}
+	r, _ := libsimplerand.New(state.Options.Rand, state.Options.Seed)
+	r.SetState(state.Rand)
+
+	g.options = state.Options
+	g.SetGrid(state.Grid)
+	g.score = state.Score
+	g.r = r
+	g.moveCount = state.MoveCount
+	return nil
+}
+
+func (g *game) MarshalBinary() ([]byte, error) {
+	state := g.GetState()
+	bs := binaryState{
+		Magic:     binaryMagic,
+		Version:   uint8(state.Version),
+		BoardLen:  BoardLen,
+		RandKind:  uint8(state.Options.Rand),
+		Variant:   uint8(state.Options.Variant),
+		Seed:      state.Options.Seed,
+		Score:     int64(state.Score),
+		Rand:      state.Rand,
+		MoveCount: uint64(state.MoveCount),
+	}
+	for row := 0; row < BoardLen; row++ {
+		for col := 0; col < BoardLen; col++ {
+			bs.Grid[row][col] = int32(state.Grid[row][col])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, &bs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *game) UnmarshalBinary(data []byte) error {
+	if len(data) < len(binaryMagic)+1 || !bytes.Equal(data[:len(binaryMagic)], binaryMagic[:]) {
+		return errors.New("not a 2048 game snapshot")
+	}
+
+	if version := int(data[len(binaryMagic)]); version != StateVersion {
+		return fmt.Errorf("unsupported game state version %d", version)
+	}
+	var bs binaryState
+	if err := binary.Read(bytes.NewReader(data), binary.BigEndian, &bs); err != nil {
+		return err
+	}
+	if bs.BoardLen != BoardLen {
+		return fmt.Errorf("snapshot is for a %dx%d board", bs.BoardLen, bs.BoardLen)
+	}
+
+	state := State{
+		Version:   int(bs.Version),
+		Options:   Options{bs.Seed, libsimplerand.Kind(bs.RandKind), Variant(bs.Variant)},
+		Score:     int(bs.Score),
+		Rand:      bs.Rand,
+		MoveCount: int(bs.MoveCount),
+	}
+	for row := 0; row < BoardLen; row++ {
+		for col := 0; col < BoardLen; col++ {
+			state.Grid[row][col] = int(bs.Grid[row][col])
+		}
+	}
+	return g.SetState(state)
+}
+
+func (g *game) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.GetState())
+}
+
+func (g *game) UnmarshalJSON(data []byte) error {
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	return g.SetState(state)
+}
@@ -0,0 +1,112 @@
+package lib2048
+
+import (
+	"distributed2048/libsimplerand"
+	"encoding/json"
+	"testing"
+)
+
+var allOptions = []Options{
+	{28, libsimplerand.LCG, Classic},
+	{28, libsimplerand.PCG, Blockers},
+	{28, libsimplerand.Xorshift, Fibonacci},
+	{28, libsimplerand.PCG, Threes},
+}
+
+// played returns a game with the given options after a few moves.
+func played(options Options) Game2048 {
+	g := NewGame2048WithOptions(options)
+	for _, dir := range []Direction{Up, Left, Down, Right, Up, Up, Left} {
+		g.MakeMove(dir)
+	}
+	return g
+}
+
+// checkSameGame checks that restored is in the same state as g, and that
+// both draw the same tiles from then on.
+func checkSameGame(t *testing.T, g, restored Game2048) {
+	t.Helper()
+	if got, want := restored.GetState(), g.GetState(); got != want {
+		t.Fatalf("Restored %+v, expected %+v", got, want)
+	}
+	for _, dir := range []Direction{Down, Right, Up, Left, Down} {
+		g.MakeMove(dir)
+		restored.MakeMove(dir)
+		if got, want := restored.GetState(), g.GetState(); got != want {
+			t.Fatalf("After %s, restored game is at %+v, expected %+v", dir, got, want)
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, options := range allOptions {
+		g := played(options)
+		data, err := g.(*game).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := &game{}
+		if err := restored.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%+v: %s", options, err)
+		}
+		checkSameGame(t, g, restored)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, options := range allOptions {
+		g := played(options)
+		data, err := json.Marshal(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := &game{}
+		if err := json.Unmarshal(data, restored); err != nil {
+			t.Fatalf("%+v: %s", options, err)
+		}
+		checkSameGame(t, g, restored)
+	}
+}
+
+// encode returns v as a binary snapshot.
+func TestRejectBadSnapshots(t *testing.T) {
+	g := played(allOptions[1])
+	data, err := g.(*game).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(offset int, value byte) []byte {
+		c := append([]byte(nil), data...)
+		c[offset] = value
+		return c
+	}
+	bad := map[string][]byte{
+		"empty":         nil,
+		"magic only":    data[:len(binaryMagic)],
+		"truncated":     data[:len(data)-1],
+		"bad magic":     corrupt(0, 'x'),
+		"version 0":     corrupt(4, 0),
+		"version 9":     corrupt(4, 9),
+		"board length":  corrupt(5, BoardLen+1),
+		"unknown rand":  corrupt(6, 99),
+		"unknown rules": corrupt(7, 99),
+	}
+	for name, data := range bad {
+		restored := &game{}
+		if err := restored.UnmarshalBinary(data); err == nil {
+			t.Errorf("Restored a snapshot that is %s", name)
+		}
+	}
+
+	for name, data := range map[string]string{
+		"not JSON":      `{"Version": 1, `,
+		"wrong types":   `{"Version": "1"}`,
+		"no version":    `{"Options": {"Seed": 28}}`,
+		"unknown rand":  `{"Version": 1, "Options": {"Seed": 28, "Rand": 99}}`,
+		"unknown rules": `{"Version": 1, "Options": {"Seed": 28, "Variant": 99}}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &game{}); err == nil {
+			t.Errorf("Restored JSON that is %s", name)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package lib2048
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Variant selects the rules that a game is played with.
+type Variant int
+
+const (
+	Classic   Variant = iota // powers of two, the default
+	Blockers                 // classic, with immovable blocker tiles on the board
+	Fibonacci                // tiles merge when they are consecutive Fibonacci numbers
+	Threes                   // "x3": Threes' rules, a 1 and a 2 make 3, and equal tiles from 3 up merge
+)
+
+// Blocker is the value of a blocker tile on the board. Blockers never move
+// or merge.
+const Blocker = -1
+
+const BlockerCount = 2
+
+var variants = []Variant{Classic, Blockers, Fibonacci, Threes}
+
+type rules struct {
+	firstTile    int // value of new tiles, except for bonusPercent of them
+	bonusTile    int // value of the other new tiles
+	bonusPercent int // of new tiles that are bonusTile
+	winningTile  int
+	blockerCount int // number of blockers placed when the game starts
+	merges       func(a, b int) bool
+	merged       func(a, b int) int
+}
+
+var classicRules = &rules{
+	firstTile:    FirstTileValue,
+	bonusTile:    FirstTileValue * 2,
+	bonusPercent: InitialTileDoublePercent,
+	winningTile:  2048,
+	merges:       func(a, b int) bool { return a > 0 && a == b },
+	merged:       func(a, b int) int { return a + b },
+}
+
+var blockerRules = &rules{
+	firstTile:    classicRules.firstTile,
+	bonusTile:    classicRules.bonusTile,
+	bonusPercent: classicRules.bonusPercent,
+	winningTile:  classicRules.winningTile,
+	blockerCount: BlockerCount,
+	merges:       classicRules.merges,
+	merged:       classicRules.merged,
+}
+
+var fibonacciRules = &rules{
+	firstTile:    1,
+	bonusTile:    2,
+	bonusPercent: InitialTileDoublePercent,
+	winningTile:  2584,
+	merges:       areConsecutiveFibonacci,
+	merged:       func(a, b int) int { return a + b },
+}
+
+// As in Threes, 1s and 2s only merge with each other, so half the new tiles
+// are each.
+var threesRules = &rules{
+	firstTile:    1,
+	bonusTile:    2,
+	bonusPercent: 50,
+	winningTile:  3072,
+	merges:       threesMerge,
+	merged:       func(a, b int) int { return a + b },
+}
+
+func rulesFor(variant Variant) *rules {
+	switch variant {
+	case Blockers:
+		return blockerRules
+	case Fibonacci:
+		return fibonacciRules
+	case Threes:
+		return threesRules
+	}
+	return classicRules
+}
+
+// NewTileValues returns the two values that new tiles have in a game with
+// the given options, and the percentage of new tiles that have the second.
+func NewTileValues(options Options) (int, int, int) {
+	r := rulesFor(options.Variant)
+	return r.firstTile, r.bonusTile, r.bonusPercent
+}
+
+// threesMerge returns whether a and b merge under Threes' rules: a 1 with a
+// 2, or two equal tiles of 3 or more.
+func threesMerge(a, b int) bool {
+	return (a == 1 && b == 2) || (a == 2 && b == 1) || (a >= 3 && a == b)
+}
+
+// areConsecutiveFibonacci returns whether a and b are next to each other in
+// the sequence 1, 1, 2, 3, 5, 8...
+func areConsecutiveFibonacci(a, b int) bool {
+	if a <= 0 || b <= 0 {
+		return false
+	}
+	if a > b {
+		a, b = b, a
+	}
+	prev, cur := 1, 1
+	for cur < a {
+		prev, cur = cur, prev+cur
+	}
+	return cur == a && (prev+cur == b || (a == 1 && b == 1))
+}
+
+func (v Variant) String() string {
+	switch v {
+	case Classic:
+		return "classic"
+	case Blockers:
+		return "blockers"
+	case Fibonacci:
+		return "fibonacci"
+	case Threes:
+		return "x3"
+	}
+	return fmt.Sprintf("Variant(%d)", int(v))
+}
+
+// ParseVariant is the inverse of Variant.String.
+func ParseVariant(name string) (Variant, error) {
+	for _, v := range variants {
+		if strings.ToLower(name) == v.String() {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown game variant %q", name)
+}
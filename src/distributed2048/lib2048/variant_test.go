@@ -0,0 +1,128 @@
+package lib2048
+
+import (
+	"testing"
+)
+
+const B = Blocker
+
+var directions = []Direction{Up, Left, Down, Right}
+
+// slides are rows slid Left, with the row they become and the score they
+// give, under each variant's rules.
+var slides = map[Variant][]struct {
+	row   [BoardLen]int
+	want  [BoardLen]int
+	score int
+}{
+	Classic: {
+		{[BoardLen]int{0, 0, 0, 2}, [BoardLen]int{2, 0, 0, 0}, 0},
+		{[BoardLen]int{2, 2, 0, 0}, [BoardLen]int{4, 0, 0, 0}, 4},
+		{[BoardLen]int{2, 0, 0, 2}, [BoardLen]int{4, 0, 0, 0}, 4},
+		{[BoardLen]int{2, 2, 2, 2}, [BoardLen]int{4, 4, 0, 0}, 8},
+		{[BoardLen]int{4, 2, 2, 0}, [BoardLen]int{4, 4, 0, 0}, 4},
+		{[BoardLen]int{2, 4, 2, 4}, [BoardLen]int{2, 4, 2, 4}, 0},
+	},
+	Blockers: {
+		{[BoardLen]int{0, B, 0, 2}, [BoardLen]int{0, B, 2, 0}, 0},
+		{[BoardLen]int{B, 0, 0, 2}, [BoardLen]int{B, 2, 0, 0}, 0},
+		{[BoardLen]int{2, B, 2, 2}, [BoardLen]int{2, B, 4, 0}, 4},
+		{[BoardLen]int{2, 2, B, 0}, [BoardLen]int{4, 0, B, 0}, 4},
+		{[BoardLen]int{2, B, B, 2}, [BoardLen]int{2, B, B, 2}, 0},
+	},
+	Fibonacci: {
+		{[BoardLen]int{1, 1, 0, 0}, [BoardLen]int{2, 0, 0, 0}, 2},
+		{[BoardLen]int{1, 2, 0, 0}, [BoardLen]int{3, 0, 0, 0}, 3},
+		{[BoardLen]int{3, 5, 0, 0}, [BoardLen]int{8, 0, 0, 0}, 8},
+		{[BoardLen]int{2, 2, 0, 0}, [BoardLen]int{2, 2, 0, 0}, 0},
+		{[BoardLen]int{3, 8, 0, 0}, [BoardLen]int{3, 8, 0, 0}, 0},
+		{[BoardLen]int{1, B, 2, 0}, [BoardLen]int{1, B, 2, 0}, 0},
+	},
+	Threes: {
+		{[BoardLen]int{1, 2, 0, 0}, [BoardLen]int{3, 0, 0, 0}, 3},
+		{[BoardLen]int{2, 0, 1, 0}, [BoardLen]int{3, 0, 0, 0}, 3},
+		{[BoardLen]int{1, 1, 0, 0}, [BoardLen]int{1, 1, 0, 0}, 0},
+		{[BoardLen]int{2, 2, 0, 0}, [BoardLen]int{2, 2, 0, 0}, 0},
+		{[BoardLen]int{3, 3, 0, 0}, [BoardLen]int{6, 0, 0, 0}, 6},
+		{[BoardLen]int{6, 6, 3, 3}, [BoardLen]int{12, 6, 0, 0}, 18},
+		{[BoardLen]int{3, 6, 0, 0}, [BoardLen]int{3, 6, 0, 0}, 0},
+		{[BoardLen]int{1, 2, 3, 0}, [BoardLen]int{3, 3, 0, 0}, 3},
+		{[BoardLen]int{1, B, 2, 0}, [BoardLen]int{1, B, 2, 0}, 0},
+	},
+}
+
+func TestVariantSlides(t *testing.T) {
+	for variant, cases := range slides {
+		options := Options{Variant: variant}
+		for _, c := range cases {
+			var grid Grid
+			grid[0] = c.row
+			got, score, moved := Slide(options, grid, Left)
+			if got[0] != c.want || score != c.score {
+				t.Errorf("%s: %v slid to %v scoring %d, expected %v scoring %d", variant, c.row, got[0], score, c.want, c.score)
+			}
+			if moved != (c.row != c.want) {
+				t.Errorf("%s: %v reported moved=%t", variant, c.row, moved)
+			}
+		}
+	}
+}
+
+// Merges keep the sum of the tiles, so the winning tile can be reached from
+// the new tiles.
+func TestVariantTiles(t *testing.T) {
+	for _, variant := range variants {
+		r := rulesFor(variant)
+		first, bonus, percent := NewTileValues(Options{Variant: variant})
+		if first != r.firstTile || bonus != r.bonusTile || percent <= 0 || percent >= 100 {
+			t.Errorf("%s: new tiles are %d and %d (%d%%)", variant, first, bonus, percent)
+		}
+
+		// Merge the tiles made so far with each other until the winning tile
+		// is made
+		tiles := map[int]bool{first: true, bonus: true}
+		for changed := true; changed && !tiles[r.winningTile]; {
+			changed = false
+			for a := range tiles {
+				for b := range tiles {
+					if r.merges(a, b) && !tiles[r.merged(a, b)] && r.merged(a, b) <= r.winningTile {
+						if r.merged(a, b) != a+b {
+							t.Errorf("%s: %d and %d merge into %d", variant, a, b, r.merged(a, b))
+						}
+						tiles[r.merged(a, b)] = true
+						changed = true
+					}
+				}
+			}
+		}
+		if !tiles[r.winningTile] {
+			t.Errorf("%s: %d cannot be made from %d and %d", variant, r.winningTile, first, bonus)
+		}
+		if r.merges(B, B) || r.merges(first, B) || r.merges(B, first) {
+			t.Errorf("%s: blockers merge", variant)
+		}
+	}
+}
+
+func TestBlockersStayPut(t *testing.T) {
+	g := NewGame2048WithOptions(Options{Seed: 31, Variant: Blockers})
+	var blockers [][2]int
+	for row, cells := range g.GetBoard() {
+		for col, value := range cells {
+			if value == Blocker {
+				blockers = append(blockers, [2]int{row, col})
+			}
+		}
+	}
+	if len(blockers) != BlockerCount {
+		t.Fatalf("Game started with %d blockers, expected %d", len(blockers), BlockerCount)
+	}
+	for i := 0; i < 50 && !g.IsGameOver(); i++ {
+		g.MakeMove(directions[i%len(directions)])
+		for _, cell := range blockers {
+			if g.GetBoard()[cell[0]][cell[1]] != Blocker {
+				t.Fatalf("Blocker at %v moved after %d moves", cell, i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,18 @@
+// Package libai plays 2048 on top of lib2048, suggesting moves for any game.
+//
+// It is kept out of lib2048 so that the rules stay all that the game server
+// and its replicas need: the search and its heuristics only build into the
+// programs that play, and can be tuned without touching the rules.
+
+package libai
+
+import (
+	"distributed2048/lib2048"
+)
+
+type Advisor interface {
+	// SuggestMove returns the direction that the advisor thinks is best for
+	// the given game. It returns false if no direction changes the board.
+	// The game itself is not changed.
+	SuggestMove(game lib2048.Game2048) (lib2048.Direction, bool)
+}
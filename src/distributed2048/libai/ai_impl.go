@@ -0,0 +1,182 @@
+package libai
+
+import (
+	"distributed2048/lib2048"
+	"math"
+)
+
+const (
+	DefaultDepth = 2
+
+	// Boards reached with a lower probability than this are not searched
+	// any further, but evaluated straight away.
+	minProbability = 0.0001
+
+	emptyWeight     = 2.7
+	monotonicWeight = 1.0
+	smoothWeight    = 0.1
+	maxTileWeight   = 1.0
+	cornerBonus     = 2.0
+	lostGamePenalty = -1e6
+)
+
+var directions = []lib2048.Direction{lib2048.Up, lib2048.Left, lib2048.Down, lib2048.Right}
+
+type expectimax struct {
+	depth int
+	visit func(ply int) // if set, called for each player move searched
+}
+
+// NewExpectimax returns an advisor that searches depth moves ahead,
+// averaging over every tile that the game could add after each move.
+func NewExpectimax(depth int) Advisor {
+	if depth < 1 {
+		depth = 1
+	}
+	return &expectimax{depth, nil}
+}
+
+func (e *expectimax) SuggestMove(game lib2048.Game2048) (lib2048.Direction, bool) {
+	options := game.GetOptions()
+	best := math.Inf(-1)
+	var bestDir lib2048.Direction
+	found := false
+	for _, dir := range directions {
+		grid, _, moved := game.Slide(dir)
+		if !moved {
+			continue
+		}
+		e.visited(1)
+		value := e.chance(options, grid, e.depth-1, 1)
+		if !found || value > best {
+			best = value
+			bestDir = dir
+			found = true
+		}
+	}
+	return bestDir, found
+}
+
+// max returns the value of the best move from grid, searching depth moves
+// ahead, including that one.
+func (e *expectimax) max(options lib2048.Options, grid lib2048.Grid, depth int, probability float64) float64 {
+	best := lostGamePenalty
+	for _, dir := range directions {
+		next, _, moved := lib2048.Slide(options, grid, dir)
+		if !moved {
+			continue
+		}
+		e.visited(e.depth - depth + 1)
+		if value := e.chance(options, next, depth-1, probability); value > best {
+			best = value
+		}
+	}
+	return best
+}
+
+func (e *expectimax) visited(ply int) {
+	if e.visit != nil {
+		e.visit(ply)
+	}
+}
+
+// chance returns the expected value of grid over every tile that could be
+// added to it, searching depth more moves ahead.
+func (e *expectimax) chance(options lib2048.Options, grid lib2048.Grid, depth int, probability float64) float64 {
+	empty := emptyCells(grid)
+	if depth <= 0 || len(empty) == 0 || probability < minProbability {
+		return evaluate(grid)
+	}
+
+	total := 0.0
+	cellProbability := probability / float64(len(empty))
+	firstTile, bonusTile, bonusPercent := lib2048.NewTileValues(options)
+	bonusTileChance := float64(bonusPercent) / 100
+	for _, cell := range empty {
+		for _, tile := range []struct {
+			value  int
+			chance float64
+		}{
+			{firstTile, 1 - bonusTileChance},
+			{bonusTile, bonusTileChance},
+		} {
+			grid[cell[0]][cell[1]] = tile.value
+			total += tile.chance * e.max(options, grid, depth, cellProbability*tile.chance)
+		}
+		grid[cell[0]][cell[1]] = 0
+	}
+	return total / float64(len(empty))
+}
+
+func emptyCells(grid lib2048.Grid) [][2]int {
+	cells := make([][2]int, 0, lib2048.BoardLen*lib2048.BoardLen)
+	for row := 0; row < lib2048.BoardLen; row++ {
+		for col := 0; col < lib2048.BoardLen; col++ {
+			if grid[row][col] == 0 {
+				cells = append(cells, [2]int{row, col})
+			}
+		}
+	}
+	return cells
+}
+
+// evaluate scores a board by how easy it is likely to be to keep playing:
+// the more empty cells, the more each row and column is sorted, the smaller
+// the difference between neighbours, and the larger the biggest tile (more
+// so if it sits in a corner), the better.
+func evaluate(grid lib2048.Grid) float64 {
+	var rank [lib2048.BoardLen][lib2048.BoardLen]float64
+	maxRank := 0.0
+	for row := 0; row < lib2048.BoardLen; row++ {
+		for col := 0; col < lib2048.BoardLen; col++ {
+			if grid[row][col] > 0 {
+				rank[row][col] = math.Log2(float64(grid[row][col]))
+			}
+			if rank[row][col] > maxRank {
+				maxRank = rank[row][col]
+			}
+		}
+	}
+
+	empty := float64(len(emptyCells(grid)))
+	smooth := 0.0
+	monotonic := 0.0
+	for i := 0; i < lib2048.BoardLen; i++ {
+		var rowUp, rowDown, colUp, colDown float64
+		for j := 0; j+1 < lib2048.BoardLen; j++ {
+			rowDiff := rank[i][j+1] - rank[i][j]
+			colDiff := rank[j+1][i] - rank[j][i]
+			if rank[i][j] > 0 && rank[i][j+1] > 0 {
+				smooth -= math.Abs(rowDiff)
+			}
+			if rank[j][i] > 0 && rank[j+1][i] > 0 {
+				smooth -= math.Abs(colDiff)
+			}
+			if rowDiff > 0 {
+				rowUp += rowDiff
+			} else {
+				rowDown -= rowDiff
+			}
+			if colDiff > 0 {
+				colUp += colDiff
+			} else {
+				colDown -= colDiff
+			}
+		}
+		monotonic -= math.Min(rowUp, rowDown) + math.Min(colUp, colDown)
+	}
+
+	corner := 0.0
+	last := lib2048.BoardLen - 1
+	for _, cell := range [][2]int{{0, 0}, {0, last}, {last, 0}, {last, last}} {
+		if rank[cell[0]][cell[1]] == maxRank {
+			corner = cornerBonus
+		}
+	}
+
+	return emptyWeight*empty +
+		monotonicWeight*monotonic +
+		smoothWeight*smooth +
+		maxTileWeight*maxRank +
+		corner
+}
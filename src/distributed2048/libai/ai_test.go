@@ -0,0 +1,65 @@
+package libai
+
+import (
+	"distributed2048/lib2048"
+	"testing"
+)
+
+func newGame(grid lib2048.Grid) lib2048.Game2048 {
+	g := lib2048.NewSeededGame2048(30)
+	g.SetGrid(grid)
+	return g
+}
+
+func TestOnlyLegalMove(t *testing.T) {
+	g := newGame(lib2048.Grid{
+		{2, 4, 2, 4},
+		{4, 2, 4, 2},
+		{2, 4, 2, 4},
+		{0, 0, 0, 0},
+	})
+	before := g.GetBoard()
+	dir, ok := NewExpectimax(DefaultDepth).SuggestMove(g)
+	if !ok || dir != lib2048.Down {
+		t.Errorf("Suggested %v, %v; want Down, true", dir, ok)
+	}
+	if g.GetBoard() != before {
+		t.Error("SuggestMove changed the game")
+	}
+}
+
+func TestDeadBoard(t *testing.T) {
+	g := newGame(lib2048.Grid{
+		{2, 4, 2, 4},
+		{4, 2, 4, 2},
+		{2, 4, 2, 4},
+		{4, 2, 4, 2},
+	})
+	if dir, ok := NewExpectimax(DefaultDepth).SuggestMove(g); ok {
+		t.Errorf("Suggested %v on a board with no moves", dir)
+	}
+}
+
+func TestSearchDepth(t *testing.T) {
+	g := newGame(lib2048.Grid{
+		{2, 0, 0, 0},
+		{0, 0, 4, 0},
+		{0, 0, 0, 0},
+		{0, 0, 0, 0},
+	})
+	for depth := 1; depth <= 3; depth++ {
+		seen := make(map[int]bool)
+		e := &expectimax{depth, func(ply int) { seen[ply] = true }}
+		if _, ok := e.SuggestMove(g); !ok {
+			t.Fatalf("Depth %d: no move suggested", depth)
+		}
+		if len(seen) != depth {
+			t.Errorf("Depth %d: searched plies %v", depth, seen)
+		}
+		for ply := 1; ply <= depth; ply++ {
+			if !seen[ply] {
+				t.Errorf("Depth %d: ply %d was not searched", depth, ply)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package libauth authenticates the RPCs that change the cluster: game
+// servers registering with the central server or draining from it, and the
+// Paxos messages between game servers. Every node is given the same secret,
+// and the args of these RPCs carry a MAC, an HMAC-SHA256 keyed with the
+// secret over the method called and the args, so that whoever does not hold
+// the secret can neither claim a slot in the ring nor inject moves.
+//
+// A MAC does not hide the args, nor keep them from being replayed; run the
+// cluster with TLS (see libtls) for that. Each node is given its Secret when
+// it is made; a nil Secret leaves authentication off. The same secret guards
+// the admin endpoints served over HTTP, through RequireAdmin.
+package libauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+)
+
+const MIN_SECRET_LENGTH = 16 // bytes
+
+// MAC authenticates the args of an RPC, which hold it in a field of their
+// own. It is empty when authentication is off.
+type MAC []byte
+
+var ErrUnauthenticated = errors.New("the call is not authenticated with the cluster's secret")
+
+// Secret is the key that a node signs and verifies calls with. Authentication
+// is off for a node whose Secret is nil.
+type Secret []byte
+
+// LoadSecret reads a secret from the file at path, ignoring whitespace at
+// either end. If path is empty, it returns nil, leaving authentication off.
+func LoadSecret(path string) (Secret, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) < MIN_SECRET_LENGTH {
+		return nil, fmt.Errorf("the secret in %s is shorter than %d bytes", path, MIN_SECRET_LENGTH)
+	}
+	return Secret(data), nil
+}
+
+// Enabled returns whether authentication is on.
+func (s Secret) Enabled() bool {
+	return len(s) > 0
+}
+
+// Sign sets the MAC of args, a pointer to the args of a call to method,
+// which must have a field of type MAC. It does nothing if authentication is
+// off. Args shared with other goroutines must be copied before they are
+// signed.
+func (s Secret) Sign(method string, args interface{}) error {
+	if !s.Enabled() {
+		return nil
+	}
+	field := macField(args)
+	field.Set(reflect.Zero(field.Type()))
+	mac, err := compute(s, method, args)
+	if err != nil {
+		return err
+	}
+	field.Set(reflect.ValueOf(mac))
+	return nil
+}
+
+// Verify returns ErrUnauthenticated unless args, a pointer to the args of a
+// call to method, hold the MAC that Sign would have given them. It always
+// returns nil if authentication is off.
+func (s Secret) Verify(method string, args interface{}) error {
+	if !s.Enabled() {
+		return nil
+	}
+	field := macField(args)
+	got := field.Interface().(MAC)
+	if len(got) == 0 {
+		return ErrUnauthenticated
+	}
+	field.Set(reflect.Zero(field.Type()))
+	want, err := compute(s, method, args)
+	field.Set(reflect.ValueOf(got))
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(got, want) {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+var macType = reflect.TypeOf(MAC(nil))
+
+// macField returns the MAC field of the struct that args points to.
+func macField(args interface{}) reflect.Value {
+	v := reflect.ValueOf(args)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("libauth: args must point to a struct, not %T", args))
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Type() == macType {
+			return v.Field(i)
+		}
+	}
+	panic(fmt.Sprintf("libauth: %T has no MAC field", args))
+}
+
+// compute returns the MAC of args, whose MAC field must be empty, for a call
+// to method. The args are hashed as the other end receives them: gob encoded,
+// as net/rpc sends them, and decoded again. As gob numbers types in the order
+// that each process first sees them, they are then hashed as JSON, which
+// holds the same fields.
+func compute(key []byte, method string, args interface{}) (MAC, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(args); err != nil {
+		return nil, err
+	}
+	received := reflect.New(reflect.TypeOf(args).Elem()).Interface()
+	if err := gob.NewDecoder(&buf).Decode(received); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(received)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,88 @@
+package libauth
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testArgs struct {
+	Name  string
+	Times []time.Time
+	Auth  MAC
+}
+
+// sent returns args as the other end of an RPC receives them.
+func sent(t *testing.T, args *testArgs) *testArgs {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(args); err != nil {
+		t.Fatal(err)
+	}
+	var received testArgs
+	if err := gob.NewDecoder(&buf).Decode(&received); err != nil {
+		t.Fatal(err)
+	}
+	return &received
+}
+
+func TestSignVerify(t *testing.T) {
+	secret := Secret("0123456789abcdef")
+	args := &testArgs{"node", []time.Time{time.Now(), time.Now().UTC()}, nil}
+	if err := secret.Sign("Test.Call", args); err != nil {
+		t.Fatal(err)
+	}
+	if err := secret.Verify("Test.Call", sent(t, args)); err != nil {
+		t.Error("Signed args were not verified:", err)
+	}
+
+	if err := secret.Verify("Test.Other", sent(t, args)); err != ErrUnauthenticated {
+		t.Error("Args signed for one method were verified for another")
+	}
+	tampered := sent(t, args)
+	tampered.Name = "rogue"
+	if err := secret.Verify("Test.Call", tampered); err != ErrUnauthenticated {
+		t.Error("Tampered args were verified")
+	}
+	if err := secret.Verify("Test.Call", &testArgs{"node", nil, nil}); err != ErrUnauthenticated {
+		t.Error("Args without a MAC were verified")
+	}
+	if err := Secret("another secret, as long").Verify("Test.Call", sent(t, args)); err != ErrUnauthenticated {
+		t.Error("Args signed with another secret were verified")
+	}
+
+	var off Secret
+	unsigned := &testArgs{"node", nil, nil}
+	if err := off.Sign("Test.Call", unsigned); err != nil || unsigned.Auth != nil {
+		t.Error("Args were signed with authentication off")
+	}
+	if err := off.Verify("Test.Call", unsigned); err != nil {
+		t.Error("Args were rejected with authentication off:", err)
+	}
+}
+
+func TestLoadSecret(t *testing.T) {
+	dir := t.TempDir()
+	short, long := filepath.Join(dir, "short"), filepath.Join(dir, "long")
+	ioutil.WriteFile(short, []byte("secret\n"), 0600)
+	ioutil.WriteFile(long, []byte("  a secret that is long enough\n"), 0600)
+
+	if _, err := LoadSecret(short); err == nil {
+		t.Error("Loaded a secret that is too short")
+	}
+	if _, err := LoadSecret(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Loaded a secret from a missing file")
+	}
+	s, err := LoadSecret(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Enabled() || string(s) != "a secret that is long enough" {
+		t.Errorf("Loaded the secret %q", s)
+	}
+	if s, err := LoadSecret(""); err != nil || s.Enabled() {
+		t.Error("Authentication is on without a secret file")
+	}
+}
@@ -0,0 +1,66 @@
+package libauth
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ADMIN_REALM is shown by browsers when they ask for the admin password.
+const ADMIN_REALM = "distributed2048 admin"
+
+// RequireAdmin only lets through requests to h that may administer the
+// cluster. If authentication is on, they have to give the secret as the
+// password of HTTP basic authentication, with any user name, so that a
+// browser asks for it; otherwise they have to come from the same machine.
+// Requests that change anything are also turned away if a browser sent them
+// from another site.
+func (s Secret) RequireAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Enabled() {
+			_, password, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(password), s) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+ADMIN_REALM+`"`)
+				http.Error(w, "the cluster's secret is needed", http.StatusUnauthorized)
+				return
+			}
+		} else if !fromLoopback(r) {
+			http.Error(w, "only allowed from the same machine while authentication is off", http.StatusForbidden)
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" && !sameOrigin(r) {
+			http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameOrigin returns whether r was not sent by a page from another site.
+// Clients other than browsers send no Origin.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// Authorize gives r the secret that RequireAdmin asks for, if authentication
+// is on.
+func (s Secret) Authorize(r *http.Request) {
+	if s.Enabled() {
+		r.SetBasicAuth("admin", string(s))
+	}
+}
@@ -0,0 +1,53 @@
+package libauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func adminStatus(s Secret, method, remoteAddr, password, origin string) int {
+	h := s.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest(method, "http://central:8080/admin/api/reset", nil)
+	r.RemoteAddr = remoteAddr
+	if password != "" {
+		r.SetBasicAuth("admin", password)
+	}
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRequireAdmin(t *testing.T) {
+	secret := Secret("0123456789abcdef")
+	tests := []struct {
+		secret     Secret
+		method     string
+		remoteAddr string
+		password   string
+		origin     string
+		want       int
+	}{
+		{secret, "POST", "10.0.0.1:1234", "", "", http.StatusUnauthorized},
+		{secret, "POST", "127.0.0.1:1234", "", "", http.StatusUnauthorized},
+		{secret, "GET", "10.0.0.1:1234", "wrong", "", http.StatusUnauthorized},
+		{secret, "POST", "10.0.0.1:1234", string(secret), "", http.StatusOK},
+		{secret, "POST", "10.0.0.1:1234", string(secret), "http://central:8080", http.StatusOK},
+		{secret, "POST", "10.0.0.1:1234", string(secret), "http://evil.example", http.StatusForbidden},
+		{secret, "GET", "10.0.0.1:1234", string(secret), "http://evil.example", http.StatusOK},
+		{nil, "POST", "10.0.0.1:1234", "", "", http.StatusForbidden},
+		{nil, "GET", "10.0.0.1:1234", "", "", http.StatusForbidden},
+		{nil, "POST", "127.0.0.1:1234", "", "", http.StatusOK},
+		{nil, "POST", "[::1]:1234", "", "", http.StatusOK},
+		{nil, "POST", "127.0.0.1:1234", "", "http://evil.example", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		if got := adminStatus(test.secret, test.method, test.remoteAddr, test.password, test.origin); got != test.want {
+			t.Errorf("%s from %s with password %q and origin %q (auth on: %t): got %d, expected %d",
+				test.method, test.remoteAddr, test.password, test.origin, test.secret.Enabled(), got, test.want)
+		}
+	}
+}
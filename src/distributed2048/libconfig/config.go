@@ -0,0 +1,366 @@
+// Package libconfig holds the settings that the runners share, and loads
+// them from a config file: where the central server, the game servers and
+// the web client listen, the timeouts of Paxos and of the central server,
+// how often votes are proposed, the games that are played, logging and
+// tracing, the certificates for TLS, and the secret that the cluster's RPCs
+// are authenticated with. Config files are written in a subset of TOML, e.g.:
+//
+//	[central]
+//	port = 25340
+//	game_servers = 3
+//
+//	[timeouts]
+//	rpc = "500ms"
+//
+// Every key is optional, and keeps its default if left out. d2048.toml, at
+// the root of the repository, lists them all. Runners take the file with
+// -config, and their flags override it.
+package libconfig
+
+import (
+	"distributed2048/centralserver"
+	"distributed2048/cmdlineclient"
+	"distributed2048/gameserver"
+	"distributed2048/lib2048"
+	"distributed2048/libauth"
+	"distributed2048/liblog"
+	"distributed2048/libpaxos"
+	"distributed2048/libsimplerand"
+	"distributed2048/libtls"
+	"distributed2048/libtrace"
+	"distributed2048/util"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Central    Central    `toml:"central"`
+	GameServer GameServer `toml:"gameserver"`
+	Web        Web        `toml:"web"`
+	Timeouts   Timeouts   `toml:"timeouts"`
+	Voting     Voting     `toml:"voting"`
+	Game       Game       `toml:"game"`
+	Log        Log        `toml:"log"`
+	TLS        TLS        `toml:"tls"`
+	Auth       Auth       `toml:"auth"`
+
+	tls    *libtls.Config // loaded by Apply
+	secret libauth.Secret // loaded by Apply
+}
+
+type Central struct {
+	Host        string `toml:"host"` // that game servers and clients reach the central server at
+	Port        int    `toml:"port"`
+	GameServers int    `toml:"game_servers"` // in the cluster, which the central server waits for
+}
+
+// HostPort returns where game servers register with the central server.
+func (c Central) HostPort() string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+type GameServer struct {
+	Hostname      string `toml:"hostname"` // that the other game servers and clients reach this one at
+	Port          int    `toml:"port"`
+	WebsocketPath string `toml:"websocket_path"` // that clients connect to
+	ReplayDir     string `toml:"replay_dir"`     // to record games into, if not empty
+}
+
+func (c GameServer) HostPort() string {
+	return fmt.Sprintf("%s:%d", c.Hostname, c.Port)
+}
+
+// Web is where d2048 web serves the web client.
+type Web struct {
+	Port int    `toml:"port"`
+	Dir  string `toml:"dir"` // the client's files
+}
+
+type Timeouts struct {
+	RPC           time.Duration `toml:"rpc"`            // for each Paxos message
+	RegisterRetry time.Duration `toml:"register_retry"` // between a game server's attempts to register
+	Admin         time.Duration `toml:"admin"`          // for the central server's calls to a game server's admin service
+	Drain         time.Duration `toml:"drain"`          // for a game server shutting down to get its votes decided
+}
+
+type Voting struct {
+	Interval    time.Duration `toml:"interval"`     // how often a game server proposes the votes it received
+	PerClient   int           `toml:"per_client"`   // votes a client may make in each interval, 0 for no limit
+	PerIP       int           `toml:"per_ip"`       // votes the clients at one IP address may make in each interval, 0 for no limit
+	Penalty     string        `toml:"penalty"`      // for clients over a limit: none, drop or downweight
+	Downweight  float64       `toml:"downweight"`   // what each vote of a down-weighted client counts for
+	PenaltyTime time.Duration `toml:"penalty_time"` // how long a client stays down-weighted after it last went over a limit
+}
+
+// Game holds the options that new games are started with.
+type Game struct {
+	Seed    uint   `toml:"seed"` // random if 0
+	RNG     string `toml:"rng"`
+	Variant string `toml:"variant"`
+}
+
+// Options returns the game options, which must be valid.
+func (g Game) Options() (lib2048.Options, error) {
+	if g.Seed > math.MaxUint32 {
+		return lib2048.Options{}, fmt.Errorf("seed %d is too large", g.Seed)
+	}
+	randKind, err := libsimplerand.ParseKind(g.RNG)
+	if err != nil {
+		return lib2048.Options{}, err
+	}
+	variant, err := lib2048.ParseVariant(g.Variant)
+	if err != nil {
+		return lib2048.Options{}, err
+	}
+	return lib2048.Options{uint32(g.Seed), randKind, variant}, nil
+}
+
+// Log holds the logging and tracing settings. Empty ones leave those of the
+// environment (D2048_LOG, D2048_LOG_FORMAT and D2048_TRACE) as they are.
+type Log struct {
+	Level  string `toml:"level"`  // a level spec, e.g. info,paxos=debug
+	Format string `toml:"format"` // text or json
+	Trace  string `toml:"trace"`  // file or collector URL to export spans to
+}
+
+// TLS holds the PEM files that TLS is turned on with. They must all be given,
+// or none of them, which leaves TLS off.
+type TLS struct {
+	CA   string `toml:"ca"`   // certificate of the CA that every node's is signed by
+	Cert string `toml:"cert"` // this node's certificate
+	Key  string `toml:"key"`  // and its key
+}
+
+// Auth holds the secret that game servers and the central server
+// authenticate their RPCs with (see libauth).
+type Auth struct {
+	SecretFile string `toml:"secret_file"` // authentication is off if empty
+}
+
+// Default returns the settings that are used where a config file and the
+// flags give none.
+func Default() *Config {
+	return &Config{
+		Central: Central{
+			Host:        util.LOCALHOST,
+			Port:        util.CENTRALPORT,
+			GameServers: 1,
+		},
+		GameServer: GameServer{
+			Hostname:      util.LOCALHOST,
+			Port:          15510,
+			WebsocketPath: util.WSPATTERN,
+		},
+		Web: Web{
+			Port: 8888,
+			Dir:  "client",
+		},
+		Timeouts: Timeouts{
+			RPC:           libpaxos.RPC_TIMEOUT_MILLISEC * time.Millisecond,
+			RegisterRetry: gameserver.REGISTER_RETRY_INTERVAL * time.Millisecond,
+			Admin:         centralserver.ADMIN_TIMEOUT,
+			Drain:         gameserver.DRAIN_TIMEOUT * time.Second,
+		},
+		Voting: Voting{
+			Interval:    gameserver.CLIENT_UPDATE_INTERVAL * time.Millisecond,
+			PerClient:   gameserver.VOTES_PER_CLIENT,
+			PerIP:       gameserver.VOTES_PER_IP,
+			Penalty:     "none",
+			Downweight:  gameserver.DOWNWEIGHT,
+			PenaltyTime: gameserver.PENALTY_TIME * time.Second,
+		},
+		Game: Game{
+			RNG:     "lcg",
+			Variant: "classic",
+		},
+	}
+}
+
+// Load returns the default settings, with those of the config file at path
+// over them.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if err := c.ReadFile(path); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadFile sets the settings that the config file at path gives. It does not
+// validate them.
+func (c *Config) ReadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.Parse(path, string(data))
+}
+
+// Parse is like ReadFile, for a config file named name that holds data.
+func (c *Config) Parse(name string, data string) error {
+	entries, err := parse(name, data)
+	if err != nil {
+		return err
+	}
+	return decode(name, entries, c)
+}
+
+// Validate returns an error describing the first setting that is not valid.
+func (c *Config) Validate() error {
+	checkPort := func(name string, port int) error {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("%s must be between 1 and 65535, not %d", name, port)
+		}
+		return nil
+	}
+	checkPositive := func(name string, d time.Duration) error {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, not %s", name, d)
+		}
+		return nil
+	}
+
+	if c.Central.Host == "" {
+		return errors.New("central.host must be given")
+	}
+	if err := checkPort("central.port", c.Central.Port); err != nil {
+		return err
+	}
+	if c.Central.GameServers < 1 {
+		return fmt.Errorf("central.game_servers must be at least 1, not %d", c.Central.GameServers)
+	}
+	if c.GameServer.Hostname == "" {
+		return errors.New("gameserver.hostname must be given")
+	}
+	if err := checkPort("gameserver.port", c.GameServer.Port); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(c.GameServer.WebsocketPath, "/") {
+		return fmt.Errorf("gameserver.websocket_path must start with /, not %q", c.GameServer.WebsocketPath)
+	}
+	if err := checkPort("web.port", c.Web.Port); err != nil {
+		return err
+	}
+	if c.Web.Dir == "" {
+		return errors.New("web.dir must be given")
+	}
+	if err := checkPositive("timeouts.rpc", c.Timeouts.RPC); err != nil {
+		return err
+	}
+	if err := checkPositive("timeouts.register_retry", c.Timeouts.RegisterRetry); err != nil {
+		return err
+	}
+	if err := checkPositive("timeouts.admin", c.Timeouts.Admin); err != nil {
+		return err
+	}
+	if err := checkPositive("timeouts.drain", c.Timeouts.Drain); err != nil {
+		return err
+	}
+	if err := checkPositive("voting.interval", c.Voting.Interval); err != nil {
+		return err
+	}
+	if c.Voting.PerClient < 0 {
+		return fmt.Errorf("voting.per_client must not be negative, not %d", c.Voting.PerClient)
+	}
+	if c.Voting.PerIP < 0 {
+		return fmt.Errorf("voting.per_ip must not be negative, not %d", c.Voting.PerIP)
+	}
+	if _, err := gameserver.ParsePenalty(c.Voting.Penalty); err != nil {
+		return fmt.Errorf("voting.penalty: %s", err)
+	}
+	if c.Voting.Downweight <= 0 || c.Voting.Downweight > 1 {
+		return fmt.Errorf("voting.downweight must be more than 0 and at most 1, not %g", c.Voting.Downweight)
+	}
+	if err := checkPositive("voting.penalty_time", c.Voting.PenaltyTime); err != nil {
+		return err
+	}
+	options, err := c.Game.Options()
+	if err == nil {
+		err = lib2048.ValidateOptions(options)
+	}
+	if err != nil {
+		return fmt.Errorf("game: %s", err)
+	}
+	if c.Log.Level != "" {
+		if err := liblog.CheckSpec(c.Log.Level); err != nil {
+			return fmt.Errorf("log.level: %s", err)
+		}
+	}
+	if c.Log.Format != "" {
+		if _, err := liblog.ParseFormat(c.Log.Format); err != nil {
+			return fmt.Errorf("log.format: %s", err)
+		}
+	}
+	if c.TLS != (TLS{}) && (c.TLS.CA == "" || c.TLS.Cert == "" || c.TLS.Key == "") {
+		return errors.New("tls.ca, tls.cert and tls.key must all be given, or none of them")
+	}
+	return nil
+}
+
+// TLSConfig returns the TLS setup that Load loaded, which the node's
+// servers and clients are given, or nil if TLS is off.
+func (c *Config) TLSConfig() *libtls.Config {
+	return c.tls
+}
+
+// Secret returns the secret that Load loaded, which the node signs and
+// verifies calls with, or nil if authentication is off.
+func (c *Config) Secret() libauth.Secret {
+	return c.secret
+}
+
+// CentralURL returns where clients ask the central server for a game server,
+// which is an https URL if TLS is on. It is only known once Load is called.
+func (c *Config) CentralURL() string {
+	return c.tls.URL(c.Central.HostPort())
+}
+
+// Load loads the TLS files for TLSConfig and the secret for Secret, leaving
+// the settings of other packages alone.
+func (c *Config) Load() error {
+	tlsConfig, err := libtls.Load(c.TLS.CA, c.TLS.Cert, c.TLS.Key)
+	if err != nil {
+		return fmt.Errorf("tls: %s", err)
+	}
+	c.tls = tlsConfig
+	secret, err := libauth.LoadSecret(c.Auth.SecretFile)
+	if err != nil {
+		return fmt.Errorf("auth: %s", err)
+	}
+	c.secret = secret
+	return nil
+}
+
+// Apply sets the timeouts and the voting settings of the packages that use
+// them, configures logging and tracing, and calls Load. Runners call it
+// once, before starting any server or client.
+func (c *Config) Apply() error {
+	libpaxos.RPCTimeout = c.Timeouts.RPC
+	gameserver.RegisterRetryInterval = c.Timeouts.RegisterRetry
+	gameserver.ProposeInterval = c.Voting.Interval
+	gameserver.VotesPerClient = c.Voting.PerClient
+	gameserver.VotesPerIP = c.Voting.PerIP
+	gameserver.LimitPenalty, _ = gameserver.ParsePenalty(c.Voting.Penalty)
+	gameserver.DownweightFactor = c.Voting.Downweight
+	gameserver.PenaltyTime = c.Voting.PenaltyTime
+	centralserver.AdminTimeout = c.Timeouts.Admin
+	gameserver.DrainTimeout = c.Timeouts.Drain
+	cmdlineclient.WebsocketPath = c.GameServer.WebsocketPath
+	if err := c.Load(); err != nil {
+		return err
+	}
+	if err := liblog.Configure(c.Log.Level, c.Log.Format); err != nil {
+		return err
+	}
+	if c.Log.Trace != "" {
+		return libtrace.Configure(c.Log.Trace)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package libconfig
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The example config at the root of the repository lists every setting with
+// its default.
+func TestExampleIsDefault(t *testing.T) {
+	c, err := Load("../../../d2048.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def := Default(); !reflect.DeepEqual(c, def) {
+		t.Errorf("d2048.toml gives\n%+v\nbut the defaults are\n%+v", c, def)
+	}
+}
+
+func TestParse(t *testing.T) {
+	c := Default()
+	err := c.Parse("test.toml", `
+# A comment
+[central]
+host = "central.example"  # trailing comment
+port = 1_234
+game_servers = 0x5 # hex
+
+[voting]
+per_client = 0b10
+per_ip = 0o17
+downweight = 2_5e-2
+
+[timeouts]
+rpc = "2s"
+
+[game]
+seed = 7
+variant = 'x3'
+
+[log]
+level = "info,paxos=debug"
+trace = "http://localhost:4318/v1/traces#x"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Central = Central{"central.example", 1234, 5}
+	want.Voting.PerClient = 2
+	want.Voting.PerIP = 15
+	want.Voting.Downweight = 0.25
+	want.Timeouts.RPC = 2 * time.Second
+	want.Game.Seed = 7
+	want.Game.Variant = "x3"
+	want.Log.Level = "info,paxos=debug"
+	want.Log.Trace = "http://localhost:4318/v1/traces#x"
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Got\n%+v\nexpected\n%+v", c, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		err  string // what the error must contain
+	}{
+		{"port = 1", "test.toml:1: port is not in a [table]"},
+		{"[central]\n\nprot = 1", "test.toml:3: unknown key prot in [central]"},
+		{"[centrl]", "test.toml:1: unknown table [centrl]"},
+		{"[central]\nport = \"1\"", "test.toml:2: port: expected an integer"},
+		{"[central]\nport = 1\nport = 2", "test.toml:3: port is given twice"},
+		{"[central]\nhost = localhost", "strings must be quoted"},
+		{"[central]\nhost = \"localhost", "bad string"},
+		{"[timeouts]\nrpc = 500", "expected a duration"},
+		{"[game]\nseed = -1", "expected a non-negative integer"},
+		{"[central]\nport = 70000", "central.port must be between 1 and 65535"},
+		{"[central]\ngame_servers = 0", "central.game_servers must be at least 1"},
+		{"[gameserver]\nwebsocket_path = \"abc\"", "must start with /"},
+		{"[voting]\ninterval = \"0s\"", "voting.interval must be positive"},
+		{"[voting]\nper_ip = -1", "voting.per_ip must not be negative"},
+		{"[voting]\npenalty = \"ban\"", "voting.penalty"},
+		{"[voting]\ndownweight = 0", "voting.downweight must be more than 0"},
+		{"[game]\nvariant = \"hex\"", "unknown game variant"},
+		{"[log]\nlevel = \"paxos=loud\"", "log.level"},
+		{"[log]\nformat = \"xml\"", "log.format"},
+		{"[tls]\ncert = \"node.pem\"", "tls.ca, tls.cert and tls.key must all be given"},
+		{"[central]\nport = 010", "bad value 010"},
+		{"[central]\nport = 0_10", "bad value 0_10"},
+		{"[central]\nport = 1__0", "bad value 1__0"},
+		{"[central]\nport = _10", "bad value _10"},
+		{"[central]\nport = 10_", "bad value 10_"},
+		{"[central]\nport = 0x_1f", "bad value 0x_1f"},
+		{"[central]\nport = -0x1f", "bad value -0x1f"},
+		{"[central]\nport = 0X1F", "bad value 0X1F"},
+		{"[central]\nport = 99999999999999999999", "out of range"},
+		{"[central]\nport = 80 90", "bad value 80 90"},
+		{"[central]\nport = # 80", "missing value"},
+		{"[central]\nport = 80 # \x01", "control character in comment"},
+		{"# \x7f\n[central]", "test.toml:1: control character in comment"},
+		{"[central]\nport = [80, 81]", "arrays are not supported"},
+		{"[central]\nport = {a = 80}", "inline tables are not supported"},
+		{"[central]\nport.a = 80", "bad key"},
+		{"[[central]]", "arrays of tables are not supported"},
+		{"[voting]\ndownweight = .5", "bad value .5"},
+		{"[voting]\ndownweight = 1.", "bad value 1."},
+		{"[voting]\ndownweight = 01.5", "bad value 01.5"},
+		{"[voting]\ndownweight = inf", "bad value inf"},
+		{"[voting]\ndownweight = 0x1p-2", "bad value 0x1p-2"},
+		{"[game]\nseed = 1979-05-27", "bad value 1979-05-27"},
+		{`[central]` + "\n" + `host = "\x41"`, `bad escape \x`},
+		{`[central]` + "\n" + `host = "\101"`, `bad escape \1`},
+		{`[central]` + "\n" + `host = """central"""`, "bad string"},
+		{"[central]\nhost = '''central'''", "bad string"},
+	} {
+		c := Default()
+		err := c.Parse("test.toml", test.data)
+		if err == nil {
+			err = c.Validate()
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, expected one containing %q", test.data, err, test.err)
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.toml")
+	data := "[central]\nhost = \"file.example\"\nport = 1000\n\n[gameserver]\nport = 2000\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Default()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	HostPortVar(fs, &c.Central.Host, &c.Central.Port, "central", "")
+	fs.IntVar(&c.GameServer.Port, "port", c.GameServer.Port, "")
+	fs.StringVar(&c.GameServer.Hostname, "hostname", c.GameServer.Hostname, "")
+	args := []string{"-central=flag.example:3000", "-config=" + path, "-hostname=gs.example"}
+	if err := ParseFlags(fs, args, c); err != nil {
+		t.Fatal(err)
+	}
+	// Flags win over the file, which wins over the defaults
+	if c.Central.HostPort() != "flag.example:3000" {
+		t.Errorf("Central server at %s, expected flag.example:3000", c.Central.HostPort())
+	}
+	if c.GameServer.HostPort() != "gs.example:2000" {
+		t.Errorf("Game server at %s, expected gs.example:2000", c.GameServer.HostPort())
+	}
+	if c.Voting.Interval != Default().Voting.Interval {
+		t.Errorf("Voting interval is %s, expected the default", c.Voting.Interval)
+	}
+}
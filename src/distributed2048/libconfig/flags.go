@@ -0,0 +1,79 @@
+package libconfig
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ParseFlags parses args with fs into c, which the flags of fs are bound to,
+// along with the config file given with -config, if any. Flags given in args
+// win over the file, which wins over the settings c started with. The result
+// is validated.
+func ParseFlags(fs *flag.FlagSet, args []string, c *Config) error {
+	path := fs.String("config", "", "config file to read settings from (flags given override it)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return c.Validate()
+	}
+
+	// Reading the file overwrites the flags bound to c, so the ones given
+	// are set again afterwards. Flags of other types, such as lists, are
+	// not bound to c, and are left alone.
+	var given []*flag.Flag
+	var values []string
+	fs.Visit(func(f *flag.Flag) {
+		if _, ok := f.Value.(flag.Getter); ok {
+			given = append(given, f)
+			values = append(values, f.Value.String())
+		}
+	})
+	if err := c.ReadFile(*path); err != nil {
+		return err
+	}
+	for i, f := range given {
+		if err := f.Value.Set(values[i]); err != nil {
+			return fmt.Errorf("-%s: %s", f.Name, err)
+		}
+	}
+	return c.Validate()
+}
+
+// hostPortValue is a flag that sets a host and a port, given as host:port.
+type hostPortValue struct {
+	host *string
+	port *int
+}
+
+func (v hostPortValue) String() string {
+	if v.host == nil {
+		return "" // the zero value, which flag.PrintDefaults makes
+	}
+	return net.JoinHostPort(*v.host, strconv.Itoa(*v.port))
+}
+
+func (v hostPortValue) Set(s string) error {
+	host, portString, err := net.SplitHostPort(s)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return fmt.Errorf("bad port %q", portString)
+	}
+	*v.host, *v.port = host, port
+	return nil
+}
+
+func (v hostPortValue) Get() interface{} {
+	return v.String()
+}
+
+// HostPortVar defines a flag of fs, given as host:port, that sets host and
+// port.
+func HostPortVar(fs *flag.FlagSet, host *string, port *int, name string, usage string) {
+	fs.Var(hostPortValue{host, port}, name, usage)
+}
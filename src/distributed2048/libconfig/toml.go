@@ -0,0 +1,314 @@
+package libconfig
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry is a key and its value, or a table header if key is empty, as read
+// from a config file.
+type entry struct {
+	table string
+	key   string
+	value interface{} // string, int64, float64 or bool
+	line  int
+}
+
+// parse reads the subset of TOML that config files are written in: comments,
+// [table] headers, and key = value pairs whose values are strings, integers,
+// floats or booleans. Anything else TOML has, such as arrays, inline tables,
+// dotted keys or dates, is an error rather than being misread. Keys before
+// the first header are in the "" table, which decode rejects.
+func parse(name string, data string) ([]entry, error) {
+	var entries []entry
+	seen := make(map[string]bool) // tables and table.keys given so far
+	table := ""
+	for i, line := range strings.Split(data, "\n") {
+		n := i + 1
+		fail := func(format string, args ...interface{}) ([]entry, error) {
+			return nil, fmt.Errorf("%s:%d: %s", name, n, fmt.Sprintf(format, args...))
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			if !isComment(line) {
+				return fail("control character in comment")
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") {
+			return fail("arrays of tables are not supported")
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 || !isComment(line[end+1:]) {
+				return fail("expected [table], got %q", line)
+			}
+			table = strings.TrimSpace(line[1:end])
+			if !isBareKey(table) {
+				return fail("bad table name %q", table)
+			}
+			if seen[table] {
+				return fail("table [%s] is given twice", table)
+			}
+			seen[table] = true
+			entries = append(entries, entry{table: table, line: n})
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return fail("expected key = value, got %q", line)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !isBareKey(key) {
+			return fail("bad key %q", key)
+		}
+		value, err := parseValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return fail("%s: %s", key, err)
+		}
+		if seen[table+"."+key] {
+			return fail("%s is given twice", key)
+		}
+		seen[table+"."+key] = true
+		entries = append(entries, entry{table, key, value, n})
+	}
+	return entries, nil
+}
+
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// isComment returns whether s, the rest of a line, is empty or a comment.
+// Comments may not hold control characters other than tabs.
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return true
+	}
+	if s[0] != '#' {
+		return false
+	}
+	for _, r := range s {
+		if r < ' ' && r != '\t' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// TOML's numbers, which unlike Go's have no leading zeros and only have
+// underscores between digits.
+var (
+	decimalPattern = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)$`)
+	prefixPattern  = regexp.MustCompile(`^0(x[0-9a-fA-F](_?[0-9a-fA-F])*|o[0-7](_?[0-7])*|b[01](_?[01])*)$`)
+	floatPattern   = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`)
+)
+
+// validEscapes are the characters that may follow a backslash in a basic
+// string.
+const validEscapes = `btnfr"\uU`
+
+func parseValue(s string) (interface{}, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"':
+		// Find the closing quote, skipping escaped characters
+		end := -1
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 || !isComment(s[end+1:]) {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		// Go's escapes are a superset of TOML's
+		for i := 1; i < end; i++ {
+			if s[i] == '\\' {
+				if !strings.ContainsRune(validEscapes, rune(s[i+1])) {
+					return nil, fmt.Errorf("bad escape \\%c in %s", s[i+1], s[:end+1])
+				}
+				i++
+			}
+		}
+		str, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", s[:end+1])
+		}
+		return str, nil
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'') + 1
+		if end == 0 || !isComment(s[end+1:]) {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		return s[1:end], nil
+	case '[':
+		return nil, fmt.Errorf("arrays are not supported")
+	case '{':
+		return nil, fmt.Errorf("inline tables are not supported")
+	}
+
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		if !isComment(s[i:]) {
+			return nil, fmt.Errorf("control character in comment")
+		}
+		s = strings.TrimSpace(s[:i])
+		if s == "" {
+			return nil, fmt.Errorf("missing value")
+		}
+	}
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	num := strings.Replace(s, "_", "", -1)
+	if decimalPattern.MatchString(s) {
+		if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+			return n, nil
+		}
+		return nil, fmt.Errorf("%s is out of range", s)
+	}
+	if prefixPattern.MatchString(s) {
+		base := 16
+		switch s[1] {
+		case 'o':
+			base = 8
+		case 'b':
+			base = 2
+		}
+		if n, err := strconv.ParseInt(num[2:], base, 64); err == nil {
+			return n, nil
+		}
+		return nil, fmt.Errorf("%s is out of range", s)
+	}
+	if floatPattern.MatchString(s) {
+		if f, err := strconv.ParseFloat(num, 64); err == nil {
+			return f, nil
+		}
+		return nil, fmt.Errorf("%s is out of range", s)
+	}
+	return nil, fmt.Errorf("bad value %s (strings must be quoted, and numbers written as in TOML)", s)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decode sets the fields of the struct that v points to from entries. Each
+// table is a struct field, and each key a field of that, named by their toml
+// tags.
+func decode(name string, entries []entry, v interface{}) error {
+	root := reflect.ValueOf(v).Elem()
+	for _, e := range entries {
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", name, e.line, fmt.Sprintf(format, args...))
+		}
+		if e.table == "" {
+			return fail("%s is not in a [table]", e.key)
+		}
+		table, ok := field(root, e.table)
+		if !ok || table.Kind() != reflect.Struct {
+			return fail("unknown table [%s]", e.table)
+		}
+		if e.key == "" {
+			continue
+		}
+		f, ok := field(table, e.key)
+		if !ok {
+			return fail("unknown key %s in [%s]", e.key, e.table)
+		}
+		if err := set(f, e.value); err != nil {
+			return fail("%s: %s", e.key, err)
+		}
+	}
+	return nil
+}
+
+// field returns the field of the struct v whose toml tag is name.
+func field(v reflect.Value, name string) (reflect.Value, bool) {
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("toml") == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func set(f reflect.Value, value interface{}) error {
+	if f.Type() == durationType {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration such as \"500ms\", got %v", value)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			f.SetString(s)
+			return nil
+		}
+		return fmt.Errorf("expected a string, got %v", value)
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			f.SetBool(b)
+			return nil
+		}
+		return fmt.Errorf("expected true or false, got %v", value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := value.(int64)
+		if !ok || f.OverflowInt(n) {
+			return fmt.Errorf("expected an integer, got %v", value)
+		}
+		f.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := value.(int64)
+		if !ok || n < 0 || f.OverflowUint(uint64(n)) {
+			return fmt.Errorf("expected a non-negative integer, got %v", value)
+		}
+		f.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch n := value.(type) {
+		case float64:
+			f.SetFloat(n)
+			return nil
+		case int64:
+			f.SetFloat(float64(n))
+			return nil
+		}
+		return fmt.Errorf("expected a number, got %v", value)
+	}
+	return fmt.Errorf("cannot be set from a config file")
+}
@@ -0,0 +1,128 @@
+package libhistory
+
+import (
+	"distributed2048/util"
+	"fmt"
+)
+
+// Divergence is the first point at which a game server disagrees with
+// another, or a client with the game servers.
+type Divergence struct {
+	Slot   uint32 // slot the game servers disagree on, if Client is -1
+	Seq    uint64 // sequence number of the state the client received, otherwise
+	Server int    // game server that applied the value first, or -1 if none did
+	Other  int    // game server that disagrees with it, if Client is -1
+	Client int    // client that received a state outside the history, or -1
+	Want   string // what Server did, or applied at Seq
+	Got    string // what Other did, or what the client received
+}
+
+func (d *Divergence) Error() string {
+	if d.Client >= 0 && d.Server < 0 {
+		return fmt.Sprintf("client %d received a state with seq %d, which no game server applied\nclient %d got: %s",
+			d.Client, d.Seq, d.Client, d.Got)
+	}
+	if d.Client >= 0 {
+		return fmt.Sprintf("client %d received a state at seq %d that differs from what game server %d applied\nclient %d got: %s\ngame server %d applied: %s",
+			d.Client, d.Seq, d.Server, d.Client, d.Got, d.Server, d.Want)
+	}
+	return fmt.Sprintf("game servers %d and %d diverge at slot %d\ngame server %d: %s\ngame server %d: %s",
+		d.Server, d.Other, d.Slot, d.Server, d.Want, d.Other, d.Got)
+}
+
+// Check verifies that the game servers, whose histories are given, applied
+// the same values in every slot that more than one of them remembers, with
+// the same results, and that every state the clients received is one of those
+// results. It returns a *Divergence describing the earliest disagreement it
+// finds, or nil.
+//
+// States older than everything the game servers remember are not checked.
+func Check(servers [][]Entry, clients [][]util.Game2048State) error {
+	first, last := ^uint32(0), uint32(0)
+	for _, entries := range servers {
+		if len(entries) == 0 {
+			continue
+		}
+		if entries[0].Slot < first {
+			first = entries[0].Slot
+		}
+		if s := entries[len(entries)-1].Slot; s > last {
+			last = s
+		}
+	}
+
+	// Compare the servers slot by slot, against the first one to remember
+	// each slot, and collect every state they went through
+	applied := make(map[uint64]Change)
+	appliedBy := make(map[uint64]int)
+	var oldestSeq uint64
+	for slot := first; slot <= last && first <= last; slot++ {
+		var want *Entry
+		wantServer := -1
+		for i, entries := range servers {
+			e := lookup(entries, slot)
+			if e == nil {
+				continue
+			}
+			if want == nil {
+				want, wantServer = e, i
+				continue
+			}
+			if !want.equal(e) {
+				return &Divergence{
+					Slot:   slot,
+					Server: wantServer,
+					Other:  i,
+					Client: -1,
+					Want:   want.String(),
+					Got:    e.String(),
+				}
+			}
+		}
+		if want == nil {
+			continue
+		}
+		for _, c := range want.Changes {
+			if len(applied) == 0 {
+				oldestSeq = c.Seq
+			}
+			applied[c.Seq] = c
+			appliedBy[c.Seq] = wantServer
+		}
+		if slot == last {
+			break // the slot number would wrap around
+		}
+	}
+
+	for i, states := range clients {
+		for _, s := range states {
+			if s.Seq < oldestSeq {
+				continue
+			}
+			c, ok := applied[s.Seq]
+			if !ok {
+				return &Divergence{Seq: s.Seq, Server: -1, Client: i, Got: describe(s)}
+			}
+			if c.State != s.State {
+				return &Divergence{Seq: s.Seq, Server: appliedBy[s.Seq], Client: i, Want: c.String(), Got: describe(s)}
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the entry for the given slot, if entries has one.
+func lookup(entries []Entry, slot uint32) *Entry {
+	if len(entries) == 0 || slot < entries[0].Slot {
+		return nil
+	}
+	i := int(slot - entries[0].Slot)
+	if i >= len(entries) {
+		return nil
+	}
+	return &entries[i]
+}
+
+func describe(s util.Game2048State) string {
+	return fmt.Sprintf("seq %d: %s, score %d", s.Seq, s.Consensus, s.Score)
+}
@@ -0,0 +1,74 @@
+package libhistory
+
+import (
+	"distributed2048/lib2048"
+	"distributed2048/util"
+	"testing"
+)
+
+// play returns the history of a game server that started a game with the
+// given seed and then made the given moves, one slot each.
+func play(seed uint32, dirs ...lib2048.Direction) []Entry {
+	game := lib2048.NewGame2048WithOptions(lib2048.Options{Seed: seed})
+	entries := []Entry{{0, "new game 1", []Change{{1, 0, game.GetState()}}}}
+	for i, dir := range dirs {
+		game.MakeMove(dir)
+		entries = append(entries, Entry{uint32(i + 1), "votes [" + dir.String() + "]", []Change{{uint64(i + 2), dir, game.GetState()}}})
+	}
+	return entries
+}
+
+// received returns the states that a client of the given game server
+// received.
+func received(entries []Entry) []util.Game2048State {
+	var states []util.Game2048State
+	for _, e := range entries {
+		for _, c := range e.Changes {
+			states = append(states, util.Game2048State{State: c.State, Seq: c.Seq})
+		}
+	}
+	return states
+}
+
+func TestCheckAgreeing(t *testing.T) {
+	a := play(1, lib2048.Up, lib2048.Left, lib2048.Down)
+	b := play(1, lib2048.Up, lib2048.Left) // lagging behind
+	c := play(1, lib2048.Up, lib2048.Left, lib2048.Down)[2:]
+	if err := Check([][]Entry{a, b, c}, [][]util.Game2048State{received(a), received(b)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckServersDiverge(t *testing.T) {
+	a := play(1, lib2048.Up, lib2048.Left, lib2048.Down)
+	b := play(1, lib2048.Up, lib2048.Right, lib2048.Down)
+	err := Check([][]Entry{a, b}, nil)
+	d, ok := err.(*Divergence)
+	if !ok {
+		t.Fatalf("Got %v, want a divergence", err)
+	}
+	if d.Client != -1 || d.Slot != 2 || d.Server != 0 || d.Other != 1 {
+		t.Fatalf("Got divergence %+v, want game servers 0 and 1 at slot 2", d)
+	}
+}
+
+func TestCheckClientDiverges(t *testing.T) {
+	a := play(1, lib2048.Up, lib2048.Left, lib2048.Down)
+	other := received(play(2, lib2048.Up))
+	err := Check([][]Entry{a}, [][]util.Game2048State{received(a), other})
+	d, ok := err.(*Divergence)
+	if !ok {
+		t.Fatalf("Got %v, want a divergence", err)
+	}
+	if d.Client != 1 || d.Seq != 1 || d.Server != 0 {
+		t.Fatalf("Got divergence %+v, want client 1 at seq 1", d)
+	}
+
+	// A state further along than any game server got is outside the history
+	// too
+	ahead := received(play(1, lib2048.Up, lib2048.Left, lib2048.Down, lib2048.Up))
+	err = Check([][]Entry{a}, [][]util.Game2048State{ahead})
+	if d, ok := err.(*Divergence); !ok || d.Seq != 5 || d.Server != -1 {
+		t.Fatalf("Got %v, want a divergence at seq 5", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Package libhistory records what each game server did with the values that
+// Paxos decided, and checks that the replicas and their clients agree.
+package libhistory
+
+import (
+	"distributed2048/lib2048"
+	"fmt"
+	"sync"
+)
+
+// Entry is what a game server did with the value decided for one slot.
+type Entry struct {
+	Slot uint32
+	// Value describes the decided value, e.g. "new game 2" or
+	// "votes [Up Up Left]".
+	Value string
+	// Changes lists the state changes that applying the value caused, if
+	// any: a value can start a new game, make a move, or just add votes.
+	Changes []Change
+}
+
+// Change is one state change of a game server's game.
+type Change struct {
+	Seq       uint64            // the server's sequence number after the change
+	Direction lib2048.Direction // the move made, or 0 if a new game started
+	State     lib2048.State     // the game after the change
+}
+
+func (c Change) String() string {
+	if c.Direction == 0 {
+		return fmt.Sprintf("seq %d: new game, score %d", c.Seq, c.State.Score)
+	}
+	return fmt.Sprintf("seq %d: %s, score %d", c.Seq, c.Direction, c.State.Score)
+}
+
+func (e *Entry) String() string {
+	return fmt.Sprintf("slot %d: %s -> %v", e.Slot, e.Value, e.Changes)
+}
+
+// equal returns whether two entries describe the same decided value, applied
+// with the same results.
+func (e *Entry) equal(other *Entry) bool {
+	if e.Slot != other.Slot || e.Value != other.Value || len(e.Changes) != len(other.Changes) {
+		return false
+	}
+	for i := range e.Changes {
+		if e.Changes[i] != other.Changes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// History keeps the latest entries of a game server, in slot order. It is
+// safe for concurrent use.
+type History struct {
+	mutex   sync.Mutex
+	max     int
+	entries []Entry
+}
+
+// NewHistory returns a history that keeps up to max entries, forgetting the
+// oldest ones first.
+func NewHistory(max int) *History {
+	return &History{max: max}
+}
+
+func (h *History) Add(e Entry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.entries) == h.max {
+		copy(h.entries, h.entries[1:])
+		h.entries = h.entries[:len(h.entries)-1]
+	}
+	h.entries = append(h.entries, e)
+}
+
+// AddChange adds a change to the latest entry.
+func (h *History) AddChange(c Change) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if len(h.entries) > 0 {
+		last := &h.entries[len(h.entries)-1]
+		last.Changes = append(last.Changes, c)
+	}
+}
+
+// Entries returns a copy of the entries kept.
+func (h *History) Entries() []Entry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	entries := make([]Entry, len(h.entries))
+	for i, e := range h.entries {
+		entries[i] = e
+		entries[i].Changes = append([]Change(nil), e.Changes...)
+	}
+	return entries
+}
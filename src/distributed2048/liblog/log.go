@@ -0,0 +1,290 @@
+// Package liblog configures the structured, leveled loggers of the game
+// servers, the central server, the Paxos nodes and the clients. Each of them
+// logs as a component, whose level can be changed while it runs, and adds
+// fields such as its node ID to what it logs.
+//
+// Levels are given as a spec: a default level, optionally followed by
+// comma separated component=level pairs, e.g. "info,paxos=debug". The spec
+// and the output format (text or json) are read from the D2048_LOG and
+// D2048_LOG_FORMAT environment variables at startup, and can be overridden
+// with Configure.
+package liblog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Components that log.
+const (
+	GAMESERVER    = "gameserver"
+	PAXOS         = "paxos"
+	CENTRALSERVER = "centralserver"
+	CLIENT        = "client"
+)
+
+const DEFAULT_LEVEL = slog.LevelInfo
+
+// OFF is a level above every other, which turns a logger off.
+const OFF = slog.LevelError + 4
+
+type Format int
+
+const (
+	TEXT Format = iota
+	JSON
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "text":
+		return TEXT, nil
+	case "json":
+		return JSON, nil
+	}
+	return TEXT, fmt.Errorf("unknown log format %q, expected text or json", s)
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	if strings.ToLower(s) == "off" {
+		return OFF, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return l, fmt.Errorf("unknown log level %q, expected debug, info, warn, error or off", s)
+	}
+	return l, nil
+}
+
+func levelName(l slog.Level) string {
+	if l >= OFF {
+		return "off"
+	}
+	return strings.ToLower(l.String())
+}
+
+var (
+	mutex        sync.RWMutex
+	base         slog.Handler // writes records in the chosen format
+	out          io.Writer    = os.Stderr
+	format       Format
+	defaultLevel = new(slog.LevelVar)
+	levels       = make(map[string]*slog.LevelVar) // components with their own level
+)
+
+func init() {
+	defaultLevel.Set(DEFAULT_LEVEL)
+	setBase()
+	if err := Configure(os.Getenv("D2048_LOG"), os.Getenv("D2048_LOG_FORMAT")); err != nil {
+		fmt.Fprintln(os.Stderr, "Ignoring log settings from the environment:", err)
+	}
+}
+
+// setBase makes the handler that records are written with, which must be
+// called with the mutex held, or from init.
+func setBase() {
+	opts := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     slog.LevelDebug, // components filter for themselves
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// Only keep the file name and line, as util.NewLogger did
+			if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+				return slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", filepath.Base(src.File), src.Line))
+			}
+			return a
+		},
+	}
+	if format == JSON {
+		base = slog.NewJSONHandler(out, opts)
+	} else {
+		base = slog.NewTextHandler(out, opts)
+	}
+}
+
+// Configure applies a level spec and an output format. Either may be empty
+// to leave it as it is.
+func Configure(spec, formatName string) error {
+	if formatName != "" {
+		f, err := ParseFormat(formatName)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		format = f
+		setBase()
+		mutex.Unlock()
+	}
+	if spec == "" {
+		return nil
+	}
+
+	// Check the whole spec before applying any of it
+	def, components, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+	defaultLevel.Set(def)
+	for component, l := range components {
+		SetLevel(component, l)
+	}
+	return nil
+}
+
+// CheckSpec returns an error if spec is not a valid level spec.
+func CheckSpec(spec string) error {
+	_, _, err := parseSpec(spec)
+	return err
+}
+
+// parseSpec returns the default level given by spec, or the current one if
+// it gives none, and the levels it gives to components.
+func parseSpec(spec string) (slog.Level, map[string]slog.Level, error) {
+	def := defaultLevel.Level()
+	components := make(map[string]slog.Level)
+	for i, part := range strings.Split(spec, ",") {
+		component, name := "", strings.TrimSpace(part)
+		if kv := strings.SplitN(name, "=", 2); len(kv) == 2 {
+			component, name = kv[0], kv[1]
+		}
+		if component == "" && (i > 0 || strings.Contains(part, "=")) {
+			return 0, nil, fmt.Errorf("expected component=level, got %q", part)
+		}
+		l, err := ParseLevel(name)
+		if err != nil {
+			return 0, nil, err
+		}
+		if component == "" || component == "default" {
+			def = l
+		} else {
+			components[component] = l
+		}
+	}
+	return def, components, nil
+}
+
+// SetOutput makes every logger write to w.
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	out = w
+	setBase()
+	mutex.Unlock()
+}
+
+// SetLevel sets the level of a component, or the default level of those
+// without their own if component is empty.
+func SetLevel(component string, l slog.Level) {
+	if component == "" {
+		defaultLevel.Set(l)
+		return
+	}
+	mutex.Lock()
+	lv, ok := levels[component]
+	if !ok {
+		lv = new(slog.LevelVar)
+		levels[component] = lv
+	}
+	mutex.Unlock()
+	lv.Set(l)
+}
+
+// Levels returns the default level, under the empty component, and the level
+// of every component that has its own.
+func Levels() map[string]slog.Level {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	result := map[string]slog.Level{"": defaultLevel.Level()}
+	for component, lv := range levels {
+		result[component] = lv.Level()
+	}
+	return result
+}
+
+func level(component string) slog.Level {
+	mutex.RLock()
+	lv, ok := levels[component]
+	mutex.RUnlock()
+	if ok {
+		return lv.Level()
+	}
+	return defaultLevel.Level()
+}
+
+// Logger returns a logger for the given component. It follows the
+// component's level, and the output and format, as they change.
+func Logger(component string) *slog.Logger {
+	return slog.New(&handler{component: component})
+}
+
+// handler filters records by its component's level and hands them to the
+// current base handler, along with the attributes and groups added to it.
+type handler struct {
+	component string
+	ops       []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, in order
+}
+
+func (h *handler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= level(h.component)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	mutex.RLock()
+	b := base
+	mutex.RUnlock()
+	b = b.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, op := range h.ops {
+		b = op(b)
+	}
+	return b.Handle(ctx, r)
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &handler{h.component, append(ops, op)}
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(b slog.Handler) slog.Handler { return b.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(b slog.Handler) slog.Handler { return b.WithGroup(name) })
+}
+
+// AdminHandler serves the log levels. GET returns them as JSON, by
+// component, with the default level under "default". POST sets them from the
+// spec given in the level form value, e.g. level=debug or level=paxos=debug.
+// It lets anyone in, so servers mount it behind libauth.RequireAdmin.
+func AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "POST", "PUT":
+			if err := Configure(r.FormValue("level"), ""); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "only GET and POST are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		result := make(map[string]string)
+		for component, l := range Levels() {
+			if component == "" {
+				component = "default"
+			}
+			result[component] = levelName(l)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
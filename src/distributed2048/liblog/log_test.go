@@ -0,0 +1,110 @@
+package liblog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// reset puts the loggers back as they were before a test.
+func reset(t *testing.T) {
+	t.Cleanup(func() {
+		mutex.Lock()
+		levels = make(map[string]*slog.LevelVar)
+		format = TEXT
+		mutex.Unlock()
+		defaultLevel.Set(DEFAULT_LEVEL)
+		SetOutput(os.Stderr)
+	})
+}
+
+func TestConfigure(t *testing.T) {
+	reset(t)
+	if err := Configure("warn,paxos=debug,client=off", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]slog.Level{"": slog.LevelWarn, PAXOS: slog.LevelDebug, CLIENT: OFF}
+	got := Levels()
+	if len(got) != len(want) {
+		t.Fatalf("Got levels %v, expected %v", got, want)
+	}
+	for component, l := range want {
+		if got[component] != l {
+			t.Errorf("Got level %s for %q, expected %s", got[component], component, l)
+		}
+	}
+
+	for _, spec := range []string{"loud", "paxos=loud", "info,debug", "=debug"} {
+		if err := Configure(spec, ""); err == nil {
+			t.Errorf("Spec %q was accepted", spec)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Format xml was accepted")
+	}
+	if Levels()[PAXOS] != slog.LevelDebug {
+		t.Error("A bad spec changed the levels")
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	reset(t)
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	if err := Configure("info,paxos=debug", "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	Logger(GAMESERVER).Debug("hidden")
+	log := Logger(PAXOS).With("node", 2)
+	log.Debug("sent prepare", "slot", 7)
+	SetLevel(PAXOS, slog.LevelInfo)
+	log.Debug("hidden")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Got %d lines, expected 1: %q", len(lines), buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]interface{}{"msg": "sent prepare", "component": PAXOS, "node": 2.0, "slot": 7.0, "level": "DEBUG"} {
+		if record[key] != value {
+			t.Errorf("Got %s=%v, expected %v", key, record[key], value)
+		}
+	}
+	if src, _ := record["source"].(string); !strings.HasPrefix(src, "log_test.go:") {
+		t.Errorf("Got source %q, expected log_test.go:<line>", src)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	reset(t)
+	h := AdminHandler()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/debug/log", strings.NewReader(url.Values{"level": {"error,gameserver=debug"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Got status %d: %s", w.Code, w.Body.String())
+	}
+	var levels map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &levels); err != nil {
+		t.Fatal(err)
+	}
+	if levels["default"] != "error" || levels[GAMESERVER] != "debug" {
+		t.Errorf("Got levels %v", levels)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/debug/log?level=loud", nil))
+	if w.Code != 400 {
+		t.Errorf("Got status %d for a bad level, expected 400", w.Code)
+	}
+}
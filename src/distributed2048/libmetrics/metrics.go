@@ -0,0 +1,291 @@
+// Package libmetrics keeps counters, gauges and histograms, and serves them
+// over HTTP in the Prometheus text format.
+package libmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DEFAULT_BUCKETS are the upper bounds, in seconds, of the buckets of a
+// histogram of latencies.
+var DEFAULT_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// Registry holds a set of metrics. Each game server and central server has
+// its own, so that several can run in one process. It is safe for concurrent
+// use.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics map[string]*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+// metric is a metric with all its series, one for each set of label values.
+type metric struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64 // only for histograms
+
+	mutex  sync.Mutex
+	series map[string]*series // by joined label values
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // the value of a counter or gauge, or the sum of a histogram
+	counts      []uint64 // for histograms, the count of each bucket, then the total
+}
+
+func (r *Registry) register(name, help string, typ metricType, buckets []float64, labels []string) *metric {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.metrics[name]; exists {
+		panic("libmetrics: metric " + name + " registered twice")
+	}
+	m := &metric{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// get returns the series for the given label values, which must be called
+// with the metric's mutex held.
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("libmetrics: metric %s takes %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.typ == histogramType {
+			s.counts = make([]uint64, len(m.buckets)+1)
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(v float64, labelValues []string) {
+	m.mutex.Lock()
+	m.get(labelValues).value += v
+	m.mutex.Unlock()
+}
+
+func (m *metric) value(labelValues []string) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.get(labelValues).value
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct {
+	m *metric
+}
+
+// NewCounter registers a counter, with the given label names. Its methods
+// then take one value for each label, in the same order.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, counterType, nil, labels)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.m.add(1, labelValues)
+}
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("libmetrics: counter " + c.m.name + " cannot go down")
+	}
+	c.m.add(v, labelValues)
+}
+
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.m.value(labelValues)
+}
+
+// Gauge is a value that goes up and down, such as a number of connections.
+type Gauge struct {
+	m *metric
+}
+
+// NewGauge registers a gauge, with the given label names. Its methods then
+// take one value for each label, in the same order.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, gaugeType, nil, labels)}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mutex.Lock()
+	g.m.get(labelValues).value = v
+	g.m.mutex.Unlock()
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.add(v, labelValues)
+}
+
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.m.value(labelValues)
+}
+
+// Histogram counts observations, such as latencies, in buckets.
+type Histogram struct {
+	m *metric
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, in
+// increasing order, and label names. Its methods then take one value for
+// each label, in the same order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("libmetrics: the buckets of histogram " + name + " are not sorted")
+	}
+	return &Histogram{r.register(name, help, histogramType, buckets, labels)}
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.mutex.Lock()
+	defer h.m.mutex.Unlock()
+	s := h.m.get(labelValues)
+	for i, upper := range h.m.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.counts[len(h.m.buckets)]++
+	s.value += v
+}
+
+// Count returns the number of observations made.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	h.m.mutex.Lock()
+	defer h.m.mutex.Unlock()
+	s := h.m.get(labelValues)
+	return s.counts[len(s.counts)-1]
+}
+
+// WriteText writes every metric in the Prometheus text format, sorted by
+// name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]*metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mutex.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (m *metric) write(w *bufio.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labelString(m.labels, s.labelValues, ""), formatValue(s.value))
+			continue
+		}
+		for i, upper := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labelString(m.labels, s.labelValues, formatValue(upper)), s.counts[i])
+		}
+		total := s.counts[len(m.buckets)]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labelString(m.labels, s.labelValues, "+Inf"), total)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labelString(m.labels, s.labelValues, ""), formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labelString(m.labels, s.labelValues, ""), total)
+	}
+}
+
+// labelString returns the labels of a series, in braces, adding the le label
+// of a histogram bucket if le is not empty.
+func labelString(names, values []string, le string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Handler serves the metrics of the given registries, one after the other.
+func Handler(registries ...*Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, reg := range registries {
+			if err := reg.WriteText(w); err != nil {
+				return
+			}
+		}
+	})
+}
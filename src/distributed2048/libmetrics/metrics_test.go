@@ -0,0 +1,47 @@
+package libmetrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests served.", "path")
+	g := r.NewGauge("clients", "Clients connected.")
+	h := r.NewHistogram("latency_seconds", "Request latency.", []float64{0.1, 1})
+
+	c.Inc("/")
+	c.Add(2, `/a"b`)
+	g.Set(3)
+	g.Add(-1)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP clients Clients connected.
+# TYPE clients gauge
+clients 2
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 5.55
+latency_seconds_count 3
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{path="/"} 1
+requests_total{path="/a\"b"} 2
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("Got:\n%s\nwant:\n%s", got, want)
+	}
+	if c.Value("/") != 1 || g.Value() != 2 || h.Count() != 3 {
+		t.Fatalf("Got values %v, %v and %v, want 1, 2 and 3", c.Value("/"), g.Value(), h.Count())
+	}
+}
@@ -0,0 +1,82 @@
+package libpaxos
+
+import (
+	"distributed2048/rpc/paxosrpc"
+	"testing"
+)
+
+// newAcceptor returns a node that no one else talks to, whose acceptor is
+// driven directly.
+func newAcceptor(t *testing.T) *libpaxos {
+	all := []paxosrpc.Node{{0, "lossy:0"}, {1, "lossy:1"}, {2, "lossy:2"}}
+	lp, err := NewLibpaxosWithTransport(0, "lossy:0", all, NewLossyNetwork(1).Transport(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lp.Close() })
+	return lp.(*libpaxos)
+}
+
+func gameValue(id uint32) paxosrpc.ProposalValue {
+	return paxosrpc.ProposalValue{NewGame: &paxosrpc.NewGameProposal{GameNumber: id}}
+}
+
+func accept(t *testing.T, lp *libpaxos, p *paxosrpc.Proposal) {
+	var reply paxosrpc.ReceiveAcceptReply
+	if err := lp.receiveAccept(&paxosrpc.ReceiveAcceptArgs{Proposal: *p}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != paxosrpc.OK {
+		t.Fatalf("Accepting %+v got status %d", p, reply.Status)
+	}
+}
+
+func prepare(t *testing.T, lp *libpaxos, number, slot, nodeID uint32) *paxosrpc.ReceivePrepareReply {
+	args := &paxosrpc.ReceivePrepareArgs{
+		Node:              paxosrpc.Node{ID: nodeID},
+		ProposalNumber:    paxosrpc.ProposalNumber{number, nodeID},
+		CommandSlotNumber: slot,
+	}
+	var reply paxosrpc.ReceivePrepareReply
+	if err := lp.receivePrepare(args, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != paxosrpc.OK {
+		t.Fatalf("Preparing slot %d got status %d", slot, reply.Status)
+	}
+	return &reply
+}
+
+// A node that accepted values for two slots has to answer a prepare for the
+// earlier one with the value it accepted for that slot, which a majority may
+// have accepted already, not with the one for the later slot.
+func TestAcceptedProposalsPerSlot(t *testing.T) {
+	lp := newAcceptor(t)
+	accept(t, lp, paxosrpc.NewProposal(1, 1, 1, gameValue(10)))
+	accept(t, lp, paxosrpc.NewProposal(2, 2, 1, gameValue(20)))
+
+	for _, test := range []struct {
+		slot, game uint32
+	}{{1, 10}, {2, 20}} {
+		reply := prepare(t, lp, 10+test.slot, test.slot, 2)
+		if !reply.HasAcceptedProposal {
+			t.Errorf("Preparing slot %d did not return the proposal accepted for it", test.slot)
+			continue
+		}
+		got := reply.AcceptedProposal
+		if got.CommandSlotNumber != test.slot || got.Value.NewGame == nil || got.Value.NewGame.GameNumber != test.game {
+			t.Errorf("Preparing slot %d returned %+v, expected the game %d accepted for it", test.slot, got, test.game)
+		}
+	}
+	if reply := prepare(t, lp, 20, 3, 2); reply.HasAcceptedProposal {
+		t.Errorf("Preparing slot 3, where nothing was accepted, returned %+v", reply.AcceptedProposal)
+	}
+
+	// Deciding a slot only forgets what was accepted for it
+	lp.dataMutex.Lock()
+	lp.forgetAccepted(1)
+	lp.dataMutex.Unlock()
+	if reply := prepare(t, lp, 30, 2, 2); !reply.HasAcceptedProposal || reply.AcceptedProposal.CommandSlotNumber != 2 {
+		t.Error("Deciding slot 1 forgot the proposal accepted for slot 2")
+	}
+}
@@ -0,0 +1,90 @@
+package libpaxos
+
+import (
+	"distributed2048/libauth"
+	"distributed2048/rpc/faultrpc"
+	"sort"
+)
+
+type faultAdmin struct {
+	fi     *FaultInjector
+	secret libauth.Secret
+}
+
+// NewFaultAdmin returns an RPC service that adds and removes the faults of
+// fi, so that faults can be injected into a running node from outside. Calls
+// that are not signed with secret are turned away, unless it is nil.
+func NewFaultAdmin(fi *FaultInjector, secret libauth.Secret) faultrpc.RemoteFaultInjector {
+	return &faultAdmin{fi, secret}
+}
+
+func (fa *faultAdmin) verify(method string, args interface{}) error {
+	err := fa.secret.Verify(method, args)
+	if err != nil {
+		authLog.Warn("rejected a fault admin call that is not authenticated", "method", method)
+	}
+	return err
+}
+
+func (fa *faultAdmin) AddFault(args *faultrpc.AddFaultArgs, reply *faultrpc.AddFaultReply) error {
+	if err := fa.verify("FaultInjector.AddFault", args); err != nil {
+		return err
+	}
+	rule, err := ParseFaultRule(args.Spec)
+	if err != nil {
+		reply.Status = faultrpc.BadSpec
+		reply.Error = err.Error()
+		return nil
+	}
+	reply.Status = faultrpc.OK
+	reply.ID = fa.fi.AddRule(rule)
+	return nil
+}
+
+func (fa *faultAdmin) Partition(args *faultrpc.PartitionArgs, reply *faultrpc.PartitionReply) error {
+	if err := fa.verify("FaultInjector.Partition", args); err != nil {
+		return err
+	}
+	reply.Status = faultrpc.OK
+	reply.ID = fa.fi.Partition(args.Peers)
+	return nil
+}
+
+func (fa *faultAdmin) RemoveFault(args *faultrpc.RemoveFaultArgs, reply *faultrpc.RemoveFaultReply) error {
+	if err := fa.verify("FaultInjector.RemoveFault", args); err != nil {
+		return err
+	}
+	if fa.fi.RemoveRule(args.ID) {
+		reply.Status = faultrpc.OK
+	} else {
+		reply.Status = faultrpc.NotFound
+	}
+	return nil
+}
+
+func (fa *faultAdmin) ListFaults(args *faultrpc.ListFaultsArgs, reply *faultrpc.ListFaultsReply) error {
+	if err := fa.verify("FaultInjector.ListFaults", args); err != nil {
+		return err
+	}
+	rules := fa.fi.Rules()
+	ids := make([]int, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	reply.Status = faultrpc.OK
+	reply.Faults = make([]faultrpc.Fault, len(ids))
+	for i, id := range ids {
+		reply.Faults[i] = faultrpc.Fault{id, rules[id].String()}
+	}
+	return nil
+}
+
+func (fa *faultAdmin) ClearFaults(args *faultrpc.ClearFaultsArgs, reply *faultrpc.ClearFaultsReply) error {
+	if err := fa.verify("FaultInjector.ClearFaults", args); err != nil {
+		return err
+	}
+	fa.fi.ClearRules()
+	reply.Status = faultrpc.OK
+	return nil
+}
@@ -0,0 +1,409 @@
+package libpaxos
+
+import (
+	"distributed2048/rpc/paxosrpc"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaultKind is what happens to a message hit by a fault.
+type FaultKind int
+
+const (
+	// FaultDrop loses the message, so that the sender times out.
+	FaultDrop FaultKind = iota + 1
+	// FaultDelay holds the message up for the rule's Delay.
+	FaultDelay
+	// FaultFail fails the message straight away with an error.
+	FaultFail
+	// FaultCrash fails the message and calls the crash handler, which is
+	// expected to stop the node and start it again. A crash rule is removed
+	// once it has fired.
+	FaultCrash
+)
+
+// FaultDirection is which of a node's messages a fault applies to.
+type FaultDirection int
+
+const (
+	FaultBoth     FaultDirection = iota // messages both sent and received
+	FaultIncoming                       // messages received from other nodes
+	FaultOutgoing                       // messages sent to other nodes
+)
+
+// ErrInjected is returned for messages failed on purpose.
+var ErrInjected = errors.New("injected fault")
+
+// FaultRule picks out some of a node's messages, and says what to do to
+// them. The zero value of each field matches every message.
+type FaultRule struct {
+	Kind      FaultKind
+	Direction FaultDirection
+	Action    PaxosAction // Prepare, Accept or Decide, or 0 for all of them
+	Peers     []uint32    // nodes on the other end of the message, all if empty
+	// Only messages for slots between MinSlot and MaxSlot (inclusive) are
+	// hit. A MaxSlot of 0 means no upper bound.
+	MinSlot, MaxSlot uint32
+	// Chance is the probability that a matching message is hit; 0 means
+	// every one.
+	Chance float64
+	// If Nth is positive, only the Nth message that the rule matches is hit.
+	Nth   int
+	Delay time.Duration // how long FaultDelay holds messages up
+
+	matched int // number of messages matched so far
+}
+
+func (r *FaultRule) matches(dir FaultDirection, action PaxosAction, peer, slot uint32) bool {
+	if r.Direction != FaultBoth && r.Direction != dir {
+		return false
+	}
+	if r.Action != 0 && r.Action != action {
+		return false
+	}
+	if slot < r.MinSlot || (r.MaxSlot != 0 && slot > r.MaxSlot) {
+		return false
+	}
+	return matchesID(r.Peers, peer)
+}
+
+var faultKindNames = map[FaultKind]string{
+	FaultDrop:  "drop",
+	FaultDelay: "delay",
+	FaultFail:  "fail",
+	FaultCrash: "crash",
+}
+
+var faultDirectionNames = map[FaultDirection]string{
+	FaultBoth:     "both",
+	FaultIncoming: "in",
+	FaultOutgoing: "out",
+}
+
+var actionNames = map[PaxosAction]string{
+	0:       "any",
+	Prepare: "prepare",
+	Accept:  "accept",
+	Decide:  "decide",
+}
+
+// String returns the rule in the form that ParseFaultRule reads.
+func (r FaultRule) String() string {
+	parts := []string{faultKindNames[r.Kind]}
+	if r.Direction != FaultBoth {
+		parts = append(parts, "dir="+faultDirectionNames[r.Direction])
+	}
+	if r.Action != 0 {
+		parts = append(parts, "action="+actionNames[r.Action])
+	}
+	if len(r.Peers) > 0 {
+		peers := make([]string, len(r.Peers))
+		for i, p := range r.Peers {
+			peers[i] = strconv.Itoa(int(p))
+		}
+		parts = append(parts, "peers="+strings.Join(peers, "+"))
+	}
+	if r.MinSlot != 0 || r.MaxSlot != 0 {
+		parts = append(parts, fmt.Sprintf("slots=%d-%d", r.MinSlot, r.MaxSlot))
+	}
+	if r.Chance != 0 {
+		parts = append(parts, fmt.Sprintf("chance=%g", r.Chance))
+	}
+	if r.Nth != 0 {
+		parts = append(parts, fmt.Sprintf("nth=%d", r.Nth))
+	}
+	if r.Kind == FaultDelay {
+		parts = append(parts, "delay="+r.Delay.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseFaultRule reads a rule written as its kind (drop, delay, fail or
+// crash), followed by comma separated key=value settings:
+//
+//	dir=in|out|both
+//	action=prepare|accept|decide|any
+//	peers=<id>+<id>...
+//	slots=<min>-<max>   (a max of 0 means no upper bound)
+//	chance=<probability>
+//	nth=<n>
+//	delay=<duration>    (required for delay)
+//
+// For example "delay,dir=out,action=accept,peers=1+2,delay=2s" holds up the
+// accept messages sent to nodes 1 and 2 for two seconds each.
+func ParseFaultRule(s string) (FaultRule, error) {
+	var r FaultRule
+	fields := strings.Split(s, ",")
+	found := false
+	for kind, name := range faultKindNames {
+		if strings.TrimSpace(fields[0]) == name {
+			r.Kind, found = kind, true
+		}
+	}
+	if !found {
+		return r, fmt.Errorf("unknown fault %q, expected drop, delay, fail or crash", fields[0])
+	}
+
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return r, fmt.Errorf("expected key=value, got %q", field)
+		}
+		key, value := kv[0], kv[1]
+		var err error
+		switch key {
+		case "dir":
+			found := false
+			for dir, name := range faultDirectionNames {
+				if value == name {
+					r.Direction, found = dir, true
+				}
+			}
+			if !found {
+				err = fmt.Errorf("unknown direction %q", value)
+			}
+		case "action":
+			found := false
+			for action, name := range actionNames {
+				if value == name {
+					r.Action, found = action, true
+				}
+			}
+			if !found {
+				err = fmt.Errorf("unknown action %q", value)
+			}
+		case "peers":
+			for _, p := range strings.Split(value, "+") {
+				var id uint64
+				if id, err = strconv.ParseUint(p, 10, 32); err != nil {
+					break
+				}
+				r.Peers = append(r.Peers, uint32(id))
+			}
+		case "slots":
+			if _, err = fmt.Sscanf(value, "%d-%d", &r.MinSlot, &r.MaxSlot); err == nil && r.MaxSlot != 0 && r.MaxSlot < r.MinSlot {
+				err = errors.New("the slot range is empty")
+			}
+		case "chance":
+			if r.Chance, err = strconv.ParseFloat(value, 64); err == nil && (r.Chance < 0 || r.Chance > 1) {
+				err = errors.New("chance must be between 0 and 1")
+			}
+		case "nth":
+			r.Nth, err = strconv.Atoi(value)
+		case "delay":
+			r.Delay, err = time.ParseDuration(value)
+		default:
+			err = errors.New("unknown setting")
+		}
+		if err != nil {
+			return r, fmt.Errorf("bad %s in fault %q: %s", key, s, err)
+		}
+	}
+	if r.Kind == FaultDelay && r.Delay <= 0 {
+		return r, fmt.Errorf("fault %q needs a positive delay", s)
+	}
+	return r, nil
+}
+
+// FaultInjector applies fault rules to the messages that a node sends and
+// receives. Rules are checked in the order they were added, and the first
+// one that hits a message decides what happens to it. It is safe for
+// concurrent use.
+type FaultInjector struct {
+	mutex   sync.Mutex
+	rules   map[int]*FaultRule
+	nextID  int
+	r       *rand.Rand
+	onCrash func(action PaxosAction, slotNumber uint32)
+	log     *slog.Logger
+}
+
+func newFaultInjector(log *slog.Logger) *FaultInjector {
+	return &FaultInjector{
+		rules: make(map[int]*FaultRule),
+		r:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		log:   log,
+	}
+}
+
+// AddRule adds a rule and returns its ID.
+func (fi *FaultInjector) AddRule(rule FaultRule) int {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	fi.nextID++
+	rule.Peers = append([]uint32(nil), rule.Peers...)
+	rule.matched = 0
+	fi.rules[fi.nextID] = &rule
+	return fi.nextID
+}
+
+// RemoveRule removes the rule with the given ID, and returns whether there
+// was one.
+func (fi *FaultInjector) RemoveRule(id int) bool {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	_, ok := fi.rules[id]
+	delete(fi.rules, id)
+	return ok
+}
+
+// ClearRules removes every rule.
+func (fi *FaultInjector) ClearRules() {
+	fi.mutex.Lock()
+	fi.rules = make(map[int]*FaultRule)
+	fi.mutex.Unlock()
+}
+
+// Rules returns the rules in force, by ID.
+func (fi *FaultInjector) Rules() map[int]FaultRule {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	rules := make(map[int]FaultRule, len(fi.rules))
+	for id, r := range fi.rules {
+		rules[id] = *r
+	}
+	return rules
+}
+
+// Partition cuts the node off from the given peers, in both directions, and
+// returns the ID of the rule that does so.
+func (fi *FaultInjector) Partition(peers []uint32) int {
+	return fi.AddRule(FaultRule{Kind: FaultDrop, Peers: peers})
+}
+
+// SetSeed makes the chances of the rules be drawn from the given seed.
+func (fi *FaultInjector) SetSeed(seed int64) {
+	fi.mutex.Lock()
+	fi.r = rand.New(rand.NewSource(seed))
+	fi.mutex.Unlock()
+}
+
+// OnCrash sets the function called, in a new goroutine, when a crash rule
+// fires. Without one, crash rules only fail the message.
+func (fi *FaultInjector) OnCrash(f func(action PaxosAction, slotNumber uint32)) {
+	fi.mutex.Lock()
+	fi.onCrash = f
+	fi.mutex.Unlock()
+}
+
+// hit returns the rule that hits the given message, if any.
+func (fi *FaultInjector) hit(dir FaultDirection, action PaxosAction, peer, slot uint32) *FaultRule {
+	fi.mutex.Lock()
+	defer fi.mutex.Unlock()
+	ids := make([]int, 0, len(fi.rules))
+	for id := range fi.rules {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		r := fi.rules[id]
+		if !r.matches(dir, action, peer, slot) {
+			continue
+		}
+		r.matched++
+		if r.Nth > 0 && r.matched != r.Nth {
+			continue
+		}
+		if r.Chance > 0 && fi.r.Float64() >= r.Chance {
+			continue
+		}
+		hit := *r
+		if r.Kind == FaultCrash {
+			delete(fi.rules, id)
+			if fi.onCrash != nil {
+				go fi.onCrash(action, slot)
+			}
+		}
+		return &hit
+	}
+	return nil
+}
+
+// apply does what the rules say to a message, and returns the error to fail
+// it with, if any.
+func (fi *FaultInjector) apply(dir FaultDirection, action PaxosAction, peer, slot uint32) error {
+	r := fi.hit(dir, action, peer, slot)
+	if r == nil {
+		return nil
+	}
+	fi.log.Debug("injecting fault", "fault", r.String(), "action", actionNames[action], "slot", slot, "peer", peer)
+	switch r.Kind {
+	case FaultDrop:
+		if dir == FaultIncoming {
+			// Keep the sender waiting, as if the message never arrived
+			time.Sleep(RPCTimeout)
+		}
+		return ErrTimeout
+	case FaultDelay:
+		time.Sleep(r.Delay)
+		return nil
+	default:
+		return ErrInjected
+	}
+}
+
+// faultTransport applies a FaultInjector to the messages going through a
+// transport.
+type faultTransport struct {
+	Transport
+	fi *FaultInjector
+}
+
+func (t *faultTransport) Serve(node paxosrpc.RemotePaxosNode) error {
+	return t.Transport.Serve(&faultNode{node, t.fi})
+}
+
+func (t *faultTransport) Prepare(to paxosrpc.Node, args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
+	if err := t.fi.apply(FaultOutgoing, Prepare, to.ID, args.CommandSlotNumber); err != nil {
+		return err
+	}
+	return t.Transport.Prepare(to, args, reply)
+}
+
+func (t *faultTransport) Accept(to paxosrpc.Node, args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error {
+	if err := t.fi.apply(FaultOutgoing, Accept, to.ID, args.Proposal.CommandSlotNumber); err != nil {
+		return err
+	}
+	return t.Transport.Accept(to, args, reply)
+}
+
+func (t *faultTransport) Decide(to paxosrpc.Node, args *paxosrpc.ReceiveDecideArgs, reply *paxosrpc.ReceiveDecideReply) error {
+	if err := t.fi.apply(FaultOutgoing, Decide, to.ID, args.Proposal.CommandSlotNumber); err != nil {
+		return err
+	}
+	return t.Transport.Decide(to, args, reply)
+}
+
+// faultNode applies a FaultInjector to the messages a node receives.
+type faultNode struct {
+	node paxosrpc.RemotePaxosNode
+	fi   *FaultInjector
+}
+
+func (n *faultNode) ReceivePrepare(args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
+	if err := n.fi.apply(FaultIncoming, Prepare, args.Node.ID, args.CommandSlotNumber); err != nil {
+		return err
+	}
+	return n.node.ReceivePrepare(args, reply)
+}
+
+func (n *faultNode) ReceiveAccept(args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error {
+	if err := n.fi.apply(FaultIncoming, Accept, args.Proposal.Number.NodeID, args.Proposal.CommandSlotNumber); err != nil {
+		return err
+	}
+	return n.node.ReceiveAccept(args, reply)
+}
+
+func (n *faultNode) ReceiveDecide(args *paxosrpc.ReceiveDecideArgs, reply *paxosrpc.ReceiveDecideReply) error {
+	if err := n.fi.apply(FaultIncoming, Decide, args.Proposal.Number.NodeID, args.Proposal.CommandSlotNumber); err != nil {
+		return err
+	}
+	return n.node.ReceiveDecide(args, reply)
+}
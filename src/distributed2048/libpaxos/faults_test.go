@@ -0,0 +1,77 @@
+package libpaxos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFaultRule(t *testing.T) {
+	for _, spec := range []string{
+		"drop",
+		"delay,dir=out,action=accept,peers=1+2,delay=2s",
+		"fail,dir=in,slots=3-10,chance=0.5,nth=3",
+		"crash,action=decide,slots=5-0",
+	} {
+		r, err := ParseFaultRule(spec)
+		if err != nil {
+			t.Fatalf("Could not parse %q: %s", spec, err)
+		}
+		if r.String() != spec {
+			t.Errorf("Parsed %q, which reads back as %q", spec, r.String())
+		}
+	}
+	for _, spec := range []string{"", "lag", "delay", "drop,dir=up", "drop,chance=2", "drop,slots=5-3", "drop,peers=a"} {
+		if _, err := ParseFaultRule(spec); err == nil {
+			t.Errorf("Parsed bad fault %q", spec)
+		}
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	c := newLossyCluster(t, 3)
+	c.ln.AddRule(LossyRule{MaxDelay: 2 * time.Millisecond})
+
+	// Node 2 never hears from node 1, and node 0 loses its third accept and
+	// crashes on its first decide
+	c.nodes[2].Faults().Partition([]uint32{1})
+	c.nodes[0].Faults().AddRule(FaultRule{Kind: FaultFail, Direction: FaultOutgoing, Action: Accept, Nth: 3})
+	crashed := make(chan PaxosAction, 10)
+	c.nodes[0].Faults().OnCrash(func(action PaxosAction, slotNumber uint32) {
+		crashed <- action
+	})
+	c.nodes[0].Faults().AddRule(FaultRule{Kind: FaultCrash, Direction: FaultOutgoing, Action: Decide})
+
+	var ids []uint32
+	own := make([][]uint32, len(c.nodes))
+	for round := 0; round < 5; round++ {
+		for i := range c.nodes {
+			id := uint32(round*len(c.nodes) + i)
+			c.propose(i, id)
+			ids = append(ids, id)
+			own[i] = append(own[i], id)
+		}
+	}
+	for i := range c.nodes {
+		c.waitDecided(i, own[i])
+	}
+
+	select {
+	case action := <-crashed:
+		if action != Decide {
+			t.Fatalf("Crashed on %s, want %s", actionNames[action], actionNames[Decide])
+		}
+	case <-time.After(settleTimeout):
+		t.Fatal("Node 0 did not crash")
+	}
+	if len(crashed) != 0 {
+		t.Fatal("A crash fault fired more than once")
+	}
+	if n := len(c.nodes[0].Faults().Rules()); n != 1 {
+		t.Fatalf("Node 0 has %d faults left, want 1", n)
+	}
+
+	for _, lp := range c.nodes {
+		lp.Faults().ClearRules()
+	}
+	c.settle(ids)
+}
@@ -0,0 +1,337 @@
+package libpaxos
+
+import (
+	"distributed2048/rpc/paxosrpc"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// LossyRule describes how a LossyNetwork treats the messages sent from any of
+// From to any of To. An empty list matches every node.
+type LossyRule struct {
+	From, To  []uint32
+	Drop      float64 // chance that a message, or its reply, is lost, so that the sender times out
+	Duplicate float64 // chance that a message is delivered twice
+	Reorder   float64 // chance that a message is held back until the next one on its link overtakes it
+	// Every message is delayed by a time picked uniformly between MinDelay
+	// and MaxDelay.
+	MinDelay, MaxDelay time.Duration
+}
+
+func (r *LossyRule) matches(from, to uint32) bool {
+	return matchesID(r.From, from) && matchesID(r.To, to)
+}
+
+func matchesID(ids []uint32, id uint32) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// REORDER_HOLD_MILLISEC is how long a reordered message waits for another
+// message to overtake it before it is delivered anyway.
+const REORDER_HOLD_MILLISEC = 50
+
+var errLossyUnreachable = errors.New("node is not on the network")
+
+// LossyNetwork connects Paxos nodes inside one process, through an unreliable
+// network that drops, delays, duplicates and reorders messages according to
+// its rules, and that can be partitioned.
+//
+// What happens to each message is drawn from a random source per link (a
+// pair of sender and receiver), seeded from the network's seed: the n-th
+// message sent over a link meets the same fate for the same seed and rules.
+// It is not a deterministic simulation, though. Messages are delivered on the
+// senders' goroutines, in real time, and the nodes time out on real clocks,
+// so which message ends up n-th on a link differs from one run to the next,
+// and a seed does not replay a run. Trace shows what happened to every
+// message of the run instead.
+type LossyNetwork struct {
+	seed int64
+
+	mutex  sync.Mutex
+	nodes  map[uint32]paxosrpc.RemotePaxosNode
+	rules  []LossyRule
+	groups map[uint32]int // partition of each node, nil if the network is whole
+	links  map[lossyLinkKey]*lossyLink
+	trace  []string
+
+	deliverMutex sync.Mutex // messages are handled one at a time
+}
+
+type lossyLinkKey struct {
+	from, to uint32
+}
+
+type lossyLink struct {
+	r    *rand.Rand
+	sent int
+	held chan struct{} // closed to release the message held back, if any
+}
+
+func NewLossyNetwork(seed int64) *LossyNetwork {
+	return &LossyNetwork{
+		seed:  seed,
+		nodes: make(map[uint32]paxosrpc.RemotePaxosNode),
+		links: make(map[lossyLinkKey]*lossyLink),
+	}
+}
+
+func (ln *LossyNetwork) Seed() int64 {
+	return ln.seed
+}
+
+// Transport returns the transport for the node with the given ID, to pass to
+// NewLibpaxosWithTransport. Closing it takes the node off the network, as if
+// it had crashed.
+func (ln *LossyNetwork) Transport(id uint32) Transport {
+	return &lossyTransport{ln, id}
+}
+
+// AddRule adds a rule for the messages it matches. When several rules match
+// a message, the first one added applies.
+func (ln *LossyNetwork) AddRule(rule LossyRule) {
+	ln.mutex.Lock()
+	ln.rules = append(ln.rules, rule)
+	ln.mutex.Unlock()
+}
+
+// ClearRules makes the network reliable again.
+func (ln *LossyNetwork) ClearRules() {
+	ln.mutex.Lock()
+	ln.rules = nil
+	ln.mutex.Unlock()
+}
+
+// Partition splits the network into the given groups of nodes. Messages
+// between groups are lost, and so are those to or from a node that is in no
+// group.
+func (ln *LossyNetwork) Partition(groups ...[]uint32) {
+	ln.mutex.Lock()
+	ln.groups = make(map[uint32]int)
+	for i, group := range groups {
+		for _, id := range group {
+			ln.groups[id] = i
+		}
+	}
+	ln.mutex.Unlock()
+}
+
+// Heal undoes Partition.
+func (ln *LossyNetwork) Heal() {
+	ln.mutex.Lock()
+	ln.groups = nil
+	ln.mutex.Unlock()
+}
+
+// Trace returns what happened to every message sent so far, in the order
+// they were sent.
+func (ln *LossyNetwork) Trace() []string {
+	ln.mutex.Lock()
+	defer ln.mutex.Unlock()
+	return append([]string(nil), ln.trace...)
+}
+
+func (ln *LossyNetwork) partitioned(from, to uint32) bool {
+	if ln.groups == nil {
+		return false
+	}
+	fromGroup, ok1 := ln.groups[from]
+	toGroup, ok2 := ln.groups[to]
+	return !ok1 || !ok2 || fromGroup != toGroup
+}
+
+// lossyFate is what happens to one message.
+type lossyFate struct {
+	unreachable bool
+	dropRequest bool
+	dropReply   bool
+	duplicate   bool
+	reorder     bool
+	delay       time.Duration
+	node        paxosrpc.RemotePaxosNode
+	link        *lossyLink
+}
+
+func (f *lossyFate) String() string {
+	switch {
+	case f.unreachable:
+		return "unreachable"
+	case f.dropRequest:
+		return "dropped"
+	}
+	s := fmt.Sprintf("delayed %s", f.delay)
+	if f.reorder {
+		s += ", reordered"
+	}
+	if f.duplicate {
+		s += ", duplicated"
+	}
+	if f.dropReply {
+		s += ", reply dropped"
+	}
+	return s
+}
+
+// decide draws the fate of the next message from one node to another.
+func (ln *LossyNetwork) decide(from, to uint32, desc string) *lossyFate {
+	ln.mutex.Lock()
+	defer ln.mutex.Unlock()
+	key := lossyLinkKey{from, to}
+	link, ok := ln.links[key]
+	if !ok {
+		link = &lossyLink{r: rand.New(rand.NewSource(ln.seed ^ int64(from)<<40 ^ int64(to)<<20))}
+		ln.links[key] = link
+	}
+	link.sent++
+
+	// Always draw the same numbers, so that changing the rules does not
+	// shift the fates of the messages that follow
+	dropDraw, whichDraw, dupDraw, reorderDraw, delayDraw := link.r.Float64(), link.r.Float64(), link.r.Float64(), link.r.Float64(), link.r.Float64()
+
+	f := &lossyFate{node: ln.nodes[to], link: link}
+	f.unreachable = f.node == nil
+	if ln.partitioned(from, to) {
+		f.dropRequest = true
+	}
+	for _, rule := range ln.rules {
+		if !rule.matches(from, to) {
+			continue
+		}
+		if dropDraw < rule.Drop {
+			if whichDraw < 0.5 {
+				f.dropRequest = true
+			} else {
+				f.dropReply = true
+			}
+		}
+		f.duplicate = dupDraw < rule.Duplicate
+		f.reorder = reorderDraw < rule.Reorder
+		f.delay = rule.MinDelay + time.Duration(delayDraw*float64(rule.MaxDelay-rule.MinDelay))
+		break
+	}
+	ln.trace = append(ln.trace, fmt.Sprintf("%d->%d #%d %s: %s", from, to, link.sent, desc, f))
+	return f
+}
+
+// send delivers a message from one node to another, by calling deliver with
+// the receiving node once, or twice if the message is duplicated.
+func (ln *LossyNetwork) send(from, to uint32, desc string, deliver func(node paxosrpc.RemotePaxosNode, duplicate bool) error) error {
+	f := ln.decide(from, to, desc)
+	if f.unreachable {
+		return errLossyUnreachable
+	}
+	if f.dropRequest {
+		return ErrTimeout
+	}
+	time.Sleep(f.delay)
+
+	if f.reorder {
+		ln.mutex.Lock()
+		held := f.link.held
+		if held == nil {
+			held = make(chan struct{})
+			f.link.held = held
+		}
+		ln.mutex.Unlock()
+		select {
+		case <-held:
+		case <-time.After(REORDER_HOLD_MILLISEC * time.Millisecond):
+			ln.release(f.link, held)
+		}
+	}
+
+	ln.deliverMutex.Lock()
+	err := deliver(f.node, false)
+	if f.duplicate {
+		deliver(f.node, true)
+	}
+	ln.deliverMutex.Unlock()
+	if !f.reorder {
+		// This message has overtaken the one held back, if any
+		ln.mutex.Lock()
+		held := f.link.held
+		ln.mutex.Unlock()
+		if held != nil {
+			ln.release(f.link, held)
+		}
+	}
+
+	if f.dropReply {
+		return ErrTimeout
+	}
+	return err
+}
+
+// release lets the message held back on link go, if it still is.
+func (ln *LossyNetwork) release(link *lossyLink, held chan struct{}) {
+	ln.mutex.Lock()
+	if link.held == held {
+		close(held)
+		link.held = nil
+	}
+	ln.mutex.Unlock()
+}
+
+// lossyTransport is one node's view of a LossyNetwork.
+type lossyTransport struct {
+	ln *LossyNetwork
+	id uint32
+}
+
+func (t *lossyTransport) Serve(node paxosrpc.RemotePaxosNode) error {
+	t.ln.mutex.Lock()
+	defer t.ln.mutex.Unlock()
+	if _, ok := t.ln.nodes[t.id]; ok {
+		return fmt.Errorf("node %d is already on the network", t.id)
+	}
+	t.ln.nodes[t.id] = node
+	return nil
+}
+
+func (t *lossyTransport) Prepare(to paxosrpc.Node, args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
+	desc := fmt.Sprintf("Prepare %s slot %d", args.ProposalNumber.String(), args.CommandSlotNumber)
+	return t.ln.send(t.id, to.ID, desc, func(node paxosrpc.RemotePaxosNode, duplicate bool) error {
+		if duplicate {
+			return node.ReceivePrepare(args, &paxosrpc.ReceivePrepareReply{})
+		}
+		return node.ReceivePrepare(args, reply)
+	})
+}
+
+func (t *lossyTransport) Accept(to paxosrpc.Node, args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error {
+	desc := fmt.Sprintf("Accept %s slot %d", args.Proposal.Number.String(), args.Proposal.CommandSlotNumber)
+	return t.ln.send(t.id, to.ID, desc, func(node paxosrpc.RemotePaxosNode, duplicate bool) error {
+		if duplicate {
+			return node.ReceiveAccept(args, &paxosrpc.ReceiveAcceptReply{})
+		}
+		return node.ReceiveAccept(args, reply)
+	})
+}
+
+func (t *lossyTransport) Decide(to paxosrpc.Node, args *paxosrpc.ReceiveDecideArgs, reply *paxosrpc.ReceiveDecideReply) error {
+	desc := fmt.Sprintf("Decide %s slot %d", args.Proposal.Number.String(), args.Proposal.CommandSlotNumber)
+	return t.ln.send(t.id, to.ID, desc, func(node paxosrpc.RemotePaxosNode, duplicate bool) error {
+		if duplicate {
+			return node.ReceiveDecide(args, &paxosrpc.ReceiveDecideReply{})
+		}
+		return node.ReceiveDecide(args, reply)
+	})
+}
+
+// Close takes the node off the network.
+func (t *lossyTransport) Close() error {
+	t.ln.mutex.Lock()
+	delete(t.ln.nodes, t.id)
+	t.ln.mutex.Unlock()
+	return nil
+}
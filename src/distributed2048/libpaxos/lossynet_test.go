@@ -0,0 +1,252 @@
+package libpaxos
+
+import (
+	"context"
+	"distributed2048/lib2048"
+	"distributed2048/rpc/paxosrpc"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var seed = flag.Int64("seed", 0, "seed for the lossy network, picked from the clock if 0")
+
+const settleTimeout = 20 * time.Second
+
+// lossyCluster is a set of Paxos nodes on a LossyNetwork, which records the
+// values each node decides. Values are told apart by their game number.
+type lossyCluster struct {
+	t     *testing.T
+	ln    *LossyNetwork
+	nodes []Libpaxos
+
+	mutex   sync.Mutex
+	decided [][]uint32
+}
+
+func newLossyCluster(t *testing.T, n int) *lossyCluster {
+	s := *seed
+	if s == 0 {
+		s = time.Now().UnixNano()
+	}
+	c := &lossyCluster{t: t, ln: NewLossyNetwork(s), decided: make([][]uint32, n)}
+	all := make([]paxosrpc.Node, n)
+	for i := range all {
+		all[i] = paxosrpc.Node{uint32(i), fmt.Sprintf("lossy:%d", i)}
+	}
+	for i := range all {
+		lp, err := NewLibpaxosWithTransport(all[i].ID, all[i].HostPort, all, c.ln.Transport(all[i].ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := i
+		lp.DecidedHandler(func(value *paxosrpc.ProposalValue) {
+			c.mutex.Lock()
+			c.decided[i] = append(c.decided[i], value.NewGame.GameNumber)
+			c.mutex.Unlock()
+		})
+		c.nodes = append(c.nodes, lp)
+	}
+	t.Cleanup(func() {
+		for _, lp := range c.nodes {
+			lp.Close()
+		}
+		if t.Failed() {
+			t.Logf("Failed with -seed=%d. Last messages:\n%s", c.ln.Seed(), strings.Join(lastLines(c.ln.Trace(), 40), "\n"))
+		}
+	})
+	return c
+}
+
+func lastLines(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// propose has node i propose the value numbered id.
+func (c *lossyCluster) propose(i int, id uint32) {
+	if err := c.nodes[i].Propose(&paxosrpc.ProposalValue{NewGame: &paxosrpc.NewGameProposal{id, lib2048.Options{}}}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// hasDecided returns whether node i has decided all of ids.
+func (c *lossyCluster) hasDecided(i int, ids []uint32) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	seen := make(map[uint32]bool)
+	for _, id := range c.decided[i] {
+		seen[id] = true
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// waitDecided waits until node i has decided all of ids.
+func (c *lossyCluster) waitDecided(i int, ids []uint32) {
+	deadline := time.Now().Add(settleTimeout)
+	for !c.hasDecided(i, ids) {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("Node %d did not decide all of %v", i, ids)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// settle makes the network reliable, has every node propose one more value so
+// that those who missed a decision catch up, and checks that every node
+// decided all of ids, in the same order.
+func (c *lossyCluster) settle(ids []uint32) {
+	c.ln.ClearRules()
+	c.ln.Heal()
+	for i := range c.nodes {
+		id := uint32(1000000 + i)
+		c.propose(i, id)
+		ids = append(ids, id)
+	}
+
+	deadline := time.Now().Add(settleTimeout)
+	for {
+		c.mutex.Lock()
+		done, diff := c.compare(ids)
+		c.mutex.Unlock()
+		if done {
+			if diff != "" {
+				c.t.Fatal(diff)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("Nodes did not settle: %s", diff)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// compare returns whether every node has decided all of ids, and if so,
+// where the first node to differ from node 0 diverges. If not, it says which
+// value is missing. It must be called with the mutex held.
+func (c *lossyCluster) compare(ids []uint32) (bool, string) {
+	for i, decided := range c.decided {
+		seen := make(map[uint32]bool)
+		for _, id := range decided {
+			seen[id] = true
+		}
+		for _, id := range ids {
+			if !seen[id] {
+				return false, fmt.Sprintf("node %d has not decided value %d", i, id)
+			}
+		}
+	}
+	for i, decided := range c.decided {
+		if len(decided) != len(c.decided[0]) {
+			return false, fmt.Sprintf("node %d decided %d values, node 0 decided %d", i, len(decided), len(c.decided[0]))
+		}
+		for slot := range decided {
+			if decided[slot] != c.decided[0][slot] {
+				return true, fmt.Sprintf("node %d decided value %d in slot %d, node 0 decided %d", i, decided[slot], slot, c.decided[0][slot])
+			}
+		}
+	}
+	return true, ""
+}
+
+func TestLossyNetworkUnreliable(t *testing.T) {
+	c := newLossyCluster(t, 3)
+	c.ln.AddRule(LossyRule{
+		Drop:      0.1,
+		Duplicate: 0.1,
+		Reorder:   0.2,
+		MaxDelay:  5 * time.Millisecond,
+	})
+
+	var ids []uint32
+	own := make([][]uint32, len(c.nodes))
+	for round := 0; round < 10; round++ {
+		for i := range c.nodes {
+			id := uint32(round*len(c.nodes) + i)
+			c.propose(i, id)
+			ids = append(ids, id)
+			own[i] = append(own[i], id)
+		}
+	}
+	// A proposer keeps trying until its value is decided, however many
+	// messages are lost
+	for i := range c.nodes {
+		c.waitDecided(i, own[i])
+	}
+	c.settle(ids)
+}
+
+func TestLossyNetworkPartition(t *testing.T) {
+	c := newLossyCluster(t, 5)
+	c.ln.AddRule(LossyRule{MaxDelay: 2 * time.Millisecond})
+
+	// The majority side keeps deciding values while the minority is cut off
+	c.ln.Partition([]uint32{0, 1}, []uint32{2, 3, 4})
+	var ids, majority []uint32
+	for round := 0; round < 5; round++ {
+		for i := range c.nodes {
+			id := uint32(round*len(c.nodes) + i)
+			c.propose(i, id)
+			ids = append(ids, id)
+			if i >= 2 {
+				majority = append(majority, id)
+			}
+		}
+	}
+	for i := 2; i < len(c.nodes); i++ {
+		c.waitDecided(i, majority)
+	}
+	for i := 0; i < 2; i++ {
+		c.mutex.Lock()
+		n := len(c.decided[i])
+		c.mutex.Unlock()
+		if n != 0 {
+			t.Fatalf("Node %d decided %d values while cut off from the majority", i, n)
+		}
+	}
+
+	// Once the partition heals, the minority catches up and gets its own
+	// values decided too
+	c.settle(ids)
+}
+
+func TestFlush(t *testing.T) {
+	c := newLossyCluster(t, 3)
+	c.ln.AddRule(LossyRule{MaxDelay: 2 * time.Millisecond})
+	if err := c.nodes[0].Flush(context.Background()); err != nil {
+		t.Fatalf("Flush with nothing proposed: %s", err)
+	}
+
+	// Values proposed by a node that is cut off cannot be decided
+	c.ln.Partition([]uint32{0}, []uint32{1, 2})
+	ids := []uint32{1, 2, 3}
+	for _, id := range ids {
+		c.propose(0, id)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := c.nodes[0].Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Flush while cut off returned %v, expected it to time out", err)
+	}
+
+	c.ln.Heal()
+	ctx, cancel = context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+	if err := c.nodes[0].Flush(ctx); err != nil {
+		t.Fatalf("Flush after healing: %s", err)
+	}
+	for i := range c.nodes {
+		c.waitDecided(i, ids)
+	}
+}
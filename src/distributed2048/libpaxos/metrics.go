@@ -0,0 +1,40 @@
+package libpaxos
+
+import (
+	"distributed2048/libmetrics"
+	"strconv"
+)
+
+// Phases of a Paxos round, as labelled in the metrics.
+const (
+	PHASE_PREPARE = "prepare"
+	PHASE_ACCEPT  = "accept"
+	PHASE_DECIDE  = "decide"
+)
+
+type paxosMetrics struct {
+	registry       *libmetrics.Registry
+	phaseStarted   *libmetrics.Counter
+	phaseSucceeded *libmetrics.Counter
+	phaseRetried   *libmetrics.Counter
+	rpcTimeouts    *libmetrics.Counter
+	decidedSlot    *libmetrics.Gauge
+	queueDepth     *libmetrics.Gauge
+}
+
+func newPaxosMetrics() *paxosMetrics {
+	r := libmetrics.NewRegistry()
+	return &paxosMetrics{
+		registry:       r,
+		phaseStarted:   r.NewCounter("paxos_phase_started_total", "Paxos phases this node started as a proposer.", "phase"),
+		phaseSucceeded: r.NewCounter("paxos_phase_succeeded_total", "Paxos phases this node completed with a majority.", "phase"),
+		phaseRetried:   r.NewCounter("paxos_phase_retried_total", "Paxos phases that failed and made this node start a new round.", "phase"),
+		rpcTimeouts:    r.NewCounter("paxos_rpc_timeouts_total", "Paxos messages to another node that timed out.", "peer"),
+		decidedSlot:    r.NewGauge("paxos_decided_slot", "Highest slot decided and handed to the game server."),
+		queueDepth:     r.NewGauge("paxos_proposal_queue_depth", "Values waiting for the proposal in progress to finish."),
+	}
+}
+
+func (m *paxosMetrics) timeout(peer uint32) {
+	m.rpcTimeouts.Inc(strconv.FormatUint(uint64(peer), 10))
+}
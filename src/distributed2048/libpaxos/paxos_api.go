@@ -1,6 +1,8 @@
 package libpaxos
 
 import (
+	"context"
+	"distributed2048/libmetrics"
 	"distributed2048/rpc/paxosrpc"
 )
 
@@ -12,6 +14,12 @@ const (
 	Decide
 )
 
+// Status is a summary of what a node has decided, and has yet to propose.
+type Status struct {
+	DecidedSlots uint32 // slots decided in a row, from slot 0
+	QueueDepth   int    // values waiting for the proposal in progress to finish
+}
+
 // Libpaxos defines the methods that a game server can call to propose new
 // values AND handle decided values from successful Paxos rounds.
 type Libpaxos interface {
@@ -26,4 +34,22 @@ type Libpaxos interface {
 	// ReceiveAccept, ReceiveDecide). This is useful for inserting debugging
 	// or testing code, to lag / interrupt the server for example.
 	SetInterruptFunc(f func(id uint32, action PaxosAction, slotNumber uint32))
+	// Faults returns the fault injector that every message the node sends
+	// to, or receives from, the other nodes goes through.
+	Faults() *FaultInjector
+	// Metrics returns the node's metrics: the Paxos phases it started,
+	// completed and retried, its timeouts, the highest slot decided and the
+	// number of values waiting to be proposed.
+	Metrics() *libmetrics.Registry
+	// Flush blocks until every value given to Propose so far has been
+	// decided, along with any proposed while it waits. It returns an error if
+	// ctx is done, or the node is closed, first.
+	Flush(ctx context.Context) error
+	// Status returns how many slots the node knows the values of, and how
+	// many values it has yet to propose.
+	Status() Status
+	// Close stops the node: it stops proposing and deciding values, and
+	// hangs up on the other nodes. If the node serves its own RPCs, it stops
+	// listening too.
+	Close() error
 }
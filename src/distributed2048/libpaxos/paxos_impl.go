@@ -2,9 +2,17 @@ package libpaxos
 
 import (
 	"container/list"
+	"context"
+	"distributed2048/libauth"
+	"distributed2048/liblog"
+	"distributed2048/libmetrics"
+	"distributed2048/libtls"
+	"distributed2048/libtrace"
 	"distributed2048/rpc/paxosrpc"
 	"distributed2048/util"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"net"
 	"net/http"
@@ -15,71 +23,124 @@ import (
 )
 
 const (
-	RPC_TIMEOUT_MILLISEC = 500
+	RPC_TIMEOUT_MILLISEC = 500 // default for RPCTimeout
 )
 
+// RPCTimeout is how long a Paxos message may take before it is given up on.
+// It may only be changed before any node starts.
+var RPCTimeout = RPC_TIMEOUT_MILLISEC * time.Millisecond
+
 const (
-	ERROR_LOG          bool = false
-	DEBUG_LOG          bool = false
-	DUMP_SLOTS         bool = false
-	SHOW_DECIDED_SLOTS bool = true
+	DUMP_SLOTS bool = false
 )
 
-var LOGV = util.NewLogger(DEBUG_LOG, "PAXOS|DEBUG", os.Stdout)
-var LOGE = util.NewLogger(ERROR_LOG, "PAXOS|ERROR", os.Stderr)
-
 type libpaxos struct {
 	allNodes       []paxosrpc.Node
 	majorityCount  int // minimum number of nodes for a majority to be reached
 	myNode         paxosrpc.Node
 	decidedHandler func(*paxosrpc.ProposalValue) // called when decided value received after successful paxos round
 
-	nodesMutex sync.Mutex
-	nodes      map[uint32]*node
+	transport Transport      // carries messages to and from the other nodes
+	faults    *FaultInjector // faults injected into those messages
+	metrics   *paxosMetrics
+	log       *slog.Logger
+	tracer    *libtrace.Tracer
 
 	dataMutex                 sync.Mutex
 	highestProposalNumberSeen *paxosrpc.ProposalNumber
-	highestAcceptedProposal   *paxosrpc.Proposal
+	acceptedProposals         map[uint32]*paxosrpc.Proposal // by slot, until the slot is decided
 
 	slotBox      *SlotBox   // holds previously decided slots
 	slotBoxMutex sync.Mutex // lock for slotBox
 
 	triggerHandlerCallCh chan struct{}
-	newValueCh           chan *paxosrpc.ProposalValue
+	newValueCh           chan *pendingValue
 
-	newValuesQueue     *list.List // Queue for new values to be later proposed
-	newValuesQueueLock sync.Mutex // Queue lock
+	newValuesQueue     *list.List    // Queue of pendingValues to be later proposed
+	newValuesQueueLock sync.Mutex    // Queue lock
+	undecided          int           // values given to Propose and not yet decided, guarded by newValuesQueueLock
+	flushed            chan struct{} // closed when undecided drops to 0, guarded by newValuesQueueLock
 
 	interruptFunc func(id uint32, action PaxosAction, slotNumber uint32)
+
+	// Decided values waiting to be handed to decidedHandler, in slot order
+	decidedQueue *list.List
+	decidedCond  *sync.Cond
+
+	httpServer *http.Server // nil if the RPC server is served by somebody else
+	listener   *util.TrackingListener
+	closing    chan struct{} // closed when Close is called
+	closeOnce  sync.Once
+}
+
+// NewLibpaxos starts a Paxos node that listens for RPCs from the other nodes
+// on hostport, over TLS if tlsConfig is not nil. Messages are signed and
+// verified with secret, unless it is nil.
+func NewLibpaxos(nodeID uint32, hostport string, allNodes []paxosrpc.Node, tlsConfig *libtls.Config, secret libauth.Secret) (Libpaxos, error) {
+	l, err := net.Listen("tcp", hostport)
+	if err != nil {
+		return nil, err
+	}
+	server := rpc.NewServer()
+	lp, err := newLibpaxos(nodeID, hostport, allNodes, NewRPCTransport(server, tlsConfig, secret))
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, tlsConfig.RequirePeer(server))
+	lp.httpServer = &http.Server{Handler: mux}
+	lp.listener = util.NewTrackingListener(l)
+	go lp.httpServer.Serve(tlsConfig.Listener(lp.listener))
+	return lp, nil
+}
+
+// NewLibpaxosOnServer starts a Paxos node that receives RPCs from the other
+// nodes through server. The caller is responsible for serving server over
+// HTTP on hostport, at rpc.DefaultRPCPath. It dials the other nodes over TLS
+// if tlsConfig is not nil, and signs and verifies messages with secret unless
+// it is nil.
+func NewLibpaxosOnServer(nodeID uint32, hostport string, allNodes []paxosrpc.Node, server *rpc.Server, tlsConfig *libtls.Config, secret libauth.Secret) (Libpaxos, error) {
+	return newLibpaxos(nodeID, hostport, allNodes, NewRPCTransport(server, tlsConfig, secret))
+}
+
+// NewLibpaxosWithTransport starts a Paxos node that exchanges messages with
+// the other nodes through transport, such as a LossyNetwork's.
+func NewLibpaxosWithTransport(nodeID uint32, hostport string, allNodes []paxosrpc.Node, transport Transport) (Libpaxos, error) {
+	return newLibpaxos(nodeID, hostport, allNodes, transport)
 }
 
-func NewLibpaxos(nodeID uint32, hostport string, allNodes []paxosrpc.Node) (Libpaxos, error) {
+func newLibpaxos(nodeID uint32, hostport string, allNodes []paxosrpc.Node, transport Transport) (*libpaxos, error) {
+	log := liblog.Logger(liblog.PAXOS).With("node", nodeID)
+	faults := newFaultInjector(log)
+	transport = &faultTransport{transport, faults}
 	lp := &libpaxos{
 		allNodes:                  allNodes,
 		majorityCount:             len(allNodes)/2 + 1,
 		myNode:                    paxosrpc.Node{nodeID, hostport},
-		nodes:                     make(map[uint32]*node),
-		newValueCh:                make(chan *paxosrpc.ProposalValue),
+		transport:                 transport,
+		faults:                    faults,
+		metrics:                   newPaxosMetrics(),
+		log:                       log,
+		tracer:                    libtrace.NewTracer(fmt.Sprintf("paxos-%d", nodeID)),
+		newValueCh:                make(chan *pendingValue),
 		highestProposalNumberSeen: &paxosrpc.ProposalNumber{0, nodeID},
+		acceptedProposals:         make(map[uint32]*paxosrpc.Proposal),
 		slotBox:                   NewSlotBox(),
 		triggerHandlerCallCh:      make(chan struct{}, 1000),
 		newValuesQueue:            list.New(),
-	}
-
-	for _, node := range allNodes {
-		lp.nodes[node.ID] = NewNode(node)
+		decidedQueue:              list.New(),
+		decidedCond:               sync.NewCond(&sync.Mutex{}),
+		closing:                   make(chan struct{}),
 	}
 
 	// Start the RPC handlers
-	rpc.RegisterName("PaxosNode", paxosrpc.Wrap(lp))
-	rpc.HandleHTTP()
-	l, err := net.Listen("tcp", fmt.Sprintf(hostport))
-	if err != nil {
+	if err := transport.Serve(lp); err != nil {
 		return nil, err
 	}
-	go http.Serve(l, nil)
 
 	go lp.controller()
+	go lp.deliverDecided()
 	if DUMP_SLOTS { // Writes the contents of slotbox to file at fixed intervals
 		go lp.dumpSlots()
 	}
@@ -88,14 +149,59 @@ func NewLibpaxos(nodeID uint32, hostport string, allNodes []paxosrpc.Node) (Libp
 }
 
 func (lp *libpaxos) SetInterruptFunc(f func(id uint32, action PaxosAction, slotNumber uint32)) {
+	lp.dataMutex.Lock()
 	lp.interruptFunc = f
+	lp.dataMutex.Unlock()
 }
 
-func (lp *libpaxos) ReceivePrepare(args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
-	if lp.interruptFunc != nil {
-		lp.interruptFunc(lp.myNode.ID, Prepare, lp.slotBox.nextUnknownSlotNumber)
+func (lp *libpaxos) Faults() *FaultInjector {
+	return lp.faults
+}
+
+func (lp *libpaxos) Metrics() *libmetrics.Registry {
+	return lp.metrics.registry
+}
+
+func (lp *libpaxos) Status() Status {
+	var status Status
+	lp.slotBoxMutex.Lock()
+	status.DecidedSlots = lp.slotBox.GetNextUnknownSlotNumber()
+	lp.slotBoxMutex.Unlock()
+	lp.newValuesQueueLock.Lock()
+	status.QueueDepth = lp.newValuesQueue.Len()
+	lp.newValuesQueueLock.Unlock()
+	return status
+}
+
+// interrupt calls the interrupt function, if one has been set, at the start
+// of the given receiving step.
+func (lp *libpaxos) interrupt(action PaxosAction) {
+	lp.dataMutex.Lock()
+	f := lp.interruptFunc
+	lp.dataMutex.Unlock()
+	if f == nil {
+		return
 	}
+	lp.slotBoxMutex.Lock()
+	slotNumber := lp.slotBox.nextUnknownSlotNumber
+	lp.slotBoxMutex.Unlock()
+	f(lp.myNode.ID, action, slotNumber)
+}
+
+func (lp *libpaxos) ReceivePrepare(args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
+	span := lp.tracer.StartChild("paxos.receive_prepare", args.Trace)
+	defer span.End()
+	span.SetAttr("from", args.Node.ID)
+	span.SetAttr("slot", args.CommandSlotNumber)
+	lp.interrupt(Prepare)
+	err := lp.receivePrepare(args, reply)
+	span.SetAttr("status", reply.Status)
+	return err
+}
 
+// receivePrepare answers a prepare message, whether it comes from another
+// node or from this node's own proposer.
+func (lp *libpaxos) receivePrepare(args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
 	lp.dataMutex.Lock()
 
 	lp.slotBoxMutex.Lock()
@@ -114,12 +220,12 @@ func (lp *libpaxos) ReceivePrepare(args *paxosrpc.ReceivePrepareArgs, reply *pax
 		reply.Status = paxosrpc.Reject
 	} else {
 		// If the proposal number is highest, then OK it, but also send back
-		// any accepted proposals.
+		// the proposal accepted for that slot, if any.
 		lp.highestProposalNumberSeen = &args.ProposalNumber
 		reply.Status = paxosrpc.OK
-		if lp.highestAcceptedProposal != nil {
+		if accepted, ok := lp.acceptedProposals[args.CommandSlotNumber]; ok {
 			reply.HasAcceptedProposal = true
-			reply.AcceptedProposal = *lp.highestAcceptedProposal
+			reply.AcceptedProposal = *accepted
 		} else {
 			reply.HasAcceptedProposal = false
 		}
@@ -129,10 +235,19 @@ func (lp *libpaxos) ReceivePrepare(args *paxosrpc.ReceivePrepareArgs, reply *pax
 }
 
 func (lp *libpaxos) ReceiveAccept(args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error {
-	if lp.interruptFunc != nil {
-		lp.interruptFunc(lp.myNode.ID, Accept, lp.slotBox.nextUnknownSlotNumber)
-	}
+	span := lp.tracer.StartChild("paxos.receive_accept", args.Trace)
+	defer span.End()
+	span.SetAttr("from", args.Proposal.Number.NodeID)
+	span.SetAttr("slot", args.Proposal.CommandSlotNumber)
+	lp.interrupt(Accept)
+	err := lp.receiveAccept(args, reply)
+	span.SetAttr("status", reply.Status)
+	return err
+}
 
+// receiveAccept answers an accept message, whether it comes from another
+// node or from this node's own proposer.
+func (lp *libpaxos) receiveAccept(args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error {
 	lp.dataMutex.Lock()
 
 	lp.slotBoxMutex.Lock()
@@ -142,13 +257,13 @@ func (lp *libpaxos) ReceiveAccept(args *paxosrpc.ReceiveAcceptArgs, reply *paxos
 	// Only accept a proposal if it has the highest proposal number so far.
 	if args.Proposal.Number.LessThan(lp.highestProposalNumberSeen) {
 		reply.Status = paxosrpc.Reject
-	} else if lp.highestAcceptedProposal != nil && (args.Proposal.Number.LessThan(&lp.highestAcceptedProposal.Number)) {
+	} else if accepted, ok := lp.acceptedProposals[args.Proposal.CommandSlotNumber]; ok && args.Proposal.Number.LessThan(&accepted.Number) {
 		reply.Status = paxosrpc.Reject
 	} else if slot != nil {
 		// Someone else already filled this slot!
 		reply.Status = paxosrpc.Reject
 	} else {
-		lp.highestAcceptedProposal = &args.Proposal
+		lp.acceptedProposals[args.Proposal.CommandSlotNumber] = &args.Proposal
 		lp.highestProposalNumberSeen = &args.Proposal.Number
 		reply.Status = paxosrpc.OK
 	}
@@ -158,9 +273,11 @@ func (lp *libpaxos) ReceiveAccept(args *paxosrpc.ReceiveAcceptArgs, reply *paxos
 }
 
 func (lp *libpaxos) ReceiveDecide(args *paxosrpc.ReceiveDecideArgs, reply *paxosrpc.ReceiveDecideReply) error {
-	if lp.interruptFunc != nil {
-		lp.interruptFunc(lp.myNode.ID, Decide, lp.slotBox.nextUnknownSlotNumber)
-	}
+	span := lp.tracer.StartChild("paxos.receive_decide", args.Trace)
+	defer span.End()
+	span.SetAttr("from", args.Proposal.Number.NodeID)
+	span.SetAttr("slot", args.Proposal.CommandSlotNumber)
+	lp.interrupt(Decide)
 	lp.dataMutex.Lock()
 
 	// Send the proposal to the slot box.
@@ -173,19 +290,140 @@ func (lp *libpaxos) ReceiveDecide(args *paxosrpc.ReceiveDecideArgs, reply *paxos
 	lp.triggerHandlerCallCh <- struct{}{}
 
 	// Reset the paxos state for the next round of paxos
-	lp.highestAcceptedProposal = nil
+	lp.forgetAccepted(args.Proposal.CommandSlotNumber)
 
 	lp.dataMutex.Unlock()
 	return nil
 }
 
+// forgetAccepted drops the proposal accepted for a slot once the slot has
+// been decided. Proposals accepted for other slots have to be kept, since a
+// majority may have accepted them already, and a proposer that got cut off
+// before sending its decides is the only one that knows. It must be called
+// with dataMutex held.
+func (lp *libpaxos) forgetAccepted(slotNumber uint32) {
+	delete(lp.acceptedProposals, slotNumber)
+}
+
+// sendPrepare sends a prepare message to the given node. The proposer sends
+// one to its own node too, so that it keeps its promises like any other.
+func (lp *libpaxos) sendPrepare(to paxosrpc.Node, args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
+	if to.ID == lp.myNode.ID {
+		return lp.receivePrepare(args, reply)
+	}
+	return lp.transport.Prepare(to, args, reply)
+}
+
+// sendAccept sends an accept message to the given node, which may be this
+// one.
+func (lp *libpaxos) sendAccept(to paxosrpc.Node, args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error {
+	if to.ID == lp.myNode.ID {
+		return lp.receiveAccept(args, reply)
+	}
+	return lp.transport.Accept(to, args, reply)
+}
+
 func (lp *libpaxos) Propose(proposal *paxosrpc.ProposalValue) error {
-	lp.newValueCh <- proposal
-	return nil
+	pv := &pendingValue{proposal, lp.tracer.StartChild("paxos.queue", proposal.Trace)}
+	lp.newValuesQueueLock.Lock()
+	if lp.undecided == 0 {
+		lp.flushed = make(chan struct{})
+	}
+	lp.undecided++
+	lp.newValuesQueueLock.Unlock()
+	select {
+	case lp.newValueCh <- pv:
+		return nil
+	case <-lp.closing:
+		lp.decided()
+		return errors.New("libpaxos has been closed")
+	}
+}
+
+// decided notes that a value given to Propose has been decided, and wakes up
+// Flush if it was the last one.
+func (lp *libpaxos) decided() {
+	lp.newValuesQueueLock.Lock()
+	lp.undecided--
+	if lp.undecided == 0 {
+		close(lp.flushed)
+	}
+	lp.newValuesQueueLock.Unlock()
+}
+
+func (lp *libpaxos) Flush(ctx context.Context) error {
+	lp.newValuesQueueLock.Lock()
+	if lp.undecided == 0 {
+		lp.newValuesQueueLock.Unlock()
+		return nil
+	}
+	flushed := lp.flushed
+	lp.newValuesQueueLock.Unlock()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-lp.closing:
+		return errors.New("libpaxos has been closed")
+	}
 }
 
 func (lp *libpaxos) DecidedHandler(handler func(proposal *paxosrpc.ProposalValue)) {
+	lp.decidedCond.L.Lock()
 	lp.decidedHandler = handler
+	lp.decidedCond.Broadcast()
+	lp.decidedCond.L.Unlock()
+}
+
+func (lp *libpaxos) Close() error {
+	lp.closeOnce.Do(func() {
+		close(lp.closing)
+		lp.decidedCond.L.Lock()
+		lp.decidedCond.Broadcast()
+		lp.decidedCond.L.Unlock()
+	})
+	var err error
+	if lp.httpServer != nil {
+		err = lp.httpServer.Close()
+		lp.listener.CloseConns()
+	}
+	if terr := lp.transport.Close(); err == nil {
+		err = terr
+	}
+	return err
+}
+
+// isClosing returns whether Close has been called.
+func (lp *libpaxos) isClosing() bool {
+	select {
+	case <-lp.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliverDecided hands decided values to decidedHandler one at a time, in
+// the order of their slots. The handler may block for a long time, so values
+// are queued up for it rather than holding up the controller. Values decided
+// before a handler is set are kept until it is.
+func (lp *libpaxos) deliverDecided() {
+	for {
+		lp.decidedCond.L.Lock()
+		for (lp.decidedQueue.Len() == 0 || lp.decidedHandler == nil) && !lp.isClosing() {
+			lp.decidedCond.Wait()
+		}
+		if lp.isClosing() {
+			lp.decidedCond.L.Unlock()
+			return
+		}
+		value := lp.decidedQueue.Remove(lp.decidedQueue.Front()).(*paxosrpc.ProposalValue)
+		handler := lp.decidedHandler
+		lp.decidedCond.L.Unlock()
+
+		handler(value)
+	}
 }
 
 // controller handles the arrival of new proposal values, and also what
@@ -201,22 +439,25 @@ func (lp *libpaxos) controller() {
 	doneCh := make(chan struct{})
 	for {
 		select {
-		case proposal := <-lp.newValueCh:
+		case <-lp.closing:
+			return
+		case pv := <-lp.newValueCh:
 			if proposalInProgress {
-				LOGV.Println("Proposal in progress, deferring")
+				lp.log.Debug("proposal in progress, queueing value")
 				lp.newValuesQueueLock.Lock()
-				lp.newValuesQueue.PushBack(proposal)
+				lp.newValuesQueue.PushBack(pv)
+				lp.metrics.queueDepth.Set(float64(lp.newValuesQueue.Len()))
 				lp.newValuesQueueLock.Unlock()
 			} else {
 				proposalInProgress = true
-				go lp.doPropose(proposal, doneCh)
+				go lp.doPropose(pv, doneCh)
 			}
 		case <-doneCh:
 			lp.newValuesQueueLock.Lock()
 			if e := lp.newValuesQueue.Front(); e != nil {
 				lp.newValuesQueue.Remove(e)
-				proposal := e.Value.(*paxosrpc.ProposalValue)
-				go lp.doPropose(proposal, doneCh)
+				lp.metrics.queueDepth.Set(float64(lp.newValuesQueue.Len()))
+				go lp.doPropose(e.Value.(*pendingValue), doneCh)
 			} else {
 				proposalInProgress = false
 			}
@@ -229,31 +470,56 @@ func (lp *libpaxos) controller() {
 				if slot == nil {
 					break
 				}
-				if lp.decidedHandler != nil {
-					if SHOW_DECIDED_SLOTS {
-						fmt.Println("Node", lp.myNode.ID, "got slot", slot.Number)
-					}
-					// this function call could potentially block for a long
-					// time, so we call it in a new goroutine
-					go lp.decidedHandler(slot.Value)
-				}
+				lp.metrics.decidedSlot.Set(float64(slot.Number))
+				lp.log.Debug("got decided slot", "slot", slot.Number)
+				lp.decidedCond.L.Lock()
+				lp.decidedQueue.PushBack(slot.Value)
+				lp.decidedCond.Signal()
+				lp.decidedCond.L.Unlock()
 			}
 		}
 	}
 }
 
+// pendingValue is a value waiting to be proposed, with the span of its wait,
+// if it is traced.
+type pendingValue struct {
+	value  *paxosrpc.ProposalValue
+	queued *libtrace.Span
+}
+
+// backoff waits for a little while before a proposal is tried again, so that
+// competing proposers do not keep getting in each other's way.
+func (lp *libpaxos) backoff(round *libtrace.Span) {
+	span := lp.tracer.StartChild("paxos.backoff", round.Context())
+	num := time.Duration(rand.Int()%100 + 25)
+	time.Sleep(num * time.Millisecond)
+	span.End()
+}
+
 // doPropose will keep attempting to submit a proposal to the Paxos cluster
 // with the given value. This may not always succeed because there will be
 // competing proposals, but when doPropose returns, it guarantees that the
 // value has been decided in a quorum.
-func (lp *libpaxos) doPropose(value *paxosrpc.ProposalValue, doneCh chan<- struct{}) {
-	LOGV.Println("doing propose")
+func (lp *libpaxos) doPropose(pv *pendingValue, doneCh chan<- struct{}) {
+	pv.queued.End()
+	value := pv.value
+	round := lp.tracer.StartChild("paxos.round", value.Trace)
+	defer round.End()
+	attempts := 0
+
 	done := false // true if $moves has been Decided, false otherwise.
 	for !done {
+		if lp.isClosing() {
+			return
+		}
 		retry := false // true if we should try again, for whatever reason, false otherwise.
 
 		// PHASE 1
-		LOGV.Println("Proposer", lp.myNode.ID, ": PHASE 1")
+		lp.metrics.phaseStarted.Inc(PHASE_PREPARE)
+		attempts++
+		round.SetAttr("attempts", attempts)
+		phase := lp.tracer.StartChild("paxos.prepare", round.Context())
 
 		// Make a new proposal such that my_n > n_h
 		lp.dataMutex.Lock()
@@ -262,31 +528,24 @@ func (lp *libpaxos) doPropose(value *paxosrpc.ProposalValue, doneCh chan<- struc
 		lp.highestProposalNumberSeen = &myProp.Number
 		lp.slotBoxMutex.Unlock()
 		lp.dataMutex.Unlock()
+		log := lp.log.With("slot", myProp.CommandSlotNumber, "proposal", myProp.Number.String())
+		log.Debug("phase 1: prepare")
+		phase.SetAttr("attempt", attempts)
+		phase.SetAttr("slot", myProp.CommandSlotNumber)
+		phase.SetAttr("proposal", myProp.Number.String())
 
 		// Send proposal to everybody
-		promisedCount := 1 // include myself
+		promisedCount := 0
 		var otherProposal *paxosrpc.Proposal
-		lp.nodesMutex.Lock()
-		for _, node := range lp.nodes {
-			if node.Info.ID == lp.myNode.ID {
-				continue // skip myself
-			}
-
-			client := node.getRPCClient()
-			if client == nil {
-				continue
-			}
-
-			args := &paxosrpc.ReceivePrepareArgs{lp.myNode, myProp.Number, myProp.CommandSlotNumber}
+		for _, node := range lp.allNodes {
+			args := &paxosrpc.ReceivePrepareArgs{lp.myNode, myProp.Number, myProp.CommandSlotNumber, phase.Context(), nil}
 			var reply paxosrpc.ReceivePrepareReply
-			reply.Status = paxosrpc.Reject
-
-			timedOut, err := rpcCallWithTimeout(client, "PaxosNode.ReceivePrepare", args, &reply)
-			if err != nil {
-				node.Client = nil // so it will try to redial in future attempts
-				continue          // skip nodes that are unreachable
-			} else if timedOut {
-				LOGE.Println("RPC call PaxosNode.ReceivePrepare to", node.Info.ID, "timed out")
+			if err := lp.sendPrepare(node, args, &reply); err != nil {
+				if err == ErrTimeout {
+					log.Debug("prepare timed out", "peer", node.ID)
+					lp.metrics.timeout(node.ID)
+				}
+				continue // skip nodes that are unreachable
 			}
 
 			lp.dataMutex.Lock()
@@ -304,6 +563,7 @@ func (lp *libpaxos) doPropose(value *paxosrpc.ProposalValue, doneCh chan<- struc
 				lp.slotBoxMutex.Lock()
 				lp.slotBox.Add(NewSlot(reply.DecidedSlotNumber, &reply.DecidedValue))
 				lp.slotBoxMutex.Unlock()
+				lp.forgetAccepted(reply.DecidedSlotNumber)
 
 				lp.triggerHandlerCallCh <- struct{}{}
 
@@ -315,102 +575,114 @@ func (lp *libpaxos) doPropose(value *paxosrpc.ProposalValue, doneCh chan<- struc
 			}
 			lp.dataMutex.Unlock()
 		}
-		lp.nodesMutex.Unlock()
+
+		phase.SetAttr("promised", promisedCount)
 
 		// Retry?
 		if retry {
+			lp.metrics.phaseRetried.Inc(PHASE_PREPARE)
+			phase.SetAttr("outcome", "slot already decided")
+			phase.End()
 			continue
 		}
 
 		// Got majority?
 		if promisedCount < lp.majorityCount {
-			LOGV.Println(lp.myNode.ID, " couldn't get a majority. Got", promisedCount, "needed", lp.majorityCount)
-			// Backoff
-			num := time.Duration(rand.Int()%100 + 25)
-			time.Sleep(num * time.Millisecond)
+			log.Debug("no majority for prepare", "promised", promisedCount, "needed", lp.majorityCount)
+			lp.metrics.phaseRetried.Inc(PHASE_PREPARE)
+			phase.SetAttr("outcome", "no majority")
+			phase.End()
+			lp.backoff(round)
 			continue // try again
 		}
 
-		// This is the proposal that we will be sending out in PHASE 2
-		propToAccept := otherProposal
-
-		if otherProposal == nil {
-			propToAccept = myProp
+		// This is the proposal that we will be sending out in PHASE 2. If a
+		// node has already accepted a value, that value has to be proposed
+		// instead of ours, but under our proposal number: the nodes have
+		// promised to reject anything numbered lower.
+		propToAccept := myProp
+		if otherProposal != nil {
+			propToAccept = paxosrpc.NewProposal(myProp.Number.Number, otherProposal.CommandSlotNumber, lp.myNode.ID, otherProposal.Value)
 		}
-		LOGV.Println(lp.myNode.ID, "got a majority on", propToAccept.Number.String())
+		lp.metrics.phaseSucceeded.Inc(PHASE_PREPARE)
+		phase.SetAttr("outcome", "ok")
+		phase.End()
 
 		// PHASE 2
-		LOGV.Println("Proposer", lp.myNode.ID, ": PHASE 2")
+		log.Debug("phase 2: accept", "adopted", otherProposal != nil)
+		lp.metrics.phaseStarted.Inc(PHASE_ACCEPT)
+		phase = lp.tracer.StartChild("paxos.accept", round.Context())
+		phase.SetAttr("slot", propToAccept.CommandSlotNumber)
+		phase.SetAttr("adopted", otherProposal != nil)
 
 		// Send <accept, myn, V> to all nodes
-		acceptedCount := 1
-		lp.nodesMutex.Lock()
-		for _, node := range lp.nodes {
-			if node.Info.ID == lp.myNode.ID {
-				continue // skip myself
-			}
-
-			client := node.getRPCClient()
-			if client == nil {
-				continue
-			}
-
-			args := &paxosrpc.ReceiveAcceptArgs{*propToAccept}
+		acceptedCount := 0
+		for _, node := range lp.allNodes {
+			args := &paxosrpc.ReceiveAcceptArgs{*propToAccept, phase.Context(), nil}
 			var reply paxosrpc.ReceiveAcceptReply
-			reply.Status = paxosrpc.Reject
-
-			timedOut, err := rpcCallWithTimeout(client, "PaxosNode.ReceiveAccept", args, &reply)
-			if err != nil {
-				LOGE.Println(err)
-				node.Client = nil // so it will try to redial in future attempts
-				continue          // skip nodes that are unreachable
-			} else if timedOut {
-				LOGE.Println("RPC call PaxosNode.ReceiveAccept to", node.Info.ID, "timed out")
+			if err := lp.sendAccept(node, args, &reply); err != nil {
+				if err == ErrTimeout {
+					log.Debug("accept timed out", "peer", node.ID)
+					lp.metrics.timeout(node.ID)
+				} else {
+					log.Warn("could not send accept", "peer", node.ID, "err", err)
+				}
+				continue // skip nodes that are unreachable
 			}
 
 			if reply.Status == paxosrpc.OK {
 				acceptedCount++
 			} else {
-				LOGV.Println("Node", node.Info.ID, "rejected my Accept message with status", reply.Status)
+				log.Debug("accept rejected", "peer", node.ID, "status", reply.Status)
 			} // do nothing if REJECTED
 		}
-		lp.nodesMutex.Unlock()
 
 		// Got majority?
+		phase.SetAttr("accepted", acceptedCount)
 		if acceptedCount < lp.majorityCount {
-			LOGV.Println("Couldn't get majority for PHASE 2,", acceptedCount, "/", lp.majorityCount)
-			// Backoff
-			num := time.Duration(rand.Int()%100 + 25)
-			time.Sleep(num * time.Millisecond)
+			log.Debug("no majority for accept", "accepted", acceptedCount, "needed", lp.majorityCount)
+			lp.metrics.phaseRetried.Inc(PHASE_ACCEPT)
+			phase.SetAttr("outcome", "no majority")
+			phase.End()
+			lp.backoff(round)
 			continue // try again
 		}
+		phase.SetAttr("outcome", "ok")
+		phase.End()
 
-		LOGV.Printf("%d got majority (%d/%d) on ACCEPT for slot %d, seqnum is %s, value is\n%s\n", lp.myNode.ID, acceptedCount, lp.majorityCount, propToAccept.CommandSlotNumber, propToAccept.Number.String(), util.MovesString(propToAccept.Value.Moves))
+		log.Debug("phase 3: decide", "accepted", acceptedCount)
+
+		lp.metrics.phaseSucceeded.Inc(PHASE_ACCEPT)
 
 		// Send <decide, va> to all nodes
-		lp.nodesMutex.Lock()
-		for _, node := range lp.nodes {
-			if node.Info.ID == lp.myNode.ID {
+		lp.metrics.phaseStarted.Inc(PHASE_DECIDE)
+		phase = lp.tracer.StartChild("paxos.decide", round.Context())
+		phase.SetAttr("slot", propToAccept.CommandSlotNumber)
+		for _, node := range lp.allNodes {
+			if node.ID == lp.myNode.ID {
 				continue // skip myself
 			}
 
-			client := node.getRPCClient()
-			if client == nil {
-				continue
-			}
-
-			args := &paxosrpc.ReceiveDecideArgs{*propToAccept}
+			args := &paxosrpc.ReceiveDecideArgs{*propToAccept, phase.Context(), nil}
 			var reply paxosrpc.ReceiveDecideReply
-
-			rpcCallWithTimeout(client, "PaxosNode.ReceiveDecide", args, &reply)
-			// We don't care if that rpc call had an error or timed out
+			err := lp.transport.Decide(node, args, &reply)
+			if err != nil && err != ErrTimeout {
+				// The connection may have gone stale while the node was
+				// restarting, so try once more on a new one. Otherwise the
+				// node would only learn this slot once it proposes a value
+				// itself.
+				err = lp.transport.Decide(node, args, &reply)
+			}
+			if err == ErrTimeout {
+				lp.metrics.timeout(node.ID)
+			}
+			// We don't care if that rpc call timed out
 		}
 
-		LOGV.Printf("%d decided on slot %d, seqnum is %s, value is\n%s\n", lp.myNode.ID, propToAccept.CommandSlotNumber, propToAccept.Number.String(), util.MovesString(propToAccept.Value.Moves))
-		lp.nodesMutex.Unlock()
+		log.Debug("decided")
 
 		lp.dataMutex.Lock()
-		lp.highestAcceptedProposal = nil
+		lp.forgetAccepted(propToAccept.CommandSlotNumber)
 		lp.dataMutex.Unlock()
 
 		lp.slotBoxMutex.Lock()
@@ -418,25 +690,18 @@ func (lp *libpaxos) doPropose(value *paxosrpc.ProposalValue, doneCh chan<- struc
 		lp.slotBoxMutex.Unlock()
 
 		lp.triggerHandlerCallCh <- struct{}{}
+		lp.metrics.phaseSucceeded.Inc(PHASE_DECIDE)
+		phase.End()
 
-		if propToAccept.Number.Equal(&myProp.Number) {
+		if otherProposal == nil {
 			done = true
 		}
 	}
-	LOGV.Println("Done propose")
-	doneCh <- struct{}{}
-}
-
-func rpcCallWithTimeout(client *rpc.Client, serviceMethod string, args, reply interface{}) (bool, error) {
-	ch := make(chan error, 1)
-	go func() { ch <- client.Call(serviceMethod, args, reply) }()
+	lp.decided()
 	select {
-	case err := <-ch:
-		return false, err
-	case <-time.After(RPC_TIMEOUT_MILLISEC * time.Millisecond):
-		return true, nil
+	case doneCh <- struct{}{}:
+	case <-lp.closing:
 	}
-	return false, nil
 }
 
 // dumpSlots writes the contents of slotbox to a timestamped file at fixed
@@ -0,0 +1,186 @@
+package libpaxos
+
+import (
+	"distributed2048/libauth"
+	"distributed2048/liblog"
+	"distributed2048/libtls"
+	"distributed2048/rpc/paxosrpc"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var authLog = liblog.Logger(liblog.PAXOS)
+
+type node struct {
+	Info   paxosrpc.Node
+	Client *rpc.Client
+	Mutex  sync.Mutex
+	tls    *libtls.Config // to dial the node with
+}
+
+func NewNode(info paxosrpc.Node, tlsConfig *libtls.Config) *node {
+	return &node{
+		Info: info,
+		tls:  tlsConfig,
+	}
+}
+
+func (n *node) getRPCClient() *rpc.Client {
+	n.Mutex.Lock()
+	if n.Client == nil {
+		c, _ := n.tls.DialRPC(n.Info.HostPort)
+		// if err != nil {
+		// 	fmt.Println(err)
+		// }
+		n.Client = c
+	}
+	n.Mutex.Unlock()
+	return n.Client
+}
+
+// resetRPCClient hangs up the connection to the node, if there is one, so
+// that the next call redials it.
+func (n *node) resetRPCClient() {
+	n.Mutex.Lock()
+	if n.Client != nil {
+		n.Client.Close()
+		n.Client = nil
+	}
+	n.Mutex.Unlock()
+}
+
+// rpcTransport sends Paxos messages over net/rpc, keeping one connection to
+// each node.
+type rpcTransport struct {
+	server *rpc.Server
+	tls    *libtls.Config
+	secret libauth.Secret
+
+	nodesMutex sync.Mutex
+	nodes      map[uint32]*node
+}
+
+// NewRPCTransport returns a Transport that receives messages through server,
+// which has to be served over HTTP at rpc.DefaultRPCPath, and dials the other
+// nodes at their host:port, over TLS if tlsConfig is not nil. Messages are
+// signed with secret, and turned away unless they are signed with it, if it
+// is not nil.
+func NewRPCTransport(server *rpc.Server, tlsConfig *libtls.Config, secret libauth.Secret) Transport {
+	return &rpcTransport{
+		server: server,
+		tls:    tlsConfig,
+		secret: secret,
+		nodes:  make(map[uint32]*node),
+	}
+}
+
+func (t *rpcTransport) Serve(pn paxosrpc.RemotePaxosNode) error {
+	return t.server.RegisterName("PaxosNode", paxosrpc.Wrap(&authNode{pn, t.secret}))
+}
+
+func (t *rpcTransport) Prepare(to paxosrpc.Node, args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
+	return t.call(to, "PaxosNode.ReceivePrepare", args, reply)
+}
+
+func (t *rpcTransport) Accept(to paxosrpc.Node, args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error {
+	return t.call(to, "PaxosNode.ReceiveAccept", args, reply)
+}
+
+func (t *rpcTransport) Decide(to paxosrpc.Node, args *paxosrpc.ReceiveDecideArgs, reply *paxosrpc.ReceiveDecideReply) error {
+	return t.call(to, "PaxosNode.ReceiveDecide", args, reply)
+}
+
+func (t *rpcTransport) Close() error {
+	t.nodesMutex.Lock()
+	for _, n := range t.nodes {
+		n.resetRPCClient()
+	}
+	t.nodesMutex.Unlock()
+	return nil
+}
+
+func (t *rpcTransport) getNode(info paxosrpc.Node) *node {
+	t.nodesMutex.Lock()
+	defer t.nodesMutex.Unlock()
+	n, ok := t.nodes[info.ID]
+	if !ok {
+		n = NewNode(info, t.tls)
+		t.nodes[info.ID] = n
+	}
+	return n
+}
+
+// call makes an RPC to the given node, giving up after RPCTimeout.
+// If the connection fails, it is redialled on the next call. The reply is
+// only filled in if call returns nil.
+func (t *rpcTransport) call(to paxosrpc.Node, serviceMethod string, args, reply interface{}) error {
+	n := t.getNode(to)
+	client := n.getRPCClient()
+	if client == nil {
+		return rpc.ErrShutdown
+	}
+
+	// Sign a copy, as the same args are sent to every node at once
+	argsCopy := reflect.New(reflect.TypeOf(args).Elem())
+	argsCopy.Elem().Set(reflect.ValueOf(args).Elem())
+	args = argsCopy.Interface()
+	if err := t.secret.Sign(serviceMethod, args); err != nil {
+		return err
+	}
+
+	// The reply is decoded into a copy, so that a call that times out does
+	// not write into reply after the caller has moved on
+	replyCopy := reflect.New(reflect.TypeOf(reply).Elem())
+	call := client.Go(serviceMethod, args, replyCopy.Interface(), make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			if _, ok := call.Error.(rpc.ServerError); !ok {
+				n.resetRPCClient() // so it will try to redial in future attempts
+			}
+			return call.Error
+		}
+		reflect.ValueOf(reply).Elem().Set(replyCopy.Elem())
+		return nil
+	case <-time.After(RPCTimeout):
+		return ErrTimeout
+	}
+}
+
+// authNode turns away messages that are not authenticated with the
+// cluster's secret, if authentication is on.
+type authNode struct {
+	paxosrpc.RemotePaxosNode
+	secret libauth.Secret
+}
+
+func (n *authNode) verify(method string, from uint32, args interface{}) error {
+	err := n.secret.Verify(method, args)
+	if err != nil {
+		authLog.Warn("rejected a message that is not authenticated", "method", method, "from", from)
+	}
+	return err
+}
+
+func (n *authNode) ReceivePrepare(args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error {
+	if err := n.verify("PaxosNode.ReceivePrepare", args.Node.ID, args); err != nil {
+		return err
+	}
+	return n.RemotePaxosNode.ReceivePrepare(args, reply)
+}
+
+func (n *authNode) ReceiveAccept(args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error {
+	if err := n.verify("PaxosNode.ReceiveAccept", args.Proposal.Number.NodeID, args); err != nil {
+		return err
+	}
+	return n.RemotePaxosNode.ReceiveAccept(args, reply)
+}
+
+func (n *authNode) ReceiveDecide(args *paxosrpc.ReceiveDecideArgs, reply *paxosrpc.ReceiveDecideReply) error {
+	if err := n.verify("PaxosNode.ReceiveDecide", args.Proposal.Number.NodeID, args); err != nil {
+		return err
+	}
+	return n.RemotePaxosNode.ReceiveDecide(args, reply)
+}
@@ -0,0 +1,27 @@
+package libpaxos
+
+import (
+	"distributed2048/rpc/paxosrpc"
+	"errors"
+)
+
+// ErrTimeout is returned by a Transport when a node did not answer in time.
+// The message may or may not have been received.
+var ErrTimeout = errors.New("paxos message timed out")
+
+// Transport carries the messages of a Paxos node to the other nodes, and
+// theirs to it. NewRPCTransport sends them over net/rpc, and a LossyNetwork
+// is an unreliable network inside one process.
+type Transport interface {
+	// Serve hands the messages that the other nodes send to this node to
+	// node. It is called once, when the node starts.
+	Serve(node paxosrpc.RemotePaxosNode) error
+	// Prepare, Accept and Decide send a message to the given node and wait
+	// for its reply. They return ErrTimeout if it takes too long, and another
+	// error if the node cannot be reached.
+	Prepare(to paxosrpc.Node, args *paxosrpc.ReceivePrepareArgs, reply *paxosrpc.ReceivePrepareReply) error
+	Accept(to paxosrpc.Node, args *paxosrpc.ReceiveAcceptArgs, reply *paxosrpc.ReceiveAcceptReply) error
+	Decide(to paxosrpc.Node, args *paxosrpc.ReceiveDecideArgs, reply *paxosrpc.ReceiveDecideReply) error
+	// Close hangs up on the other nodes.
+	Close() error
+}
@@ -0,0 +1,71 @@
+package libreplay
+
+import (
+	"distributed2048/lib2048"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Recorder writes one replay file per game into a directory.
+type Recorder struct {
+	dir   string
+	mutex sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+}
+
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// StartGame finishes the replay file of the previous game, if any, and starts
+// a new one for the given game.
+func (r *Recorder) StartGame(id uint32, game lib2048.Game2048) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closeFile()
+	name := fmt.Sprintf("%d_%d_%d.replay", id, time.Now().UnixNano(), game.GetSeed())
+	file, err := os.Create(filepath.Join(r.dir, name))
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.enc = json.NewEncoder(file)
+	return r.enc.Encode(NewHeader(game))
+}
+
+// RecordMove appends a winning direction and its vote tally to the current
+// replay file. game must already have had the direction applied.
+func (r *Recorder) RecordMove(dir lib2048.Direction, votes map[lib2048.Direction]int, game lib2048.Game2048) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.enc == nil {
+		return nil
+	}
+	return r.enc.Encode(NewEntry(dir, votes, game))
+}
+
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closeFile()
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.enc = nil
+	return err
+}
@@ -0,0 +1,118 @@
+// Package libreplay records crowd games as they are played on a game server,
+// and loads those recordings back so that they can be replayed exactly.
+//
+// A replay file holds one JSON object per line. The first line is a Header
+// describing how the game was set up, and every following line is an Entry
+// describing one direction that was applied to the board. Since the board is
+// fully determined by the seed and the sequence of directions, the recorded
+// game snapshots are only used to verify a replay.
+
+package libreplay
+
+import (
+	"bufio"
+	"distributed2048/lib2048"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	Version = 1
+)
+
+// Header is the first line of every replay file. It holds the game as it was
+// when recording started.
+type Header struct {
+	Version int
+	Started time.Time
+	Game    lib2048.State
+}
+
+// Entry records one winning direction, the votes that decided it, and the
+// game after it was applied.
+type Entry struct {
+	Time      time.Time
+	Direction lib2048.Direction
+	Votes     map[string]int
+	Game      lib2048.State
+}
+
+// Replay is a complete recording of one game.
+type Replay struct {
+	Header  Header
+	Entries []Entry
+}
+
+func NewHeader(game lib2048.Game2048) Header {
+	return Header{Version, time.Now(), game.GetState()}
+}
+
+func NewEntry(dir lib2048.Direction, votes map[lib2048.Direction]int, game lib2048.Game2048) Entry {
+	namedVotes := make(map[string]int)
+	for d, count := range votes {
+		namedVotes[d.String()] = count
+	}
+	return Entry{time.Now(), dir, namedVotes, game.GetState()}
+}
+
+// Load reads a replay file from disk.
+func Load(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(file)
+}
+
+// Read reads a replay in the format written by a Recorder.
+func Read(r io.Reader) (*Replay, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	replay := &Replay{}
+	if err := dec.Decode(&replay.Header); err != nil {
+		return nil, fmt.Errorf("could not read replay header: %s", err)
+	}
+	if replay.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported replay version %d", replay.Header.Version)
+	}
+	for {
+		var entry Entry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// A server that was killed mid-write leaves a truncated last
+			// line behind, so keep everything before it.
+			if err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, fmt.Errorf("could not read replay entry %d: %s", len(replay.Entries), err)
+		}
+		replay.Entries = append(replay.Entries, entry)
+	}
+	return replay, nil
+}
+
+// NewGame returns the game in the state it was in when recording started.
+func (r *Replay) NewGame() (lib2048.Game2048, error) {
+	return lib2048.NewGame2048FromState(r.Header.Game)
+}
+
+// Verify applies every recorded direction to a new game, checking that the
+// board matches the recording after each one. It returns the final game.
+func (r *Replay) Verify() (lib2048.Game2048, error) {
+	game, err := r.NewGame()
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range r.Entries {
+		game.MakeMove(entry.Direction)
+		if game.GetState() != entry.Game {
+			return game, fmt.Errorf("replay diverged at move %d (%s)", i, entry.Direction)
+		}
+	}
+	return game, nil
+}
@@ -0,0 +1,114 @@
+package libreplay
+
+import (
+	"bytes"
+	"distributed2048/lib2048"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var moves = []lib2048.Direction{lib2048.Up, lib2048.Left, lib2048.Down, lib2048.Right, lib2048.Up, lib2048.Left}
+
+// record plays moves on a new game with the given options, recording them
+// into dir, and returns the game and the contents of the replay file.
+func record(t *testing.T, dir string, options lib2048.Options) (lib2048.Game2048, []byte) {
+	t.Helper()
+	r, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game := lib2048.NewGame2048WithOptions(options)
+	if err := r.StartGame(1, game); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range moves {
+		game.MakeMove(dir)
+		if err := r.RecordMove(dir, map[lib2048.Direction]int{dir: 2, lib2048.Down: 1}, game); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.replay"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Recorded %v (%v), expected one replay file", files, err)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return game, data
+}
+
+func TestRoundTrip(t *testing.T) {
+	options := lib2048.Options{Seed: 26, Variant: lib2048.Fibonacci}
+	game, data := record(t, t.TempDir(), options)
+	replay, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.Header.Game.Options != options {
+		t.Errorf("Recorded options %+v, expected %+v", replay.Header.Game.Options, options)
+	}
+	if len(replay.Entries) != len(moves) {
+		t.Fatalf("Read %d entries, expected %d", len(replay.Entries), len(moves))
+	}
+	for i, entry := range replay.Entries {
+		if entry.Direction != moves[i] || entry.Votes[moves[i].String()] != 2 {
+			t.Errorf("Entry %d is %s with votes %v, expected %s with 2 votes", i, entry.Direction, entry.Votes, moves[i])
+		}
+	}
+	final, err := replay.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final.GetState() != game.GetState() {
+		t.Errorf("Replayed to %+v, expected %+v", final.GetState(), game.GetState())
+	}
+}
+
+func TestTruncatedLastLine(t *testing.T) {
+	_, data := record(t, t.TempDir(), lib2048.Options{Seed: 26})
+	// Cut the last entry off halfway, as a server killed mid-write would
+	lastLine := bytes.LastIndexByte(data[:len(data)-1], '\n') + 1
+	truncated := data[:lastLine+(len(data)-lastLine)/2]
+	replay, err := Read(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replay.Entries) != len(moves)-1 {
+		t.Fatalf("Read %d entries, expected %d", len(replay.Entries), len(moves)-1)
+	}
+	if _, err := replay.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRejectBadReplays(t *testing.T) {
+	_, data := record(t, t.TempDir(), lib2048.Options{Seed: 26})
+	lines := strings.SplitAfter(string(data), "\n")
+	bad := map[string]string{
+		"empty":              "",
+		"truncated header":   lines[0][:len(lines[0])/2],
+		"another version":    strings.Replace(lines[0], `"Version":1`, `"Version":2`, 1),
+		"garbage in between": lines[0] + lines[1] + "}\n" + lines[2],
+	}
+	for name, data := range bad {
+		if _, err := Read(strings.NewReader(data)); err == nil {
+			t.Errorf("Read a replay with %s", name)
+		}
+	}
+
+	// A replay whose boards do not follow from its directions fails to verify
+	replay, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Entries[2].Direction = replay.Entries[3].Direction
+	if _, err := replay.Verify(); err == nil {
+		t.Error("Verified a replay with a changed direction")
+	}
+}
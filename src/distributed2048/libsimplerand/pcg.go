@@ -0,0 +1,64 @@
+package libsimplerand
+
+import (
+	"sync"
+)
+
+const (
+	pcgMultiplier = uint64(6364136223846793005)
+	pcgIncrement  = uint64(1442695040888963407)
+)
+
+// PCGRand is a PCG32 generator with a fixed stream, so that its whole state
+// fits in a single 64-bit value.
+type PCGRand struct {
+	state uint64
+	mutex sync.Mutex
+}
+
+func NewPCGRand(seed uint32) *PCGRand {
+	r := &PCGRand{}
+	r.step()
+	r.state += uint64(seed)
+	r.step()
+	return r
+}
+
+func (r *PCGRand) step() uint64 {
+	old := r.state
+	r.state = old*pcgMultiplier + pcgIncrement
+	return old
+}
+
+func (r *PCGRand) Uint32() uint32 {
+	r.mutex.Lock()
+	old := r.step()
+	r.mutex.Unlock()
+	xorshifted := uint32(((old >> 18) ^ old) >> 27)
+	rot := uint32(old >> 59)
+	return (xorshifted >> rot) | (xorshifted << ((-rot) & 31))
+}
+
+func (r *PCGRand) Int() int {
+	return int(r.Uint32() >> 1)
+}
+
+func (r *PCGRand) Intn(n int) int {
+	return intn(r.Uint32, n)
+}
+
+func (r *PCGRand) Kind() Kind {
+	return PCG
+}
+
+func (r *PCGRand) GetState() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.state
+}
+
+func (r *PCGRand) SetState(state uint64) {
+	r.mutex.Lock()
+	r.state = state
+	r.mutex.Unlock()
+}
@@ -0,0 +1,83 @@
+package libsimplerand
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind identifies one of the random number generators in this package.
+type Kind int
+
+const (
+	LCG      Kind = iota // SimpleRand, the default
+	PCG                  // PCG32 (XSH RR)
+	Xorshift             // xorshift64*
+)
+
+// Rand is a deterministic random number generator whose entire state can be
+// read and restored, so that two replicas can be made to draw the same
+// numbers from then on.
+type Rand interface {
+	// Uint32 returns the next pseudo-random 32-bit value. Generators with a
+	// smaller output may leave the top bits unset.
+	Uint32() uint32
+	// Int returns a non-negative pseudo-random int.
+	Int() int
+	// Intn returns, as an int, a non-negative pseudo-random number in
+	// [0,n). It panics if n <= 0.
+	Intn(n int) int
+	Kind() Kind
+	GetState() uint64
+	SetState(state uint64)
+}
+
+// New returns a generator of the given kind, initialized with seed.
+func New(kind Kind, seed uint32) (Rand, error) {
+	switch kind {
+	case LCG:
+		return NewSimpleRand(seed), nil
+	case PCG:
+		return NewPCGRand(seed), nil
+	case Xorshift:
+		return NewXorshiftRand(seed), nil
+	}
+	return nil, fmt.Errorf("unknown random number generator %d", int(kind))
+}
+
+func (k Kind) String() string {
+	switch k {
+	case LCG:
+		return "lcg"
+	case PCG:
+		return "pcg"
+	case Xorshift:
+		return "xorshift"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// ParseKind is the inverse of Kind.String.
+func ParseKind(name string) (Kind, error) {
+	for _, k := range []Kind{LCG, PCG, Xorshift} {
+		if strings.ToLower(name) == k.String() {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown random number generator %q", name)
+}
+
+// intn maps a uniformly distributed 32-bit value into [0,n) without the bias
+// of taking it modulo n, drawing again from next if necessary.
+func intn(next func() uint32, n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+	bound := uint32(n)
+	threshold := -bound % bound
+	for {
+		product := uint64(next()) * uint64(bound)
+		if uint32(product) >= threshold {
+			return int(product >> 32)
+		}
+	}
+}
@@ -1,4 +1,7 @@
-// Package libsimplerand implements a linear congruential generator that supports manually setting the present x_n value
+// Package libsimplerand implements deterministic random number generators
+// that support manually reading and setting their state. SimpleRand is a
+// linear congruential generator that supports manually setting the present
+// x_n value.
 
 package libsimplerand
 
@@ -55,3 +58,15 @@ func (r *SimpleRand) SetCurrent(x uint32) {
 	r.xn = x
 	r.mutex.Unlock()
 }
+
+func (r *SimpleRand) Kind() Kind {
+	return LCG
+}
+
+func (r *SimpleRand) GetState() uint64 {
+	return uint64(r.GetCurrent())
+}
+
+func (r *SimpleRand) SetState(state uint64) {
+	r.SetCurrent(uint32(state))
+}
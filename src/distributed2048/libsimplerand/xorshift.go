@@ -0,0 +1,62 @@
+package libsimplerand
+
+import (
+	"sync"
+)
+
+const xorshiftMultiplier = uint64(0x2545F4914F6CDD1D)
+
+// XorshiftRand is a xorshift64* generator. Its state is never 0.
+type XorshiftRand struct {
+	state uint64
+	mutex sync.Mutex
+}
+
+func NewXorshiftRand(seed uint32) *XorshiftRand {
+	// Spread the seed over all 64 bits (splitmix64), since nearby seeds
+	// would otherwise start out with very similar sequences.
+	z := uint64(seed) + 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	r := &XorshiftRand{}
+	r.SetState(z ^ (z >> 31))
+	return r
+}
+
+func (r *XorshiftRand) Uint32() uint32 {
+	r.mutex.Lock()
+	x := r.state
+	x ^= x >> 12
+	x ^= x << 25
+	x ^= x >> 27
+	r.state = x
+	r.mutex.Unlock()
+	return uint32((x * xorshiftMultiplier) >> 32)
+}
+
+func (r *XorshiftRand) Int() int {
+	return int(r.Uint32() >> 1)
+}
+
+func (r *XorshiftRand) Intn(n int) int {
+	return intn(r.Uint32, n)
+}
+
+func (r *XorshiftRand) Kind() Kind {
+	return Xorshift
+}
+
+func (r *XorshiftRand) GetState() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.state
+}
+
+func (r *XorshiftRand) SetState(state uint64) {
+	if state == 0 {
+		state = xorshiftMultiplier // xorshift never leaves the all zero state
+	}
+	r.mutex.Lock()
+	r.state = state
+	r.mutex.Unlock()
+}
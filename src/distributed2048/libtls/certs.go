@@ -0,0 +1,120 @@
+package libtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Names of the CA's files, in the directory that GenerateCA writes to.
+const (
+	CA_CERT = "ca.pem"
+	CA_KEY  = "ca-key.pem"
+)
+
+const CERT_VALIDITY = 365 * 24 * time.Hour
+
+// GenerateCA writes a new CA certificate and key into dir, unless there is
+// one already, which is kept. It returns the path of the certificate.
+func GenerateCA(dir string) (string, error) {
+	certFile, keyFile := filepath.Join(dir, CA_CERT), filepath.Join(dir, CA_KEY)
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		return certFile, nil
+	}
+	template, err := newTemplate("distributed2048 CA")
+	if err != nil {
+		return "", err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", err
+	}
+	return certFile, writePair(certFile, keyFile, der, key)
+}
+
+// GenerateCert writes a certificate and key for a node, name.pem and
+// name-key.pem, into dir, signed by the CA that GenerateCA wrote there. The
+// certificate is valid for every host name or IP address in hosts, both for
+// serving and for calling other nodes. It returns the paths of the
+// certificate and key.
+func GenerateCert(dir, name string, hosts []string) (string, string, error) {
+	if len(hosts) == 0 {
+		return "", "", errors.New("a certificate needs at least one host")
+	}
+	ca, err := tls.LoadX509KeyPair(filepath.Join(dir, CA_CERT), filepath.Join(dir, CA_KEY))
+	if err != nil {
+		return "", "", err
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return "", "", err
+	}
+
+	template, err := newTemplate(name)
+	if err != nil {
+		return "", "", err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return "", "", err
+	}
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	return certFile, keyFile, writePair(certFile, keyFile, der, key)
+}
+
+func newTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"distributed2048"}, CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour), // in case clocks disagree a little
+		NotAfter:     now.Add(CERT_VALIDITY),
+	}, nil
+}
+
+// writePair writes a certificate and its key as PEM files, the key only
+// readable by its owner.
+func writePair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
@@ -0,0 +1,190 @@
+// Package libtls turns on TLS for every connection of a node of the cluster:
+// the RPCs between game servers, from game servers to the central server and
+// back, the clients' requests to the central server, and their websockets.
+// Each node is given a Config, loaded from a CA certificate, and a
+// certificate and key signed by it, as PEM files. A nil Config leaves TLS
+// off.
+//
+// With TLS on, servers only take RPCs from peers that present a certificate
+// signed by the CA, and present theirs when they call others, so that only
+// nodes holding one can join the cluster. Clients, which have no
+// certificate, can still ask the central server for a game server and play,
+// as long as they trust the CA.
+package libtls
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"time"
+)
+
+// Config is the TLS setup of a node: the certificate it presents, and the CA
+// it trusts. Its methods may be called on a nil Config, which leaves TLS off.
+type Config struct {
+	server    *tls.Config
+	client    *tls.Config
+	transport http.RoundTripper // shared by the clients of HTTPClient
+}
+
+// Load reads the CA certificate at caFile, which peers must be signed by, and
+// the certificate and key at certFile and keyFile, which the node presents to
+// them. If all three are empty, it returns nil, which leaves TLS off.
+func Load(caFile, certFile, keyFile string) (*Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if caFile == "" || certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS needs a CA certificate, a certificate and a key, or none of them")
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{
+		server: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			// Clients have no certificate, so it is only required for RPCs,
+			// by RequirePeer
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			MinVersion: tls.VersionTLS12,
+		},
+		client: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	c.transport = &http.Transport{TLSClientConfig: c.client.Clone(), Proxy: http.ProxyFromEnvironment}
+	return c, nil
+}
+
+// Enabled returns whether TLS is on.
+func (c *Config) Enabled() bool {
+	return c != nil
+}
+
+// ClientConfig returns the config to dial other nodes with, or nil if TLS is
+// off.
+func (c *Config) ClientConfig() *tls.Config {
+	if c == nil {
+		return nil
+	}
+	return c.client.Clone()
+}
+
+// URL returns the URL of the HTTP server at hostport.
+func (c *Config) URL(hostport string) string {
+	if c.Enabled() {
+		return "https://" + hostport
+	}
+	return "http://" + hostport
+}
+
+// WebsocketURL returns the URL of the websocket served at path on hostport.
+func (c *Config) WebsocketURL(hostport, path string) string {
+	if c.Enabled() {
+		return "wss://" + hostport + path
+	}
+	return "ws://" + hostport + path
+}
+
+// Listener returns a listener that does the TLS handshake on every
+// connection accepted by l, or l itself if TLS is off. Closing it closes l.
+func (c *Config) Listener(l net.Listener) net.Listener {
+	if c == nil {
+		return l
+	}
+	return tls.NewListener(l, c.server.Clone())
+}
+
+// RequirePeer wraps the handler of an RPC server so that it turns away
+// requests from anybody without a certificate signed by the CA. It returns h
+// itself if TLS is off.
+func (c *Config) RequirePeer(h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "a client certificate signed by the cluster's CA is required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Dial connects to addr over TCP, with TLS if it is on, giving up after
+// timeout, or never if it is 0.
+func (c *Config) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if c == nil {
+		return dialer.Dial("tcp", addr)
+	}
+	return tls.DialWithDialer(dialer, "tcp", addr, c.ClientConfig())
+}
+
+// DialRPC connects to the RPC server at addr, as rpc.DialHTTP does, with TLS
+// if it is on. If TLS is off and the server requires it, it returns
+// ErrTLSRequired rather than anything the server might say in plain text.
+func (c *Config) DialRPC(addr string) (*rpc.Client, error) {
+	conn, err := c.Dial(addr, 0)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewRPCClient(conn)
+	if err != nil {
+		conn.Close()
+		// A TLS server hangs up on a request that is not a handshake
+		if c == nil && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return nil, ErrTLSRequired
+		}
+	}
+	return client, err
+}
+
+// ErrTLSRequired is returned by DialRPC on a nil Config for a server that
+// hung up without answering, as one that requires TLS does.
+var ErrTLSRequired = errors.New("the server hung up without answering; it may require TLS, which is off")
+
+// NewRPCClient asks for the RPC server served over HTTP at the other end of
+// conn, as rpc.DialHTTP does, and returns a client that calls it.
+func NewRPCClient(conn net.Conn) (*rpc.Client, error) {
+	io.WriteString(conn, "CONNECT "+rpc.DefaultRPCPath+" HTTP/1.0\n\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// HTTPClient returns a client for the HTTP servers of the cluster, which
+// trusts the CA if TLS is on, and gives up after timeout, or never if it is
+// 0.
+func (c *Config) HTTPClient(timeout time.Duration) *http.Client {
+	if c == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	return &http.Client{Transport: c.transport, Timeout: timeout}
+}
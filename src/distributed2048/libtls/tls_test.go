@@ -0,0 +1,132 @@
+package libtls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := GenerateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := GenerateCert(dir, "node", []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(ca, cert, ""); err == nil {
+		t.Error("Loaded TLS without a key")
+	}
+	c, err := Load(ca, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Enabled() || c.URL("localhost:1") != "https://localhost:1" || c.WebsocketURL("localhost:1", "/abc") != "wss://localhost:1/abc" {
+		t.Error("TLS is not on once loaded")
+	}
+	off, err := Load("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off.Enabled() || off.URL("localhost:1") != "http://localhost:1" || off.ClientConfig() != nil {
+		t.Error("TLS is on without any files")
+	}
+}
+
+func TestGenerateCert(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := GenerateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := GenerateCert(dir, "node", []string{"gs.example", "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Making the CA again keeps the one there
+	caPEM, _ := ioutil.ReadFile(ca)
+	if _, err := GenerateCA(dir); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := ioutil.ReadFile(filepath.Join(dir, CA_CERT)); string(again) != string(caPEM) {
+		t.Error("GenerateCA replaced the CA")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	certPEM, _ := ioutil.ReadFile(cert)
+	block, _ := pem.Decode(certPEM)
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"gs.example", "10.0.0.1"} {
+		for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+			opts := x509.VerifyOptions{DNSName: host, Roots: pool, KeyUsages: []x509.ExtKeyUsage{usage}}
+			if _, err := c.Verify(opts); err != nil {
+				t.Errorf("Certificate not valid for %s (usage %d): %s", host, usage, err)
+			}
+		}
+	}
+	if _, err := c.Verify(x509.VerifyOptions{DNSName: "other.example", Roots: pool}); err == nil {
+		t.Error("Certificate valid for a host it was not made for")
+	}
+}
+
+// An RPC client with TLS off gets ErrTLSRequired from a server that requires
+// TLS, and reaches it with TLS on.
+func TestDialRPCRequiresTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := GenerateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := GenerateCert(dir, "node", []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(ca, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Echo", new(echo)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(c.Listener(l), c.RequirePeer(server))
+
+	var off *Config
+	if _, err := off.DialRPC(l.Addr().String()); err != ErrTLSRequired {
+		t.Errorf("Dialing a TLS server without TLS got %v, expected ErrTLSRequired", err)
+	}
+	client, err := c.DialRPC(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply string
+	if err := client.Call("Echo.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Errorf("Echo over TLS got %q, %v", reply, err)
+	}
+}
+
+type echo struct{}
+
+func (echo) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
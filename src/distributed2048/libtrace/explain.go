@@ -0,0 +1,130 @@
+package libtrace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// explainer prints spans as trees, following the links between traces.
+type explainer struct {
+	w        io.Writer
+	byID     map[ID]*Span
+	children map[ID][]*Span // by parent
+	roots    map[ID][]*Span // by trace, spans whose parent is unknown
+	printed  map[ID]bool    // traces printed so far
+	start    time.Time      // offsets are printed from here
+	err      error
+}
+
+func newExplainer(w io.Writer, spans []*Span) *explainer {
+	e := &explainer{
+		w:        w,
+		byID:     make(map[ID]*Span),
+		children: make(map[ID][]*Span),
+		roots:    make(map[ID][]*Span),
+		printed:  make(map[ID]bool),
+	}
+	for _, s := range spans {
+		e.byID[s.SpanID] = s
+	}
+	for _, s := range spans {
+		if _, ok := e.byID[s.ParentID]; ok && s.ParentID != 0 {
+			e.children[s.ParentID] = append(e.children[s.ParentID], s)
+		} else {
+			e.roots[s.TraceID] = append(e.roots[s.TraceID], s)
+		}
+	}
+	byStart := func(spans []*Span) {
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].StartTime.Before(spans[j].StartTime) })
+	}
+	for _, c := range e.children {
+		byStart(c)
+	}
+	for _, r := range e.roots {
+		byStart(r)
+	}
+	return e
+}
+
+// earliest returns when the first span reachable from s started, following
+// children and links.
+func (e *explainer) earliest(s *Span, seen map[ID]bool) time.Time {
+	t := s.StartTime
+	consider := func(o *Span) {
+		if ot := e.earliest(o, seen); ot.Before(t) {
+			t = ot
+		}
+	}
+	for _, c := range e.children[s.SpanID] {
+		consider(c)
+	}
+	for _, link := range s.Links {
+		if seen[link.TraceID] {
+			continue
+		}
+		seen[link.TraceID] = true
+		for _, r := range e.roots[link.TraceID] {
+			consider(r)
+		}
+	}
+	return t
+}
+
+func (e *explainer) printf(format string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+func (e *explainer) print(s *Span, depth int) {
+	var attrs []string
+	for k, v := range s.Attrs {
+		attrs = append(attrs, k+"="+v)
+	}
+	sort.Strings(attrs)
+	indent := strings.Repeat("  ", depth)
+	e.printf("%10s %10s  %s%s [%s] %s\n", "+"+formatDuration(s.StartTime.Sub(e.start)), formatDuration(s.Duration()), indent, s.Name, s.Service, strings.Join(attrs, " "))
+
+	for _, c := range e.children[s.SpanID] {
+		e.print(c, depth+1)
+	}
+	for _, link := range s.Links {
+		if e.printed[link.TraceID] {
+			e.printf("%22s%s  follows from span %s, shown above\n", "", indent, link.SpanID)
+			continue
+		}
+		e.printed[link.TraceID] = true
+		if len(e.roots[link.TraceID]) == 0 {
+			e.printf("%22s%s  follows from trace %s, which was not recorded\n", "", indent, link.TraceID)
+			continue
+		}
+		e.printf("%22s%s  follows from trace %s:\n", "", indent, link.TraceID)
+		for _, r := range e.roots[link.TraceID] {
+			e.print(r, depth+2)
+		}
+	}
+}
+
+// Explain writes the span with the given ID as a tree of the spans under it,
+// and of the traces it follows from through its links and theirs, so that
+// it can be seen what it waited for. Each span is shown with when it started,
+// from the first span shown, and how long it took.
+func Explain(w io.Writer, spans []*Span, id ID) error {
+	e := newExplainer(w, spans)
+	s, ok := e.byID[id]
+	if !ok {
+		return fmt.Errorf("no span %s", id)
+	}
+	e.start = e.earliest(s, map[ID]bool{s.TraceID: true})
+	e.printed[s.TraceID] = true
+	e.printf("%10s %10s  %s\n", "START", "DURATION", "SPAN")
+	e.print(s, 0)
+	return e.err
+}
@@ -0,0 +1,110 @@
+package libtrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const HTTP_EXPORT_TIMEOUT = 5 * time.Second
+
+// Batch is what the HTTP exporter posts to a collector.
+type Batch struct {
+	Spans []*Span
+}
+
+type fileExporter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileExporter appends spans to the file at path, one JSON object per
+// line, creating it if needed.
+func NewFileExporter(path string) (Exporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{file: f}, nil
+}
+
+func (e *fileExporter) Export(spans []*Span) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return WriteSpans(e.file, spans)
+}
+
+func (e *fileExporter) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.file.Close()
+}
+
+type httpExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPExporter posts spans, as a JSON Batch, to the collector at url.
+func NewHTTPExporter(url string) Exporter {
+	return &httpExporter{url, &http.Client{Timeout: HTTP_EXPORT_TIMEOUT}}
+}
+
+func (e *httpExporter) Export(spans []*Span) error {
+	body, err := json.Marshal(Batch{spans})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("collector replied %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (e *httpExporter) Close() error {
+	return nil
+}
+
+// WriteSpans writes spans to w, one JSON object per line. Each line is
+// written in one go, so that several processes can append to the same file.
+func WriteSpans(w io.Writer, spans []*Span) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, s := range spans {
+		buf.Reset()
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSpans reads spans written by WriteSpans.
+func ReadSpans(r io.Reader) ([]*Span, error) {
+	var spans []*Span
+	dec := json.NewDecoder(r)
+	for {
+		s := new(Span)
+		if err := dec.Decode(s); err == io.EOF {
+			return spans, nil
+		} else if err != nil {
+			return spans, err
+		}
+		spans = append(spans, s)
+	}
+}
@@ -0,0 +1,281 @@
+// Package libtrace records spans: named, timed steps in the life of a vote,
+// from the keypress that made it, through the game server and Paxos, to the
+// move it was counted in. A span belongs to a trace, may have a parent in the
+// same trace, and may link to spans of other traces, such as the votes that
+// were proposed together, or the values that were counted into one move.
+//
+// Spans are only recorded once an exporter is set, with Configure or
+// SetExporter. They are then exported in batches, as JSON, to a file or to a
+// collector over HTTP (see d2048 trace). Configure is called with the
+// D2048_TRACE environment variable at startup.
+package libtrace
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FLUSH_INTERVAL = 1 * time.Second // how often finished spans are exported
+	MAX_BUFFERED   = 100000          // finished spans kept while the exporter is slow, before dropping them
+)
+
+// ID identifies a trace or a span. The zero ID is no trace or span.
+type ID uint64
+
+func newID() ID {
+	for {
+		if id := ID(rand.Uint64()); id != 0 {
+			return id
+		}
+	}
+}
+
+func (id ID) String() string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *ID) UnmarshalText(text []byte) error {
+	n, err := strconv.ParseUint(string(text), 16, 64)
+	if err != nil {
+		return fmt.Errorf("bad trace ID %q", text)
+	}
+	*id = ID(n)
+	return nil
+}
+
+// SpanContext identifies a span, so that it can be continued elsewhere: in
+// another goroutine, or on another game server or client.
+type SpanContext struct {
+	TraceID ID
+	SpanID  ID
+}
+
+// IsValid returns whether sc identifies a span, which it does not if the
+// span was never recorded.
+func (sc SpanContext) IsValid() bool {
+	return sc.SpanID != 0
+}
+
+// Span is a step that took place between StartTime and EndTime. It is not
+// safe for concurrent use. The methods of a nil Span, which Start returns
+// when tracing is off, do nothing.
+type Span struct {
+	TraceID   ID
+	SpanID    ID
+	ParentID  ID `json:",omitempty"`
+	Name      string
+	Service   string // what recorded the span, e.g. gameserver-1
+	StartTime time.Time
+	EndTime   time.Time
+	Attrs     map[string]string `json:",omitempty"`
+	Links     []SpanContext     `json:",omitempty"`
+}
+
+// Context returns the span's context, which is the zero SpanContext for a
+// nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{s.TraceID, s.SpanID}
+}
+
+// SetAttr records a value, formatted with fmt.Sprint, under key.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.Attrs == nil {
+		s.Attrs = make(map[string]string)
+	}
+	s.Attrs[key] = fmt.Sprint(value)
+}
+
+// Link records that the span follows from another one, outside its trace.
+func (s *Span) Link(sc SpanContext) {
+	if s == nil || !sc.IsValid() {
+		return
+	}
+	s.Links = append(s.Links, sc)
+}
+
+// End finishes the span, and queues it for export. The span must not be
+// changed afterwards.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.EndTime = time.Now()
+	mutex.Lock()
+	if exporter != nil {
+		if len(buffer) < MAX_BUFFERED {
+			buffer = append(buffer, s)
+		} else {
+			dropped++
+		}
+	}
+	mutex.Unlock()
+}
+
+// Duration returns how long the span took.
+func (s *Span) Duration() time.Duration {
+	return s.EndTime.Sub(s.StartTime)
+}
+
+// Tracer starts the spans of a service, such as a game server or a client.
+type Tracer struct {
+	service string
+}
+
+func NewTracer(service string) *Tracer {
+	return &Tracer{service}
+}
+
+// Start starts a span as a child of parent, or as the root of a new trace if
+// parent is not valid, which links to the given spans. It returns nil if
+// tracing is off.
+func (t *Tracer) Start(name string, parent SpanContext, links ...SpanContext) *Span {
+	if !enabled.Load() {
+		return nil
+	}
+	s := &Span{
+		TraceID:   parent.TraceID,
+		SpanID:    newID(),
+		ParentID:  parent.SpanID,
+		Name:      name,
+		Service:   t.service,
+		StartTime: time.Now(),
+	}
+	if !parent.IsValid() {
+		s.TraceID = newID()
+	}
+	for _, link := range links {
+		s.Link(link)
+	}
+	return s
+}
+
+// StartChild starts a span as a child of parent, like Start, but only if
+// parent is valid, so that only work done for traced spans is traced.
+func (t *Tracer) StartChild(name string, parent SpanContext) *Span {
+	if !parent.IsValid() {
+		return nil
+	}
+	return t.Start(name, parent)
+}
+
+// Exporter sends finished spans somewhere they can be looked at.
+type Exporter interface {
+	Export(spans []*Span) error
+	Close() error
+}
+
+var (
+	enabled atomic.Bool // whether spans are recorded
+
+	mutex    sync.Mutex // guards everything below
+	exporter Exporter
+	buffer   []*Span // finished spans waiting to be exported
+	dropped  int     // spans dropped since the last export
+	stop     chan struct{}
+	stopped  chan struct{}
+
+	exportMutex sync.Mutex // held while exporting, so that batches stay in order
+)
+
+func init() {
+	if err := Configure(os.Getenv("D2048_TRACE")); err != nil {
+		fmt.Fprintln(os.Stderr, "Ignoring trace settings from the environment:", err)
+	}
+}
+
+// Configure exports spans to the given destination: a collector's URL if it
+// starts with http:// or https://, or else a file that spans are appended to.
+// An empty destination turns tracing off.
+func Configure(dest string) error {
+	if dest == "" {
+		return SetExporter(nil)
+	}
+	var e Exporter
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		e = NewHTTPExporter(dest)
+	} else {
+		var err error
+		if e, err = NewFileExporter(dest); err != nil {
+			return err
+		}
+	}
+	return SetExporter(e)
+}
+
+// SetExporter exports spans with e from now on, or turns tracing off if e is
+// nil. The previous exporter, if any, is flushed and closed.
+func SetExporter(e Exporter) error {
+	mutex.Lock()
+	oldStop, oldStopped := stop, stopped
+	mutex.Unlock()
+	if oldStop != nil {
+		close(oldStop)
+		<-oldStopped
+	}
+	err := Flush()
+
+	mutex.Lock()
+	if exporter != nil {
+		if cerr := exporter.Close(); err == nil {
+			err = cerr
+		}
+	}
+	exporter, stop, stopped = e, nil, nil
+	if e != nil {
+		stop, stopped = make(chan struct{}), make(chan struct{})
+		go flusher(stop, stopped)
+	}
+	enabled.Store(e != nil)
+	mutex.Unlock()
+	return err
+}
+
+func flusher(stop, stopped chan struct{}) {
+	defer close(stopped)
+	ticker := time.NewTicker(FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := Flush(); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not export spans:", err)
+			}
+		}
+	}
+}
+
+// Flush exports every span that has ended.
+func Flush() error {
+	exportMutex.Lock()
+	defer exportMutex.Unlock()
+	mutex.Lock()
+	e, spans, n := exporter, buffer, dropped
+	buffer, dropped = nil, 0
+	mutex.Unlock()
+	if n > 0 {
+		fmt.Fprintf(os.Stderr, "Dropped %d spans while the exporter was busy\n", n)
+	}
+	if e == nil || len(spans) == 0 {
+		return nil
+	}
+	return e.Export(spans)
+}
@@ -0,0 +1,113 @@
+package libtrace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memExporter struct {
+	spans []*Span
+}
+
+func (e *memExporter) Export(spans []*Span) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memExporter) Close() error {
+	return nil
+}
+
+func TestDisabled(t *testing.T) {
+	if err := SetExporter(nil); err != nil {
+		t.Fatal(err)
+	}
+	s := NewTracer("test").Start("root", SpanContext{})
+	if s != nil {
+		t.Fatalf("Started %+v with tracing off", s)
+	}
+	// None of these may panic
+	s.SetAttr("a", 1)
+	s.Link(SpanContext{1, 2})
+	s.End()
+	if s.Context().IsValid() {
+		t.Error("A nil span has a valid context")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	e := &memExporter{}
+	if err := SetExporter(e); err != nil {
+		t.Fatal(err)
+	}
+	defer SetExporter(nil)
+
+	tr := NewTracer("test")
+	other := tr.Start("other", SpanContext{})
+	other.End()
+	root := tr.Start("root", SpanContext{}, other.Context())
+	root.SetAttr("n", 3)
+	child := tr.StartChild("child", root.Context())
+	child.End()
+	if s := tr.StartChild("orphan", SpanContext{}); s != nil {
+		t.Errorf("Started %+v without a parent", s)
+	}
+	root.End()
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.spans) != 3 {
+		t.Fatalf("Exported %d spans, expected 3", len(e.spans))
+	}
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+		t.Errorf("Child %+v is not under %+v", child, root)
+	}
+	if root.TraceID == other.TraceID || len(root.Links) != 1 || root.Links[0] != other.Context() {
+		t.Errorf("Root %+v does not link to %+v", root, other)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSpans(&buf, e.spans); err != nil {
+		t.Fatal(err)
+	}
+	spans, err := ReadSpans(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 3 || spans[2].SpanID != root.SpanID || spans[2].Attrs["n"] != "3" {
+		t.Errorf("Read back %+v", spans)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	spans := []*Span{
+		{TraceID: 1, SpanID: 10, Name: "vote", Service: "client-1", StartTime: at(0), EndTime: at(5)},
+		{TraceID: 2, SpanID: 20, Name: "move", Service: "gameserver-0", StartTime: at(20), EndTime: at(21),
+			Links: []SpanContext{{1, 10}, {3, 30}}},
+		{TraceID: 2, SpanID: 21, ParentID: 20, Name: "apply", Service: "gameserver-0", StartTime: at(20), EndTime: at(21)},
+	}
+	var buf bytes.Buffer
+	if err := Explain(&buf, spans, 20); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"+20.0ms      1.0ms  move [gameserver-0]",
+		"+20.0ms      1.0ms    apply [gameserver-0]",
+		"follows from trace 0000000000000001:",
+		"+0.0ms      5.0ms      vote [client-1]",
+		"follows from trace 0000000000000003, which was not recorded",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Explanation lacks %q:\n%s", want, out)
+		}
+	}
+	if err := Explain(&buf, spans, 99); err == nil {
+		t.Error("Explained a span that does not exist")
+	}
+}
@@ -0,0 +1,56 @@
+package adminrpc
+
+import (
+	"distributed2048/libauth"
+	"distributed2048/util"
+)
+
+type Status int
+
+const (
+	OK Status = iota + 1 // RPC was a success
+)
+
+// GameServerStatus describes a game server, as shown by the central server's
+// admin API.
+type GameServerStatus struct {
+	ID           uint32
+	HostPort     string
+	Clients      int  // websocket clients connected
+	Draining     bool // whether new clients are turned away
+	ShuttingDown bool // whether it is proposing its last votes before it stops
+	DecidedSlots uint32
+	QueueDepth   int                 // values waiting to be proposed
+	GameNumber   uint32              // 0 before the first game starts
+	Game         *util.Game2048State // nil before the first game starts
+	Tally        map[string]int      // decided votes not yet counted into a move, by direction
+	Buffered     int                 // votes from its clients not yet proposed
+}
+
+type GetStatusArgs struct {
+	Auth libauth.MAC // if authentication is on
+}
+
+type GetStatusReply struct {
+	Status     Status
+	GameServer GameServerStatus
+}
+
+type DrainArgs struct {
+	Shutdown bool        // whether to also send the clients elsewhere and stop, once its votes are decided
+	Auth     libauth.MAC // if authentication is on
+}
+
+type DrainReply struct {
+	Status Status
+}
+
+type NewGameArgs struct {
+	Seed uint32      // 0 to use the game server's options, as at the end of a game
+	Auth libauth.MAC // if authentication is on
+}
+
+type NewGameReply struct {
+	Status     Status
+	GameNumber uint32 // number of the game proposed
+}
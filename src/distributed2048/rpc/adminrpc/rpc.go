@@ -0,0 +1,21 @@
+package adminrpc
+
+type RemoteGameServerAdmin interface {
+	GetStatus(*GetStatusArgs, *GetStatusReply) error
+	// Drain makes the game server turn away new clients, or shut down
+	// gracefully if asked to.
+	Drain(*DrainArgs, *DrainReply) error
+	// NewGame ends the current game, and proposes a new one to every game
+	// server.
+	NewGame(*NewGameArgs, *NewGameReply) error
+}
+
+type GameServerAdmin struct {
+	RemoteGameServerAdmin
+}
+
+// Wrap wraps a in a type-safe wrapper struct to ensure that only the desired
+// GameServerAdmin methods are exported to receive RPCs.
+func Wrap(a RemoteGameServerAdmin) RemoteGameServerAdmin {
+	return &GameServerAdmin{a}
+}
@@ -1,6 +1,7 @@
 package centralrpc
 
 import (
+	"distributed2048/libauth"
 	"distributed2048/rpc/paxosrpc"
 )
 
@@ -10,6 +11,7 @@ const (
 	OK Status = iota + 1 // RPC was a success
 	NotReady
 	Full
+	NotFound // no game server has registered with that Host:Port
 )
 
 type GetGameServerForClientArgs struct {
@@ -22,7 +24,8 @@ type GetGameServerForClientReply struct {
 }
 
 type RegisterGameServerArgs struct {
-	HostPort string // Host:Port of the registering game server
+	HostPort string      // Host:Port of the registering game server
+	Auth     libauth.MAC // if authentication is on
 }
 
 type RegisterGameServerReply struct {
@@ -30,3 +33,12 @@ type RegisterGameServerReply struct {
 	GameServerID uint32 // Unique ID
 	Servers      []paxosrpc.Node
 }
+
+type DrainGameServerArgs struct {
+	HostPort string      // Host:Port of the game server being drained
+	Auth     libauth.MAC // if authentication is on
+}
+
+type DrainGameServerReply struct {
+	Status Status
+}
@@ -3,6 +3,7 @@ package centralrpc
 type RemoteCentralServer interface {
 	GetGameServerForClient(*GetGameServerForClientArgs, *GetGameServerForClientReply) error
 	RegisterGameServer(*RegisterGameServerArgs, *RegisterGameServerReply) error
+	DrainGameServer(*DrainGameServerArgs, *DrainGameServerReply) error
 }
 
 type CentralServer struct {
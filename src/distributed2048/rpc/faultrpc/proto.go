@@ -0,0 +1,66 @@
+package faultrpc
+
+import (
+	"distributed2048/libauth"
+)
+
+type Status int
+
+const (
+	OK       Status = iota + 1 // RPC was a success
+	BadSpec                    // the fault could not be parsed
+	NotFound                   // there is no fault with that ID
+)
+
+// Fault is a fault injected into a game server's Paxos messages.
+type Fault struct {
+	ID   int
+	Spec string // in the form libpaxos.ParseFaultRule reads
+}
+
+type AddFaultArgs struct {
+	Spec string
+	Auth libauth.MAC // if authentication is on
+}
+
+type AddFaultReply struct {
+	Status Status
+	ID     int
+	Error  string // why the spec is bad, if Status is BadSpec
+}
+
+type PartitionArgs struct {
+	Peers []uint32    // IDs of the game servers to cut off from
+	Auth  libauth.MAC // if authentication is on
+}
+
+type PartitionReply struct {
+	Status Status
+	ID     int // ID of the fault that does so
+}
+
+type RemoveFaultArgs struct {
+	ID   int
+	Auth libauth.MAC // if authentication is on
+}
+
+type RemoveFaultReply struct {
+	Status Status
+}
+
+type ListFaultsArgs struct {
+	Auth libauth.MAC // if authentication is on
+}
+
+type ListFaultsReply struct {
+	Status Status
+	Faults []Fault // in the order they are checked
+}
+
+type ClearFaultsArgs struct {
+	Auth libauth.MAC // if authentication is on
+}
+
+type ClearFaultsReply struct {
+	Status Status
+}
@@ -0,0 +1,23 @@
+package faultrpc
+
+type RemoteFaultInjector interface {
+	// AddFault adds a fault, given in the form libpaxos.ParseFaultRule
+	// reads, and returns its ID.
+	AddFault(*AddFaultArgs, *AddFaultReply) error
+	// Partition cuts the game server off from the given peers.
+	Partition(*PartitionArgs, *PartitionReply) error
+	RemoveFault(*RemoveFaultArgs, *RemoveFaultReply) error
+	ListFaults(*ListFaultsArgs, *ListFaultsReply) error
+	// ClearFaults removes every fault, healing any partition.
+	ClearFaults(*ClearFaultsArgs, *ClearFaultsReply) error
+}
+
+type FaultInjector struct {
+	RemoteFaultInjector
+}
+
+// Wrap wraps fi in a type-safe wrapper struct to ensure that only the desired
+// FaultInjector methods are exported to receive RPCs.
+func Wrap(fi RemoteFaultInjector) RemoteFaultInjector {
+	return &FaultInjector{fi}
+}
@@ -2,6 +2,7 @@ package paxosrpc
 
 import (
 	"distributed2048/lib2048"
+	"distributed2048/libtrace"
 	"fmt"
 )
 
@@ -26,24 +27,36 @@ func (a *ProposalNumber) String() string {
 	return fmt.Sprintf("(%d, %d)", a.Number, a.NodeID)
 }
 
+// GameData is a binary snapshot of a game, as taken by MarshalBinary.
 type GameData struct {
-	Grid        lib2048.Grid
-	Score       int
-	RandCurrent uint32
+	Snapshot []byte
 }
 
-func NewGameData(game lib2048.Game2048) *GameData {
-	return &GameData{game.GetBoard(), game.GetScore(), game.GetRand().GetCurrent()}
+func NewGameData(game lib2048.Game2048) (*GameData, error) {
+	snapshot, err := game.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &GameData{snapshot}, nil
+}
+
+func (gd *GameData) CopyInto(game lib2048.Game2048) error {
+	return game.UnmarshalBinary(gd.Snapshot)
 }
 
-func (gd *GameData) CopyInto(game lib2048.Game2048) {
-	game.SetGrid(gd.Grid)
-	game.SetScore(gd.Score)
-	game.GetRand().SetCurrent(gd.RandCurrent)
+// NewGameProposal suggests the options, including the seed, for the game
+// numbered GameNumber. Every game server proposes one when a game ends, and
+// the first to be decided for a game number is used by all of them.
+type NewGameProposal struct {
+	GameNumber uint32
+	Options    lib2048.Options
 }
 
+// ProposalValue either holds a batch of votes, or proposes a new game.
 type ProposalValue struct {
-	Moves []lib2048.Move
+	Moves   []lib2048.Move
+	NewGame *NewGameProposal
+	Trace   libtrace.SpanContext // the span that proposed the votes, if traced
 }
 
 type Proposal struct {
@@ -1,5 +1,11 @@
 package paxosrpc
 
+import (
+	"distributed2048/libauth"
+	"distributed2048/libtrace"
+	"strconv"
+)
+
 type Status int
 
 type Node struct {
@@ -13,10 +19,24 @@ const (
 	DecidedValueExists
 )
 
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case Reject:
+		return "Reject"
+	case DecidedValueExists:
+		return "DecidedValueExists"
+	}
+	return "Status(" + strconv.Itoa(int(s)) + ")"
+}
+
 type ReceivePrepareArgs struct {
 	Node              Node
 	ProposalNumber    ProposalNumber
 	CommandSlotNumber uint32
+	Trace             libtrace.SpanContext // the proposer's prepare phase, if traced
+	Auth              libauth.MAC          // if authentication is on
 }
 
 type ReceivePrepareReply struct {
@@ -29,6 +49,8 @@ type ReceivePrepareReply struct {
 
 type ReceiveAcceptArgs struct {
 	Proposal Proposal
+	Trace    libtrace.SpanContext // the proposer's accept phase, if traced
+	Auth     libauth.MAC          // if authentication is on
 }
 
 type ReceiveAcceptReply struct {
@@ -37,6 +59,8 @@ type ReceiveAcceptReply struct {
 
 type ReceiveDecideArgs struct {
 	Proposal Proposal
+	Trace    libtrace.SpanContext // the proposer's decide phase, if traced
+	Auth     libauth.MAC          // if authentication is on
 }
 
 type ReceiveDecideReply struct {
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"distributed2048/cmdlineclient"
+	"distributed2048/lib2048"
+	"distributed2048/libai"
+	"distributed2048/libconfig"
+	"distributed2048/liblog"
+	"distributed2048/libtls"
+	"distributed2048/libtrace"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchFlags holds the flags of bench, other than the settings.
+type benchFlags struct {
+	numClients          int
+	gameServerHostPorts string
+	duration            time.Duration
+	ramp                float64
+	interval            int
+	behaviors           string
+	think               string
+	favorite            string
+	bias                float64
+	burstSize           int
+	burstPause          string
+	churnLife           string
+	churnAway           string
+	seed                int64
+	verbose             bool
+}
+
+// benchConfig holds the parsed flags shared by every player.
+type benchConfig struct {
+	cservAddr  string
+	gameServs  []string
+	tls        *libtls.Config
+	interval   int // in milliseconds
+	think      distribution
+	burstPause distribution
+	burstSize  int
+	churnLife  distribution
+	churnAway  distribution
+	favorite   lib2048.Direction
+	bias       float64
+	advisor    libai.Advisor
+}
+
+// runBench simulates a crowd of players to put load on a cluster. Every
+// player is a cmdlineclient, connected through the central server (or
+// straight to the given game servers), that votes according to one of a mix
+// of behaviors and a distribution of think times. When the run is over it
+// reports the latency from vote to applied state, the throughput, and how
+// the players were spread over the game servers.
+func runBench(args []string) {
+	fs, settings := newFlags("bench", "")
+	f := &benchFlags{}
+	fs.IntVar(&f.numClients, "numClients", 100, "number of simulated players")
+	libconfig.HostPortVar(fs, &settings.Central.Host, &settings.Central.Port, "csHostPort", "host:port of central server")
+	fs.StringVar(&f.gameServerHostPorts, "gsHostPorts", "", "comma separated list of host:port for each game server, to connect to directly instead of going through the central server")
+	fs.DurationVar(&f.duration, "duration", 30*time.Second, "how long to run for")
+	fs.Float64Var(&f.ramp, "ramp", 50, "how many players connect per second at the start of the run")
+	fs.IntVar(&f.interval, "interval", 100, "how often each client sends its queued vote, in milliseconds")
+	fs.StringVar(&f.behaviors, "behaviors", "random", "comma separated mix of behavior:weight (random, ai, biased, bursty, churn)")
+	fs.StringVar(&f.think, "think", "exp:1s", "time a player thinks before each vote (const:<d>, uniform:<min>,<max>, exp:<mean> or normal:<mean>,<stddev>)")
+	fs.StringVar(&f.favorite, "favorite", "up", "direction that biased players prefer")
+	fs.Float64Var(&f.bias, "bias", 0.8, "chance that a biased player votes for its favorite direction")
+	fs.IntVar(&f.burstSize, "burstSize", 5, "number of votes a bursty player sends in a row")
+	fs.StringVar(&f.burstPause, "burstPause", "exp:10s", "time a bursty player goes quiet between bursts")
+	fs.StringVar(&f.churnLife, "churnLife", "exp:15s", "time a churning player stays connected")
+	fs.StringVar(&f.churnAway, "churnAway", "exp:2s", "time a churning player stays away before reconnecting")
+	fs.Int64Var(&f.seed, "seed", 0, "seed for the players' choices (random if 0)")
+	fs.BoolVar(&f.verbose, "verbose", false, "show client logs")
+	fs.StringVar(&settings.Log.Trace, "trace", "", "file or collector URL to export the players' spans to (overrides D2048_TRACE)")
+	parseFlags(fs, args, settings)
+	conf, m, err := f.parse(settings)
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !f.verbose {
+		liblog.SetLevel(liblog.CLIENT, liblog.OFF)
+	}
+	if f.seed == 0 {
+		f.seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Starting %d players for %s (seed %d)\n", f.numClients, f.duration, f.seed)
+
+	s := newStats()
+	ctx, cancel := context.WithTimeout(context.Background(), f.duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	seeds := rand.New(rand.NewSource(f.seed))
+	for i := 0; i < f.numClients; i++ {
+		p := &player{
+			id:   i,
+			conf: conf,
+			r:    rand.New(rand.NewSource(seeds.Int63())),
+			s:    s,
+		}
+		p.behavior = m.pick(p.r)
+		delay := time.Duration(float64(i) / f.ramp * float64(time.Second))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-time.After(delay):
+				p.run(ctx)
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	fmt.Println("Stopping players...")
+	wg.Wait()
+	s.scrapeLimits(conf.tls)
+	if err := libtrace.Flush(); err != nil {
+		fmt.Println("Could not export spans:", err)
+	}
+	fmt.Println()
+	s.report(os.Stdout)
+}
+
+func (f *benchFlags) parse(settings *libconfig.Config) (*benchConfig, *mix, error) {
+	if f.numClients < 1 || f.ramp <= 0 || f.interval < 1 || f.burstSize < 1 {
+		return nil, nil, fmt.Errorf("numClients, ramp, interval and burstSize must be positive")
+	}
+	m, err := parseMix(f.behaviors)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conf := &benchConfig{
+		cservAddr: settings.CentralURL(),
+		tls:       settings.TLSConfig(),
+		interval:  f.interval,
+		burstSize: f.burstSize,
+		bias:      f.bias,
+		advisor:   libai.NewExpectimax(libai.DefaultDepth),
+	}
+	if f.gameServerHostPorts != "" {
+		conf.cservAddr = ""
+		conf.gameServs = strings.Split(f.gameServerHostPorts, ",")
+	}
+	for _, d := range []struct {
+		s   string
+		dst *distribution
+	}{
+		{f.think, &conf.think},
+		{f.burstPause, &conf.burstPause},
+		{f.churnLife, &conf.churnLife},
+		{f.churnAway, &conf.churnAway},
+	} {
+		if *d.dst, err = parseDistribution(d.s); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch strings.ToLower(f.favorite) {
+	case "up":
+		conf.favorite = lib2048.Up
+	case "down":
+		conf.favorite = lib2048.Down
+	case "left":
+		conf.favorite = lib2048.Left
+	case "right":
+		conf.favorite = lib2048.Right
+	default:
+		return nil, nil, fmt.Errorf("unknown direction %q", f.favorite)
+	}
+	return conf, m, nil
+}
+
+// player is one simulated member of the crowd.
+type player struct {
+	id       int
+	behavior behavior
+	conf     *benchConfig
+	r        *rand.Rand
+	s        *stats
+}
+
+// run connects the player and keeps it voting until ctx is done. Churning
+// players leave and come back every so often; everyone else stays until the
+// end, unless their client stops.
+func (p *player) run(ctx context.Context) {
+	for {
+		client, err := p.connect(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.s.connectFailed()
+		} else {
+			p.s.connected(client.GetSnapshot().Server, p.behavior)
+			var leave <-chan time.Time
+			if p.behavior == churn {
+				leave = time.After(p.conf.churnLife.sample(p.r))
+			}
+			p.play(ctx, client, leave)
+			if err := client.Close(); err != nil {
+				p.s.connectFailed()
+			}
+		}
+
+		// Come back after a while if churning, or after failing to connect
+		away := p.conf.churnAway.sample(p.r)
+		if p.behavior != churn {
+			away = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(away):
+		}
+	}
+}
+
+func (p *player) connect(ctx context.Context) (cmdlineclient.Cclient, error) {
+	gameServ := ""
+	if len(p.conf.gameServs) > 0 {
+		gameServ = p.conf.gameServs[p.id%len(p.conf.gameServs)]
+	}
+	return cmdlineclient.NewCClient(ctx, p.conf.cservAddr, gameServ, p.conf.interval, p.conf.tls)
+}
+
+// play votes on client until ctx is done, leave fires or the client stops,
+// timing how long it takes for a move to be applied after each vote.
+func (p *player) play(ctx context.Context, client cmdlineclient.Cclient, leave <-chan time.Time) {
+	c := &chooser{p.behavior, p.conf.favorite, p.conf.bias, p.conf.advisor}
+	updates := client.Subscribe(ctx)
+	lastCount := client.GetSnapshot().MoveCount
+	applied := 0
+	var votedAt time.Time // when the oldest vote still waiting for a move was input
+	burstLeft := 0
+
+	next := time.NewTimer(p.conf.think.sample(p.r))
+	defer next.Stop()
+	for {
+		select {
+		case <-leave:
+			p.s.left(applied, !votedAt.IsZero())
+			return
+		case snapshot, ok := <-updates:
+			if !ok {
+				// Either ctx is done or the client could not reconnect
+				p.s.left(applied, !votedAt.IsZero())
+				return
+			}
+			count := snapshot.MoveCount
+			if count == lastCount {
+				continue
+			}
+			if count > lastCount {
+				applied += count - lastCount
+			} else {
+				// A new game has started
+				applied += count
+			}
+			lastCount = count
+			if !votedAt.IsZero() {
+				p.s.answered(time.Since(votedAt))
+				votedAt = time.Time{}
+			}
+		case <-next.C:
+			client.InputMove(c.choose(p.r, client.GetGameState()))
+			p.s.voted(client.GetSnapshot().Server)
+			if votedAt.IsZero() {
+				votedAt = time.Now()
+			}
+			next.Reset(p.nextVote(&burstLeft))
+		}
+	}
+}
+
+// nextVote returns how long the player waits before voting again. A bursty
+// player votes once every client interval until its burst is over, so that
+// each vote of the burst gets sent, and then pauses.
+func (p *player) nextVote(burstLeft *int) time.Duration {
+	if p.behavior != bursty {
+		return p.conf.think.sample(p.r)
+	}
+	if *burstLeft == 0 {
+		*burstLeft = p.conf.burstSize
+	}
+	*burstLeft--
+	if *burstLeft > 0 {
+		return time.Duration(p.conf.interval) * time.Millisecond
+	}
+	return p.conf.burstPause.sample(p.r)
+}
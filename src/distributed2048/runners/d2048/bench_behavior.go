@@ -0,0 +1,171 @@
+package main
+
+import (
+	"distributed2048/lib2048"
+	"distributed2048/libai"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A behavior decides how one simulated player votes.
+type behavior int
+
+const (
+	random behavior = iota // votes for a random direction
+	ai                     // votes for the move the AI suggests
+	biased                 // mostly votes for one direction
+	bursty                 // votes several times in a row, then goes quiet
+	churn                  // votes randomly, and disconnects and reconnects every so often
+)
+
+var behaviorNames = map[string]behavior{
+	"random": random,
+	"ai":     ai,
+	"biased": biased,
+	"bursty": bursty,
+	"churn":  churn,
+}
+
+func (b behavior) String() string {
+	for name, other := range behaviorNames {
+		if other == b {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// mix picks a behavior for each new player, with a chance proportional to
+// the behavior's weight.
+type mix struct {
+	behaviors []behavior
+	weights   []int
+	total     int
+}
+
+// parseMix parses a comma separated list of behavior:weight pairs, like
+// "random:70,ai:10,churn:20". A behavior without a weight has weight 1.
+func parseMix(s string) (*mix, error) {
+	m := &mix{}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		b, ok := behaviorNames[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown behavior %q", fields[0])
+		}
+		weight := 1
+		if len(fields) == 2 {
+			var err error
+			if weight, err = strconv.Atoi(fields[1]); err != nil || weight < 0 {
+				return nil, fmt.Errorf("invalid weight %q for behavior %s", fields[1], fields[0])
+			}
+		}
+		m.behaviors = append(m.behaviors, b)
+		m.weights = append(m.weights, weight)
+		m.total += weight
+	}
+	if m.total == 0 {
+		return nil, errors.New("behavior weights add up to zero")
+	}
+	return m, nil
+}
+
+func (m *mix) pick(r *rand.Rand) behavior {
+	n := r.Intn(m.total)
+	for i, weight := range m.weights {
+		if n < weight {
+			return m.behaviors[i]
+		}
+		n -= weight
+	}
+	return m.behaviors[len(m.behaviors)-1]
+}
+
+// A distribution draws random durations, such as how long a player thinks
+// before voting.
+type distribution interface {
+	sample(r *rand.Rand) time.Duration
+	String() string
+}
+
+type constant struct{ d time.Duration }
+type uniform struct{ min, max time.Duration }
+type exponential struct{ mean time.Duration }
+type normal struct{ mean, stddev time.Duration }
+
+func (c constant) sample(r *rand.Rand) time.Duration { return c.d }
+func (c constant) String() string                    { return "const:" + c.d.String() }
+
+func (u uniform) sample(r *rand.Rand) time.Duration {
+	return u.min + time.Duration(r.Int63n(int64(u.max-u.min)+1))
+}
+func (u uniform) String() string { return "uniform:" + u.min.String() + "," + u.max.String() }
+
+func (e exponential) sample(r *rand.Rand) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(e.mean))
+}
+func (e exponential) String() string { return "exp:" + e.mean.String() }
+
+func (n normal) sample(r *rand.Rand) time.Duration {
+	d := time.Duration(r.NormFloat64()*float64(n.stddev)) + n.mean
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+func (n normal) String() string { return "normal:" + n.mean.String() + "," + n.stddev.String() }
+
+// parseDistribution parses a distribution of durations, written as one of
+// const:<d>, uniform:<min>,<max>, exp:<mean> or normal:<mean>,<stddev>.
+func parseDistribution(s string) (distribution, error) {
+	fields := strings.SplitN(s, ":", 2)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid distribution %q", s)
+	}
+	var args []time.Duration
+	for _, arg := range strings.Split(fields[1], ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(arg))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid duration %q in distribution %q", arg, s)
+		}
+		args = append(args, d)
+	}
+
+	switch {
+	case fields[0] == "const" && len(args) == 1:
+		return constant{args[0]}, nil
+	case fields[0] == "uniform" && len(args) == 2 && args[0] <= args[1]:
+		return uniform{args[0], args[1]}, nil
+	case fields[0] == "exp" && len(args) == 1:
+		return exponential{args[0]}, nil
+	case fields[0] == "normal" && len(args) == 2:
+		return normal{args[0], args[1]}, nil
+	}
+	return nil, fmt.Errorf("invalid distribution %q", s)
+}
+
+// chooser picks the direction a player votes for.
+type chooser struct {
+	behavior behavior
+	favorite lib2048.Direction // direction a biased player prefers
+	bias     float64           // chance that a biased player votes for favorite
+	advisor  libai.Advisor
+}
+
+func (c *chooser) choose(r *rand.Rand, game lib2048.Game2048) lib2048.Direction {
+	switch c.behavior {
+	case ai:
+		if dir, ok := c.advisor.SuggestMove(game); ok {
+			return dir
+		}
+	case biased:
+		if r.Float64() < c.bias {
+			return c.favorite
+		}
+	}
+	return lib2048.Up + lib2048.Direction(r.Intn(4))
+}
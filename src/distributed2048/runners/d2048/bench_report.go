@@ -0,0 +1,192 @@
+package main
+
+import (
+	"distributed2048/libtls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IP_LIMIT_METRIC counts the votes a game server rejected for its per-IP
+// limit.
+const IP_LIMIT_METRIC = `gameserver_votes_rejected_total{reason="ip_limit"}`
+
+// stats collects what every player observed during a run.
+type stats struct {
+	mutex       sync.Mutex
+	started     time.Time
+	latencies   []time.Duration // from a vote being input to the next move being applied
+	votes       int
+	unanswered  int // votes that no applied move followed before the player left
+	applied     int // most moves any one player saw applied
+	connects    int
+	connectErrs int
+	perServer   map[string]*serverStats
+	perBehavior map[behavior]int
+}
+
+type serverStats struct {
+	connects   int
+	votes      int
+	ipRejected float64 // votes the game server has rejected for its per-IP limit since it started, -1 if unknown
+}
+
+func newStats() *stats {
+	return &stats{
+		started:     time.Now(),
+		perServer:   make(map[string]*serverStats),
+		perBehavior: make(map[behavior]int),
+	}
+}
+
+func (s *stats) server(hostport string) *serverStats {
+	if hostport == "" {
+		hostport = "(reconnecting)"
+	}
+	ss, ok := s.perServer[hostport]
+	if !ok {
+		ss = &serverStats{ipRejected: -1}
+		s.perServer[hostport] = ss
+	}
+	return ss
+}
+
+func (s *stats) connected(hostport string, b behavior) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connects++
+	s.server(hostport).connects++
+	s.perBehavior[b]++
+}
+
+func (s *stats) connectFailed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connectErrs++
+}
+
+func (s *stats) voted(hostport string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.votes++
+	s.server(hostport).votes++
+}
+
+func (s *stats) answered(latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.latencies = append(s.latencies, latency)
+}
+
+// left records a player leaving, with the number of moves it saw applied and
+// whether its last vote was still waiting for one.
+func (s *stats) left(applied int, waiting bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if applied > s.applied {
+		s.applied = applied
+	}
+	if waiting {
+		s.unanswered++
+	}
+}
+
+// scrapeLimits asks every game server that players connected to how many
+// votes it has rejected for its per-IP limit.
+func (s *stats) scrapeLimits(tlsConfig *libtls.Config) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	client := tlsConfig.HTTPClient(STATUS_TIMEOUT)
+	for hostport, ss := range s.perServer {
+		resp, err := client.Get(tlsConfig.URL(hostport) + "/metrics")
+		if err != nil {
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		ss.ipRejected = 0
+		for _, line := range strings.Split(string(body), "\n") {
+			if strings.HasPrefix(line, IP_LIMIT_METRIC+" ") {
+				ss.ipRejected, _ = strconv.ParseFloat(strings.TrimPrefix(line, IP_LIMIT_METRIC+" "), 64)
+			}
+		}
+	}
+}
+
+// percentile returns the p-th percentile of sorted, which must not be empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p / 100 * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+func (s *stats) report(w io.Writer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	elapsed := time.Since(s.started)
+
+	fmt.Fprintf(w, "Ran for %s\n", elapsed)
+	fmt.Fprintf(w, "\nConnections: %d (%d failed)\n", s.connects, s.connectErrs)
+	for _, name := range []string{"random", "ai", "biased", "bursty", "churn"} {
+		if n := s.perBehavior[behaviorNames[name]]; n > 0 {
+			fmt.Fprintf(w, "  %-8s %d\n", name, n)
+		}
+	}
+
+	fmt.Fprintf(w, "\nThroughput:\n")
+	fmt.Fprintf(w, "  votes sent     %d (%.1f/s)\n", s.votes, float64(s.votes)/elapsed.Seconds())
+	fmt.Fprintf(w, "  moves applied  %d (%.1f/s)\n", s.applied, float64(s.applied)/elapsed.Seconds())
+
+	fmt.Fprintf(w, "\nLatency from vote to applied state (%d votes, %d unanswered):\n", len(s.latencies), s.unanswered)
+	if len(s.latencies) > 0 {
+		sorted := append([]time.Duration(nil), s.latencies...)
+		sort.Sort(durations(sorted))
+		var total time.Duration
+		for _, l := range sorted {
+			total += l
+		}
+		fmt.Fprintf(w, "  min  %s\n", sorted[0])
+		fmt.Fprintf(w, "  mean %s\n", total/time.Duration(len(sorted)))
+		for _, p := range []float64{50, 90, 99} {
+			fmt.Fprintf(w, "  p%-3.0f %s\n", p, percentile(sorted, p))
+		}
+		fmt.Fprintf(w, "  max  %s\n", sorted[len(sorted)-1])
+	}
+
+	fmt.Fprintf(w, "\nPer server:\n")
+	servers := make([]string, 0, len(s.perServer))
+	for hostport := range s.perServer {
+		servers = append(servers, hostport)
+	}
+	sort.Strings(servers)
+	limited := false
+	for _, hostport := range servers {
+		ss := s.perServer[hostport]
+		fmt.Fprintf(w, "  %-22s %6d connections %8d votes", hostport, ss.connects, ss.votes)
+		if ss.ipRejected > 0 {
+			fmt.Fprintf(w, " %8.0f rejected for the per-IP limit", ss.ipRejected)
+			limited = true
+		}
+		fmt.Fprintln(w)
+	}
+	if limited {
+		fmt.Fprintln(w, "\nWARNING: game servers rejected votes for their per-IP limit. Every player runs")
+		fmt.Fprintln(w, "from this host, so their votes beyond it were ignored and the numbers above do")
+		fmt.Fprintln(w, "not measure the cluster. Run it with per_ip = 0 in the [voting] table of its")
+		fmt.Fprintln(w, "config.")
+	}
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"distributed2048/centralserver"
+	"fmt"
+)
+
+func runCentral(args []string) {
+	fs, conf := newFlags("central", "")
+	fs.IntVar(&conf.Central.Port, "port", conf.Central.Port, "port number to listen on")
+	fs.IntVar(&conf.Central.GameServers, "gameservers", conf.Central.GameServers, "the number of game servers in the cluster")
+	logFlags(fs, conf)
+	parseFlags(fs, args, conf)
+
+	if _, err := centralserver.NewCentralServer(conf.Central.Port, conf.Central.GameServers, conf.TLSConfig(), conf.Secret()); err != nil {
+		fail(fmt.Sprint("Could not create central server: ", err))
+	}
+	fmt.Println("Central Server running on port", conf.Central.Port)
+
+	// Run the central server forever
+	select {}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"distributed2048/libtls"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runCerts makes a CA in a directory, unless there is one there already, and
+// a certificate signed by it, for running the cluster with TLS. Every node
+// can share one certificate, as long as it names all of their hosts, or each
+// can be given its own with -name.
+func runCerts(args []string) {
+	fs := flag.NewFlagSet("d2048 certs", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: d2048 certs [flags]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", "certs", "directory to write the CA and the certificate into")
+	name := fs.String("name", "node", "name of the certificate, which is written to <name>.pem and <name>-key.pem")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma separated host names and IP addresses that the certificate is valid for")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fail(err)
+	}
+	ca, err := libtls.GenerateCA(*dir)
+	if err != nil {
+		fail(fmt.Sprint("Could not make the CA: ", err))
+	}
+	cert, key, err := libtls.GenerateCert(*dir, *name, strings.Split(*hosts, ","))
+	if err != nil {
+		fail(fmt.Sprint("Could not make the certificate: ", err))
+	}
+	fmt.Printf("CA:          %s (key in %s)\n", ca, filepath.Join(*dir, libtls.CA_KEY))
+	fmt.Printf("Certificate: %s (key in %s)\n", cert, key)
+	fmt.Printf("\nRun the commands with -tlsCA=%s -tlsCert=%s -tlsKey=%s\n", ca, cert, key)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"distributed2048/centralserver"
+	"distributed2048/gameserver"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runCluster runs a central server and its game servers in this process, on
+// consecutive ports from the game server port of the config, and serves the
+// web client, for trying the game out on one machine. It stops on Ctrl-C.
+func runCluster(args []string) {
+	fs, conf := newFlags("cluster", "")
+	conf.Central.GameServers = 3
+	fs.IntVar(&conf.Central.Port, "port", conf.Central.Port, "port number for the central server to listen on")
+	fs.IntVar(&conf.Central.GameServers, "gameservers", conf.Central.GameServers, "the number of game servers to run")
+	fs.IntVar(&conf.GameServer.Port, "gamePort", conf.GameServer.Port, "port number of the first game server, the others taking the ports after it")
+	fs.StringVar(&conf.GameServer.ReplayDir, "replayDir", "", "directory to record game replays into (disabled if empty)")
+	fs.StringVar(&conf.Game.Variant, "variant", conf.Game.Variant, "rules for new games (classic, blockers, fibonacci or x3)")
+	web := fs.Bool("web", true, "whether to serve the web client too, on the web port of the config")
+	logFlags(fs, conf)
+	parseFlags(fs, args, conf)
+	options, _ := conf.Game.Options() // checked by parseFlags
+	n := conf.Central.GameServers
+
+	central, err := centralserver.NewCentralServer(conf.Central.Port, n, conf.TLSConfig(), conf.Secret())
+	if err != nil {
+		fail(fmt.Sprint("Could not create central server: ", err))
+	}
+	fmt.Println("Central Server running on port", conf.Central.Port)
+
+	// Game servers only finish starting once all of them have registered,
+	// so start them all at once
+	servers := make([]gameserver.GameServer, n)
+	errs := make(chan error, n)
+	for i := range servers {
+		go func(i int) {
+			var err error
+			servers[i], err = gameserver.NewGameServer(conf.Central.HostPort(), conf.GameServer.Hostname, conf.GameServer.Port+i, conf.GameServer.WebsocketPath, conf.GameServer.ReplayDir, options, conf.TLSConfig(), conf.Secret())
+			errs <- err
+		}(i)
+	}
+	for range servers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		fail(fmt.Sprint("Could not create game server: ", err))
+	}
+	for _, gs := range servers {
+		fmt.Printf("Game Server %d running on %s\n", gs.ID(), gs.HostPort())
+	}
+
+	if *web {
+		go func() {
+			fail(listenAndServe(conf, conf.Web.Port, webHandler(conf)))
+		}()
+		fmt.Printf("Web client on %s\n", conf.TLSConfig().URL(fmt.Sprintf("localhost:%d", conf.Web.Port)))
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	fmt.Println("Stopping the cluster")
+	for _, gs := range servers {
+		gs.Close()
+	}
+	central.Close()
+}
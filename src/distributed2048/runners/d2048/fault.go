@@ -0,0 +1,117 @@
+package main
+
+import (
+	"distributed2048/libconfig"
+	"distributed2048/rpc/faultrpc"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runFault adds and removes the faults injected into a game server's Paxos
+// messages, through the FaultInjector RPC service that d2048 game -faultAdmin
+// serves. See libpaxos.ParseFaultRule for the form of a spec.
+func runFault(args []string) {
+	fs, conf := newFaultFlags()
+	parseFlags(fs, args, conf)
+	if err := fault(conf, fs.Args(), os.Stdout); err == errUsage {
+		fs.Usage()
+		os.Exit(2)
+	} else if err != nil {
+		fail(err)
+	}
+}
+
+func newFaultFlags() (*flag.FlagSet, *libconfig.Config) {
+	fs, conf := newFlags("fault", "add <spec> | partition <id>,<id>... | remove <id> | list | clear")
+	libconfig.HostPortVar(fs, &conf.GameServer.Hostname, &conf.GameServer.Port, "server", "host:port of the game server")
+	return fs, conf
+}
+
+// errUsage is returned by fault for arguments that it does not take.
+var errUsage = errors.New("bad arguments")
+
+// fault runs the command that args give against the game server of conf,
+// printing what it returns to out.
+func fault(conf *libconfig.Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	c, err := conf.TLSConfig().DialRPC(conf.GameServer.HostPort())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	// Signed with the secret if authentication is on
+	call := func(serviceMethod string, args, reply interface{}) error {
+		if err := conf.Secret().Sign(serviceMethod, args); err != nil {
+			return err
+		}
+		return c.Call(serviceMethod, args, reply)
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 2 {
+			return errUsage
+		}
+		var reply faultrpc.AddFaultReply
+		if err := call("FaultInjector.AddFault", &faultrpc.AddFaultArgs{args[1], nil}, &reply); err != nil {
+			return err
+		}
+		if reply.Status != faultrpc.OK {
+			return errors.New(reply.Error)
+		}
+		fmt.Fprintln(out, reply.ID)
+	case "partition":
+		if len(args) != 2 {
+			return errUsage
+		}
+		var peers []uint32
+		for _, s := range strings.Split(args[1], ",") {
+			id, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return fmt.Errorf("bad game server ID %q", s)
+			}
+			peers = append(peers, uint32(id))
+		}
+		var reply faultrpc.PartitionReply
+		if err := call("FaultInjector.Partition", &faultrpc.PartitionArgs{peers, nil}, &reply); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, reply.ID)
+	case "remove":
+		if len(args) != 2 {
+			return errUsage
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("bad fault ID %q", args[1])
+		}
+		var reply faultrpc.RemoveFaultReply
+		if err := call("FaultInjector.RemoveFault", &faultrpc.RemoveFaultArgs{id, nil}, &reply); err != nil {
+			return err
+		}
+		if reply.Status == faultrpc.NotFound {
+			return fmt.Errorf("no fault with ID %d", id)
+		}
+	case "list":
+		var reply faultrpc.ListFaultsReply
+		if err := call("FaultInjector.ListFaults", &faultrpc.ListFaultsArgs{nil}, &reply); err != nil {
+			return err
+		}
+		for _, f := range reply.Faults {
+			fmt.Fprintf(out, "%d\t%s\n", f.ID, f.Spec)
+		}
+	case "clear":
+		var reply faultrpc.ClearFaultsReply
+		return call("FaultInjector.ClearFaults", &faultrpc.ClearFaultsArgs{nil}, &reply)
+	default:
+		return errUsage
+	}
+	return nil
+}
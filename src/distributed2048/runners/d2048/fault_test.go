@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"distributed2048/libauth"
+	"distributed2048/libconfig"
+	"distributed2048/libtls"
+	"distributed2048/tests/cluster"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// d2048 fault reaches a game server of a cluster with TLS and authentication
+// on, with the certificates and the secret of its config.
+func TestFaultSecureCluster(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := libtls.GenerateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := libtls.GenerateCert(dir, "node", []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := libtls.Load(ca, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("the cluster's secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := libauth.LoadSecret(secretFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cluster.New(cluster.Config{NumGameServers: 2, TLS: tlsConfig, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.GameServer(0).ServeFaultAdmin(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := "-server=" + c.GameServerHostPort(0)
+
+	// Without the certificates and the secret, d2048 fault is turned away
+	if err := runFaultForTest([]string{server, "list"}, &bytes.Buffer{}); err != libtls.ErrTLSRequired {
+		t.Errorf("Listing the faults of a secure game server without TLS got %v, expected ErrTLSRequired", err)
+	}
+
+	// With them, from the flags or the config file, it is let in
+	configFile := filepath.Join(dir, "d2048.toml")
+	config := fmt.Sprintf("[auth]\nsecret_file = %q\n", secretFile)
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flags := []string{server, "-config=" + configFile, "-tlsCA=" + ca, "-tlsCert=" + cert, "-tlsKey=" + key}
+	var out bytes.Buffer
+	if err := runFaultForTest(append(flags, "add", "drop,dir=out,action=accept"), &out); err != nil {
+		t.Fatal(err)
+	}
+	id := strings.TrimSpace(out.String())
+	out.Reset()
+	if err := runFaultForTest(append(flags, "list"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), id+"\tdrop") {
+		t.Errorf("Listed %q after adding fault %s", out.String(), id)
+	}
+	out.Reset()
+	if err := runFaultForTest(append(flags, "clear"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := runFaultForTest(append(flags, "list"), &out); err != nil || out.Len() != 0 {
+		t.Errorf("Listed %q (%v) after clearing the faults", out.String(), err)
+	}
+}
+
+// runFaultForTest does what runFault does with args, writing to out, but
+// returns the errors that it would exit with. It only loads the certificates
+// and the secret, since applying the rest of the config would change the
+// settings of the cluster running in this process.
+func runFaultForTest(args []string, out io.Writer) error {
+	fs, conf := newFaultFlags()
+	fs.Init("d2048 fault", flag.ContinueOnError)
+	if err := libconfig.ParseFlags(fs, args, conf); err != nil {
+		return err
+	}
+	if err := conf.Load(); err != nil {
+		return err
+	}
+	return fault(conf, fs.Args(), out)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"distributed2048/gameserver"
+	"distributed2048/lib2048"
+	"distributed2048/libconfig"
+	"distributed2048/libpaxos"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// faultList collects the -fault flags.
+type faultList []libpaxos.FaultRule
+
+func (l *faultList) String() string {
+	specs := make([]string, len(*l))
+	for i, r := range *l {
+		specs[i] = r.String()
+	}
+	return strings.Join(specs, " ")
+}
+
+func (l *faultList) Set(spec string) error {
+	r, err := libpaxos.ParseFaultRule(spec)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+// gameRunner runs a game server, with the faults it was asked to inject, and
+// starts it again after a crash fault.
+type gameRunner struct {
+	conf         *libconfig.Config
+	options      lib2048.Options
+	faults       faultList
+	partition    string
+	faultAdmin   bool
+	restartDelay time.Duration
+
+	isFaulty         bool
+	faultyPercent    int
+	lagDuration      int
+	lagPrepare       bool
+	lagAccept        bool
+	lagDecide        bool
+	maxLagSlotNumber int
+
+	mutex     sync.Mutex
+	gs        gameserver.GameServer
+	restarted chan struct{} // signalled after a crash fault restarts gs
+}
+
+func runGame(args []string) {
+	fs, conf := newFlags("game", "")
+	g := &gameRunner{conf: conf, restarted: make(chan struct{}, 1)}
+	fs.IntVar(&conf.GameServer.Port, "port", conf.GameServer.Port, "port number to listen on")
+	libconfig.HostPortVar(fs, &conf.Central.Host, &conf.Central.Port, "central", "host:port of central server")
+	fs.StringVar(&conf.GameServer.Hostname, "hostname", conf.GameServer.Hostname, "hostname of THIS game server")
+	fs.StringVar(&conf.GameServer.ReplayDir, "replayDir", "", "directory to record game replays into (disabled if empty)")
+	fs.UintVar(&conf.Game.Seed, "seed", 0, "seed to start every game with (random if 0)")
+	fs.StringVar(&conf.Game.RNG, "rng", conf.Game.RNG, "random number generator for new games (lcg, pcg or xorshift)")
+	fs.StringVar(&conf.Game.Variant, "variant", conf.Game.Variant, "rules for new games (classic, blockers, fibonacci or x3)")
+	logFlags(fs, conf)
+	fs.BoolVar(&g.isFaulty, "faulty", false, "whether this game server sometimes lags")
+	fs.IntVar(&g.faultyPercent, "faultyPercent", 25, "how frequently lag occurs")
+	fs.IntVar(&g.lagDuration, "lagDuration", 15, "how long the lag lasts, in seconds")
+	fs.BoolVar(&g.lagPrepare, "lagPrepare", true, "whether receiving a prepare should be lagged")
+	fs.BoolVar(&g.lagAccept, "lagAccept", true, "whether receiving an accept request should be lagged")
+	fs.BoolVar(&g.lagDecide, "lagDecide", true, "whether receiving a decide request should be lagged ")
+	fs.IntVar(&g.maxLagSlotNumber, "maxLagSlotNumber", 15, "maximum slot number to lag until")
+	fs.StringVar(&g.partition, "partition", "", "comma separated IDs of game servers to cut this one off from")
+	fs.BoolVar(&g.faultAdmin, "faultAdmin", false, "whether faults can be added and removed over RPC, e.g. with d2048 fault")
+	fs.DurationVar(&g.restartDelay, "restartDelay", 2*time.Second, "how long a crash fault keeps this game server down")
+	fs.Var(&g.faults, "fault", "fault to inject into Paxos messages, e.g. drop,dir=out,action=accept,nth=3 (may be repeated)")
+	parseFlags(fs, args, conf)
+	g.options, _ = conf.Game.Options() // checked by parseFlags
+
+	g.mutex.Lock()
+	err := g.start(false)
+	g.mutex.Unlock()
+	if err != nil {
+		fail(fmt.Sprint("Could not create game server: ", err))
+	}
+	fmt.Printf("Game Server running on %s\n", conf.GameServer.HostPort())
+
+	// Run the game server until it is shut down, by SIGTERM or through the
+	// GameServerAdmin RPC service
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	for {
+		g.mutex.Lock()
+		gs := g.gs
+		g.mutex.Unlock()
+		select {
+		case <-g.restarted:
+			continue
+		case <-sig:
+			fmt.Println("Shutting down, waiting for the last votes to be decided")
+			ctx, cancel := context.WithTimeout(context.Background(), gameserver.DrainTimeout)
+			err := gs.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				fail(fmt.Sprint("Could not shut down gracefully: ", err))
+			}
+		case <-gs.Drained():
+		}
+		fmt.Println("Game Server drained and stopped")
+		return
+	}
+}
+
+func actionString(action libpaxos.PaxosAction) string {
+	switch action {
+	case libpaxos.Prepare:
+		return "Prepare"
+	case libpaxos.Accept:
+		return "Accept"
+	case libpaxos.Decide:
+		return "Decide"
+	}
+	return ""
+}
+
+func (g *gameRunner) interrupt(id uint32, action libpaxos.PaxosAction, slotNumber uint32) {
+	if int(slotNumber) > g.maxLagSlotNumber {
+		return
+	}
+	if (g.lagPrepare && action == libpaxos.Prepare) ||
+		(g.lagAccept && action == libpaxos.Accept) ||
+		(g.lagDecide && action == libpaxos.Decide) {
+		if num := rand.Intn(100); num < g.faultyPercent {
+			fmt.Printf("Lagging game server %d on %s step for %d seconds\n", int(id), actionString(action), g.lagDuration)
+			time.Sleep(time.Duration(g.lagDuration) * time.Second)
+		}
+	}
+}
+
+// start starts the game server and sets up its faults. Crash faults only
+// fire once, so they are not set up again after a restart. It must be called
+// with the mutex held.
+func (g *gameRunner) start(restarted bool) error {
+	conf := g.conf
+	gs, err := gameserver.NewGameServer(conf.Central.HostPort(), conf.GameServer.Hostname, conf.GameServer.Port, conf.GameServer.WebsocketPath, conf.GameServer.ReplayDir, g.options, conf.TLSConfig(), conf.Secret())
+	if err != nil {
+		return err
+	}
+	g.gs = gs
+	if g.isFaulty {
+		gs.GetLibpaxos().SetInterruptFunc(g.interrupt)
+	}
+
+	fi := gs.GetLibpaxos().Faults()
+	for _, r := range g.faults {
+		if !restarted || r.Kind != libpaxos.FaultCrash {
+			fi.AddRule(r)
+		}
+	}
+	if g.partition != "" {
+		var peers []uint32
+		for _, s := range strings.Split(g.partition, ",") {
+			id, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return fmt.Errorf("bad game server ID %q in -partition", s)
+			}
+			peers = append(peers, uint32(id))
+		}
+		fi.Partition(peers)
+	}
+	fi.OnCrash(g.crash)
+	if g.faultAdmin {
+		return gs.ServeFaultAdmin()
+	}
+	return nil
+}
+
+// crash stops the game server, as a crash fault asks, and starts it again on
+// the same port after restartDelay.
+func (g *gameRunner) crash(action libpaxos.PaxosAction, slotNumber uint32) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	fmt.Printf("Crashing game server on %s step for slot %d, for %s\n", actionString(action), slotNumber, g.restartDelay)
+	g.gs.Close()
+	time.Sleep(g.restartDelay)
+	if err := g.start(true); err != nil {
+		fail(fmt.Sprint("Could not restart game server: ", err))
+	}
+	fmt.Printf("Game Server restarted on %s\n", g.conf.GameServer.HostPort())
+	select {
+	case g.restarted <- struct{}{}:
+	default:
+	}
+}
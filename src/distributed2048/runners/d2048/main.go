@@ -0,0 +1,129 @@
+// d2048 runs every part of distributed2048, as one of its commands:
+//
+//	d2048 central [flags]           run the central server
+//	d2048 game    [flags]           run a game server
+//	d2048 web     [flags]           serve the web client
+//	d2048 play    [flags]           play in the terminal
+//	d2048 bench   [flags]           simulate a crowd of players
+//	d2048 replay  [flags] <file>    verify a recorded game, and stream it
+//	d2048 status  [flags]           show how a cluster is doing
+//	d2048 cluster [flags]           run a whole cluster in this process
+//	d2048 certs   [flags]           make certificates for TLS
+//	d2048 fault   [flags] <cmd>     add and remove a game server's faults
+//	d2048 trace   [flags] <cmd>     collect spans, and explain moves
+//
+// Every command takes -config, for a config file of the settings that the
+// commands share (see libconfig), and flags for those of the settings that
+// it uses, which override the file. d2048 <command> -h lists them. Every
+// command also takes -tlsCA, -tlsCert and -tlsKey, which turn TLS on for
+// what it serves and what it connects to, and -secretFile, which turns on
+// authentication of the RPCs between servers.
+package main
+
+import (
+	"distributed2048/libconfig"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+type command struct {
+	name  string
+	args  string // what the command takes after its flags
+	short string
+	run   func(args []string)
+}
+
+var commands = []command{
+	{"central", "", "run the central server", runCentral},
+	{"game", "", "run a game server", runGame},
+	{"web", "", "serve the web client", runWeb},
+	{"play", "", "play in the terminal", runPlay},
+	{"bench", "", "simulate a crowd of players", runBench},
+	{"replay", "<file>", "verify a recorded game, and stream it", runReplay},
+	{"status", "", "show how a cluster is doing", runStatus},
+	{"cluster", "", "run a whole cluster in this process", runCluster},
+	{"certs", "", "make certificates for TLS", runCerts},
+	{"fault", "<command>", "add and remove a game server's faults", runFault},
+	{"trace", "<command>", "collect spans, and explain moves", runTrace},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: d2048 <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.short)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run d2048 <command> -h for the flags of a command.")
+	os.Exit(2)
+}
+
+// fail prints err and exits.
+func fail(err interface{}) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// newFlags returns the flag set of a command, which takes args after its
+// flags, and the settings that its flags are bound to, starting from the
+// defaults.
+func newFlags(name string, args string) (*flag.FlagSet, *libconfig.Config) {
+	fs := flag.NewFlagSet("d2048 "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: d2048 %s [flags] %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	conf := libconfig.Default()
+	fs.StringVar(&conf.TLS.CA, "tlsCA", "", "certificate of the CA that the cluster's certificates are signed by (TLS is off if not given)")
+	fs.StringVar(&conf.TLS.Cert, "tlsCert", "", "certificate to present to the rest of the cluster")
+	fs.StringVar(&conf.TLS.Key, "tlsKey", "", "key of the certificate given with -tlsCert")
+	fs.StringVar(&conf.Auth.SecretFile, "secretFile", "", "file holding the secret that the cluster's RPCs are authenticated with (off if not given)")
+	return fs, conf
+}
+
+// parseFlags parses the arguments of a command, along with its config file,
+// into conf, and applies the settings.
+func parseFlags(fs *flag.FlagSet, args []string, conf *libconfig.Config) {
+	if err := libconfig.ParseFlags(fs, args, conf); err != nil {
+		fail(err)
+	}
+	if err := conf.Apply(); err != nil {
+		fail(err)
+	}
+}
+
+// listenAndServe serves h on port, over TLS if conf turns it on.
+func listenAndServe(conf *libconfig.Config, port int, h http.Handler) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return http.Serve(conf.TLSConfig().Listener(l), h)
+}
+
+// logFlags binds the flags for the logging and tracing settings.
+func logFlags(fs *flag.FlagSet, conf *libconfig.Config) {
+	fs.StringVar(&conf.Log.Level, "logLevel", "", "log levels, e.g. info or info,paxos=debug (overrides D2048_LOG)")
+	fs.StringVar(&conf.Log.Format, "logFormat", "", "log format, text or json (overrides D2048_LOG_FORMAT)")
+	fs.StringVar(&conf.Log.Trace, "trace", "", "file or collector URL to export spans to, e.g. http://localhost:4318/v1/traces (overrides D2048_TRACE)")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			c.run(os.Args[2:])
+			return
+		}
+	}
+	if os.Args[1] != "-h" && os.Args[1] != "help" {
+		fmt.Fprintf(os.Stderr, "d2048: unknown command %q\n", os.Args[1])
+	}
+	usage()
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"distributed2048/cmdlineclient"
+	"distributed2048/lib2048"
+	"distributed2048/libai"
+	"distributed2048/liblog"
+	"distributed2048/util"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const cellWidth = 7
+
+// ANSI escape sequences used to draw the screen.
+const (
+	altScreenOn  = "\x1b[?1049h"
+	altScreenOff = "\x1b[?1049l"
+	hideCursor   = "\x1b[?25l"
+	showCursor   = "\x1b[?25h"
+	clearScreen  = "\x1b[H\x1b[2J"
+	reset        = "\x1b[0m"
+	bold         = "\x1b[1m"
+	dim          = "\x1b[2m"
+)
+
+// tileColors holds the 256-color background for each tile, indexed by the
+// tile's rank: 1 for the smallest tile of the variant, 2 for the next, and so
+// on. Ranks past the end of the table use its last color.
+var tileColors = []int{236, 230, 223, 215, 209, 203, 196, 227, 226, 220, 214, 208, 202}
+
+type key int
+
+const (
+	keyNone key = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHint
+	keyQuit
+)
+
+// runPlay is a full-screen terminal client. It connects through the central
+// server just like the browser client does, so a cluster can be played and
+// debugged over SSH, or plays a game of its own with -local.
+//
+// Arrow keys or WASD vote for a move, T shows a hint, and Q or Ctrl-C quits.
+func runPlay(args []string) {
+	fs, conf := newFlags("play", "")
+	central := fs.String("central", "", "address of the central server (by default, http:// and the central server's host:port in the config)")
+	server := fs.String("server", "", "host:port of a game server to connect to directly, bypassing the central server")
+	interval := fs.Int("interval", 50, "how often queued moves are sent to the server, in milliseconds")
+	logFile := fs.String("log", "", "file to write client logs to (discarded if empty, since they would garble the screen)")
+	local := fs.Bool("local", false, "play a game of this process's own, with the game settings of the config, instead of joining a cluster")
+	fs.UintVar(&conf.Game.Seed, "seed", 0, "seed to start -local games with (random if 0)")
+	fs.StringVar(&conf.Game.Variant, "variant", conf.Game.Variant, "rules for -local games (classic, blockers, fibonacci or x3)")
+	parseFlags(fs, args, conf)
+	if *central == "" {
+		*central = conf.CentralURL()
+	}
+
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		liblog.SetOutput(f)
+	} else {
+		liblog.SetOutput(ioutil.Discard)
+	}
+
+	// A local game is played straight away, while a client sends votes to
+	// the cluster and shows the states it sends back
+	var client cmdlineclient.Cclient
+	var game *localGame
+	var updates <-chan cmdlineclient.Snapshot
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *local {
+		options, _ := conf.Game.Options() // checked by parseFlags
+		game = newLocalGame(options)
+	} else {
+		fmt.Println("Connecting...")
+		var err error
+		client, err = cmdlineclient.NewCClient(ctx, *central, *server, *interval, conf.TLSConfig())
+		if err != nil {
+			fail(fmt.Sprint("Could not connect: ", err))
+		}
+		defer client.Close()
+		updates = client.Subscribe(ctx)
+	}
+
+	restore, err := rawMode()
+	if err != nil {
+		fail(fmt.Sprint("Could not put the terminal in raw mode: ", err))
+	}
+	fmt.Print(altScreenOn + hideCursor)
+	defer func() {
+		fmt.Print(showCursor + altScreenOff)
+		restore()
+	}()
+
+	keys := make(chan key)
+	go readKeys(keys)
+
+	advisor := libai.NewExpectimax(libai.DefaultDepth)
+	status := "Use the arrow keys or WASD to vote."
+	for {
+		if game != nil {
+			draw(game.snapshot(), status, "Vote for a move to start a new game.")
+		} else {
+			draw(client.GetSnapshot(), status, "A new game starts shortly.")
+		}
+		select {
+		case _, ok := <-updates:
+			if !ok {
+				// The client gave up reconnecting
+				return
+			}
+		case k := <-keys:
+			switch k {
+			case keyQuit:
+				return
+			case keyHint:
+				var state lib2048.Game2048
+				if game != nil {
+					state = game.copy()
+				} else {
+					state = client.GetGameState()
+				}
+				if dir, ok := advisor.SuggestMove(state); ok {
+					status = "Hint: try " + dir.String() + "."
+				} else {
+					status = "Hint: there are no moves left."
+				}
+			case keyUp, keyDown, keyLeft, keyRight:
+				dir := map[key]lib2048.Direction{
+					keyUp:    lib2048.Up,
+					keyDown:  lib2048.Down,
+					keyLeft:  lib2048.Left,
+					keyRight: lib2048.Right,
+				}[k]
+				if game != nil {
+					game.move(dir)
+				} else {
+					client.InputMove(dir)
+				}
+				status = "You voted " + dir.String() + "."
+			}
+		}
+	}
+}
+
+// localGame is a game played with -local, which starts again after it ends.
+type localGame struct {
+	options   lib2048.Options
+	game      lib2048.Game2048
+	consensus string // the last move
+}
+
+func newLocalGame(options lib2048.Options) *localGame {
+	g := &localGame{options: options}
+	if options.Seed == 0 {
+		options.Seed = rand.Uint32() // a different game every time, as on a cluster
+	}
+	g.game = lib2048.NewGame2048WithOptions(options)
+	return g
+}
+
+// copy returns a copy of the game, which the caller is free to modify.
+func (g *localGame) copy() lib2048.Game2048 {
+	game, _ := lib2048.NewGame2048FromState(g.game.GetState())
+	return game
+}
+
+func (g *localGame) move(dir lib2048.Direction) {
+	if g.game.IsGameOver() || g.game.IsGameWon() {
+		*g = *newLocalGame(g.options)
+		return
+	}
+	g.game.MakeMove(dir)
+	g.consensus = dir.String()
+}
+
+// snapshot returns the game as a client connected to a cluster would see it.
+func (g *localGame) snapshot() cmdlineclient.Snapshot {
+	return cmdlineclient.Snapshot{
+		Game2048State: util.Game2048State{
+			State:     g.game.GetState(),
+			Won:       g.game.IsGameWon(),
+			Over:      g.game.IsGameOver(),
+			Consensus: g.consensus,
+			Seq:       uint64(g.game.GetMoveCount()),
+		},
+		Server:    "local",
+		Connected: true,
+	}
+}
+
+// rawMode switches the terminal to raw mode with echo off, and returns a
+// function that puts it back the way it was.
+func rawMode() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() {
+		stty(strings.TrimSpace(saved))
+	}, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// readKeys decodes keypresses from stdin and sends them on keys. Anything
+// it does not recognize is dropped.
+func readKeys(keys chan<- key) {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			keys <- keyQuit
+			return
+		}
+		for in := buf[:n]; len(in) > 0; {
+			k, size := decodeKey(in)
+			in = in[size:]
+			if k != keyNone {
+				keys <- k
+			}
+		}
+	}
+}
+
+// decodeKey decodes the first keypress in in, returning it and the number of
+// bytes it took up.
+func decodeKey(in []byte) (key, int) {
+	if len(in) >= 3 && in[0] == 0x1b && in[1] == '[' {
+		switch in[2] {
+		case 'A':
+			return keyUp, 3
+		case 'B':
+			return keyDown, 3
+		case 'C':
+			return keyRight, 3
+		case 'D':
+			return keyLeft, 3
+		}
+		return keyNone, 3
+	}
+	switch in[0] {
+	case 'w', 'W', 'k':
+		return keyUp, 1
+	case 's', 'S', 'j':
+		return keyDown, 1
+	case 'a', 'A', 'h':
+		return keyLeft, 1
+	case 'd', 'D', 'l':
+		return keyRight, 1
+	case 't', 'T':
+		return keyHint, 1
+	case 'q', 'Q', 0x03:
+		return keyQuit, 1
+	}
+	return keyNone, 1
+}
+
+// draw shows the game that snapshot holds, with a status line, and ended, what
+// happens next if the game is over.
+func draw(snapshot cmdlineclient.Snapshot, status string, ended string) {
+	game, err := lib2048.NewGame2048FromState(snapshot.State)
+	if err != nil {
+		return
+	}
+	options := game.GetOptions()
+
+	var buf bytes.Buffer
+	buf.WriteString(clearScreen)
+	fmt.Fprintf(&buf, "%s2048%s  %s  seed %d\r\n\r\n", bold, reset, options.Variant, options.Seed)
+	fmt.Fprintf(&buf, "Score: %s%d%s   Moves: %d\r\n\r\n", bold, game.GetScore(), reset, game.GetMoveCount())
+
+	ranks := tileRanks(options)
+	grid := game.GetBoard()
+	for row := 0; row < lib2048.BoardLen; row++ {
+		for line := 0; line < 3; line++ {
+			for col := 0; col < lib2048.BoardLen; col++ {
+				value := grid[row][col]
+				text := ""
+				if line == 1 && value > 0 {
+					text = fmt.Sprint(value)
+				}
+				buf.WriteString(tile(value, ranks, text))
+			}
+			buf.WriteString(reset + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+
+	if snapshot.Won {
+		fmt.Fprintf(&buf, "%sYou win!%s %s\r\n", bold, reset, ended)
+	} else if snapshot.Over {
+		fmt.Fprintf(&buf, "%sGame over!%s %s\r\n", bold, reset, ended)
+	}
+	consensus := snapshot.Consensus
+	if consensus == "" {
+		consensus = "-"
+	}
+	fmt.Fprintf(&buf, "Consensus: %s\r\n", consensus)
+	if snapshot.Connected {
+		fmt.Fprintf(&buf, "Server:    %s\r\n", snapshot.Server)
+	} else {
+		fmt.Fprintf(&buf, "Server:    %sreconnecting...%s\r\n", bold, reset)
+	}
+	fmt.Fprintf(&buf, "\r\n%s\r\n", status)
+	fmt.Fprintf(&buf, "%sArrows/WASD: vote  T: hint  Q: quit%s\r\n", dim, reset)
+	os.Stdout.Write(buf.Bytes())
+}
+
+// tileRanks maps each tile value of the variant to its rank, so that tiles
+// are colored the same way whatever their actual values. The values are found
+// by sliding pairs of tiles together, so they follow the variant's own rules.
+func tileRanks(options lib2048.Options) map[int]int {
+	first, bonus, _ := lib2048.NewTileValues(options)
+	values := []int{first}
+	if next, ok := merge(options, first, first); !ok || next != bonus {
+		values = append(values, bonus) // not made by merging, as in x3
+	}
+	for len(values) < len(tileColors) {
+		last := values[len(values)-1]
+		next, ok := merge(options, last, last)
+		if !ok && len(values) > 1 {
+			next, ok = merge(options, values[len(values)-2], last)
+		}
+		if !ok {
+			break
+		}
+		values = append(values, next)
+	}
+
+	ranks := make(map[int]int)
+	for i, value := range values {
+		ranks[value] = i + 1
+	}
+	return ranks
+}
+
+// merge returns the tile that a and b merge into, if they merge at all.
+func merge(options lib2048.Options, a, b int) (int, bool) {
+	var grid lib2048.Grid
+	grid[0][0], grid[0][1] = a, b
+	grid, _, _ = lib2048.Slide(options, grid, lib2048.Left)
+	return grid[0][0], grid[0][1] == 0
+}
+
+// tile draws one line of a cell, centering text on its background color.
+func tile(value int, ranks map[int]int, text string) string {
+	color := tileColors[0]
+	fg := 16
+	if value == lib2048.Blocker {
+		color, fg = 240, 255
+		if text == "" {
+			text = strings.Repeat("#", cellWidth-2)
+		}
+	} else if value > 0 {
+		rank, ok := ranks[value]
+		if !ok || rank >= len(tileColors) {
+			rank = len(tileColors) - 1
+		}
+		color = tileColors[rank]
+		if rank >= 4 {
+			fg = 255
+		}
+	}
+	pad := cellWidth - len(text)
+	if pad < 0 {
+		pad = 0
+	}
+	left := pad / 2
+	return fmt.Sprintf("\x1b[48;5;%dm\x1b[38;5;%dm%s%s%s", color, fg,
+		strings.Repeat(" ", left), text, strings.Repeat(" ", pad-left))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"code.google.com/p/go.net/websocket"
+	"distributed2048/centralserver"
+	"distributed2048/lib2048"
+	"distributed2048/libreplay"
+	"distributed2048/util"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// runReplay loads a game recorded by a game server with -replayDir, checks
+// it against lib2048, and can stream it to the web client.
+func runReplay(args []string) {
+	fs, conf := newFlags("replay", "<file>")
+	verify := fs.Bool("verify", true, "check the recorded boards against the replayed game")
+	port := fs.Int("port", 0, "if set, stream the replay to websocket clients on this port")
+	speed := fs.Float64("speed", 1, "playback speed, relative to the recorded timings")
+	maxDelay := fs.Int("maxDelay", 2000, "longest pause between two moves during playback, in milliseconds")
+	fs.StringVar(&conf.GameServer.WebsocketPath, "pattern", conf.GameServer.WebsocketPath, "websocket path to stream the replay on")
+	parseFlags(fs, args, conf)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		fail("a replay file must be given")
+	}
+	if *speed <= 0 {
+		fail("speed must be positive")
+	}
+
+	replay, err := libreplay.Load(fs.Arg(0))
+	if err != nil {
+		fail(fmt.Sprint("Could not load replay: ", err))
+	}
+	fmt.Printf("Loaded replay with seed %d and %d moves\n", replay.Header.Game.Options.Seed, len(replay.Entries))
+
+	if *verify {
+		game, err := replay.Verify()
+		if err != nil {
+			fail(fmt.Sprint("FAIL: ", err))
+		}
+		fmt.Printf("Replay verified, final state:\n%s", game.String())
+	}
+
+	if *port == 0 {
+		return
+	}
+
+	// Answer like a central server, so that the web client can be pointed
+	// at this command to watch the replay.
+	hostport := fmt.Sprintf("localhost:%d", *port)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := json.Marshal(centralserver.HttpReply{"OK", hostport})
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write(buf)
+	})
+	maxPause := time.Duration(*maxDelay) * time.Millisecond
+	mux.Handle(conf.GameServer.WebsocketPath, websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		streamReplay(ws, replay, *speed, maxPause)
+	}))
+	fmt.Println("Streaming replay on port", *port)
+	fail(listenAndServe(conf, *port, mux))
+}
+
+// streamReplay sends the replay to a websocket client one move at a time,
+// pausing between moves according to the recorded timings and the playback
+// speed, for at most maxPause.
+func streamReplay(ws *websocket.Conn, replay *libreplay.Replay, speed float64, maxPause time.Duration) {
+	game, err := replay.NewGame()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// The replayed game is the only one played, so it starts at Seq 1
+	if sendReplayState(ws, game, "", 1) != nil {
+		return
+	}
+	last := replay.Header.Started
+	for i, entry := range replay.Entries {
+		delay := time.Duration(float64(entry.Time.Sub(last)) / speed)
+		if delay > maxPause {
+			delay = maxPause
+		}
+		time.Sleep(delay)
+		last = entry.Time
+
+		game.MakeMove(entry.Direction)
+		if sendReplayState(ws, game, entry.Direction.String(), uint64(i+2)) != nil {
+			return
+		}
+	}
+
+	// Keep the connection open until the viewer leaves, ignoring any moves
+	// they try to make.
+	var msg string
+	for websocket.Message.Receive(ws, &msg) == nil {
+	}
+}
+
+func sendReplayState(ws *websocket.Conn, game lib2048.Game2048, consensus string, seq uint64) error {
+	state := &util.Game2048State{
+		State:     game.GetState(),
+		Won:       game.IsGameWon(),
+		Over:      game.IsGameOver(),
+		Consensus: consensus,
+		Seq:       seq,
+	}
+	buf, _ := json.Marshal(state)
+	return websocket.Message.Send(ws, string(buf))
+}
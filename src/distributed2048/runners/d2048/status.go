@@ -0,0 +1,80 @@
+package main
+
+import (
+	"distributed2048/centralserver"
+	"distributed2048/libconfig"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const STATUS_TIMEOUT = 5 * time.Second // for the central server's reply, which waits for every game server
+
+// runStatus asks the central server how the cluster is doing, through its
+// admin API, and shows every game server it knows of.
+func runStatus(args []string) {
+	fs, conf := newFlags("status", "")
+	libconfig.HostPortVar(fs, &conf.Central.Host, &conf.Central.Port, "central", "host:port of central server")
+	asJSON := fs.Bool("json", false, "print the central server's reply as it is")
+	parseFlags(fs, args, conf)
+
+	req, err := http.NewRequest("GET", conf.CentralURL()+"/admin/api/cluster", nil)
+	if err != nil {
+		fail(err)
+	}
+	conf.Secret().Authorize(req)
+	resp, err := conf.TLSConfig().HTTPClient(STATUS_TIMEOUT).Do(req)
+	if err != nil {
+		fail(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fail(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fail(fmt.Sprintf("central server replied %s: %s", resp.Status, body))
+	}
+	if *asJSON {
+		os.Stdout.Write(body)
+		return
+	}
+	var info centralserver.ClusterInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		fail(err)
+	}
+
+	fmt.Printf("%d of %d game servers registered with %s\n\n", len(info.GameServers), info.NumGameServers, conf.Central.HostPort())
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tSTATE\tCLIENTS\tDECIDED\tQUEUED\tGAME\tMOVES\tSCORE")
+	var errs []string
+	for _, gs := range info.GameServers {
+		state := "up"
+		if gs.Draining {
+			state = "draining"
+		}
+		if !gs.Healthy {
+			fmt.Fprintf(w, "%d\t%s\tdown\t-\t-\t-\t-\t-\t-\n", gs.ID, gs.HostPort)
+			errs = append(errs, fmt.Sprintf("%d: %s", gs.ID, gs.Error))
+			continue
+		}
+		st := gs.Status
+		if st.ShuttingDown {
+			state = "shutting down"
+		}
+		moves, score := "-", "-"
+		if st.Game != nil {
+			moves, score = fmt.Sprint(st.Game.MoveCount), fmt.Sprint(st.Game.Score)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n", gs.ID, gs.HostPort, state, st.Clients, st.DecidedSlots, st.QueueDepth, st.GameNumber, moves, score)
+	}
+	w.Flush()
+	if len(errs) > 0 {
+		fmt.Printf("\nGame servers down:\n  %s\n", strings.Join(errs, "\n  "))
+	}
+}
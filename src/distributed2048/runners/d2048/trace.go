@@ -0,0 +1,125 @@
+package main
+
+import (
+	"distributed2048/libtrace"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// runTrace collects the spans that game servers and clients export with
+// -trace=http://<host:port>/v1/traces, standing in for a tracing collector,
+// and explains how the moves they recorded came about.
+//
+// explain reads spans written by collect, or by -trace=<file>, and shows, for
+// each move that a game server applied, every span it follows from: waiting
+// for enough votes, each Paxos round and the messages it sent, and the votes
+// from the clients, back to their keypresses.
+func runTrace(args []string) {
+	fs, conf := newFlags("trace", "collect | explain <file>...")
+	listen := fs.String("listen", "localhost:4318", "host:port to collect spans on")
+	out := fs.String("out", "spans.jsonl", "file that collected spans are appended to")
+	service := fs.String("service", "", "only explain the moves of this service, e.g. gameserver-0 (the first one found if empty)")
+	seq := fs.Uint64("seq", 0, "only explain the move that led to this state sequence number")
+	last := fs.Int("last", 5, "how many of the last moves to explain, if -seq is not given")
+	parseFlags(fs, args, conf)
+	usage := func() {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	switch fs.Arg(0) {
+	case "collect":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		collectSpans(*listen, *out)
+	case "explain":
+		if fs.NArg() < 2 {
+			usage()
+		}
+		explainMoves(fs.Args()[1:], *service, *seq, *last)
+	default:
+		usage()
+	}
+}
+
+// collectSpans serves the collector's endpoint on listen, appending the spans
+// it is sent to the file out.
+func collectSpans(listen, out string) {
+	f, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fail(err)
+	}
+	var mutex sync.Mutex
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var batch libtrace.Batch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		err := libtrace.WriteSpans(f, batch.Spans)
+		mutex.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+	fmt.Printf("Collecting spans on %s into %s\n", listen, out)
+	fail(http.ListenAndServe(listen, mux))
+}
+
+// explainMoves explains the moves of service, the first one found if empty,
+// in the spans of files: the one that led to seq, or the last ones if seq is
+// 0.
+func explainMoves(files []string, service string, seq uint64, last int) {
+	var spans []*libtrace.Span
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			fail(err)
+		}
+		s, err := libtrace.ReadSpans(f)
+		f.Close()
+		if err != nil {
+			fail(fmt.Sprintf("%s: %s", name, err))
+		}
+		spans = append(spans, s...)
+	}
+
+	var moves []*libtrace.Span
+	for _, s := range spans {
+		if s.Name != "gameserver.move" {
+			continue
+		}
+		if service == "" {
+			service = s.Service
+		}
+		if s.Service == service && (seq == 0 || s.Attrs["seq"] == fmt.Sprint(seq)) {
+			moves = append(moves, s)
+		}
+	}
+	if len(moves) == 0 {
+		fail("no moves were found")
+	}
+	sort.Slice(moves, func(i, j int) bool { return moves[i].StartTime.Before(moves[j].StartTime) })
+	if seq == 0 && len(moves) > last {
+		moves = moves[len(moves)-last:]
+	}
+	for _, m := range moves {
+		fmt.Printf("Move %s (seq %s) on %s:\n", m.Attrs["direction"], m.Attrs["seq"], m.Service)
+		if err := libtrace.Explain(os.Stdout, spans, m.SpanID); err != nil {
+			fail(err)
+		}
+		fmt.Println()
+	}
+}
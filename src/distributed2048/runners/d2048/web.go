@@ -0,0 +1,32 @@
+package main
+
+import (
+	"distributed2048/libconfig"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func runWeb(args []string) {
+	fs, conf := newFlags("web", "")
+	fs.IntVar(&conf.Web.Port, "port", conf.Web.Port, "port number to serve the web client on")
+	fs.StringVar(&conf.Web.Dir, "dir", conf.Web.Dir, "directory holding the web client")
+	parseFlags(fs, args, conf)
+
+	fmt.Printf("Serving the web client from %s on %s\n", conf.Web.Dir, conf.TLSConfig().URL(fmt.Sprintf("localhost:%d", conf.Web.Port)))
+	fail(listenAndServe(conf, conf.Web.Port, webHandler(conf)))
+}
+
+// webHandler serves the web client from conf's directory, along with
+// settings.js, which tells it the websocket path that game servers serve
+// clients on.
+func webHandler(conf *libconfig.Config) http.Handler {
+	path, _ := json.Marshal(conf.GameServer.WebsocketPath)
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(conf.Web.Dir)))
+	mux.HandleFunc("/settings.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		fmt.Fprintf(w, "var WEBSOCKET_PATH = %s;\n", path)
+	})
+	return mux
+}
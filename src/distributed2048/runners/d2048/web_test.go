@@ -0,0 +1,28 @@
+package main
+
+import (
+	"distributed2048/libconfig"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebSettings(t *testing.T) {
+	conf := libconfig.Default()
+	conf.GameServer.WebsocketPath = "/play"
+	s := httptest.NewServer(webHandler(conf))
+	defer s.Close()
+
+	resp, err := s.Client().Get(s.URL + "/settings.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "var WEBSOCKET_PATH = \"/play\";\n"; string(body) != want {
+		t.Errorf("Got settings.js %q, want %q", body, want)
+	}
+}
@@ -0,0 +1,146 @@
+package cluster
+
+import (
+	"distributed2048/centralserver"
+	"distributed2048/cmdlineclient"
+	"distributed2048/libauth"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// adminRequest calls the central server's admin API, giving it secret if it
+// is not nil.
+func adminRequest(t *testing.T, c *Cluster, method, action string, secret libauth.Secret) *http.Response {
+	req, err := http.NewRequest(method, c.CentralAddr()+"/admin/api/"+action, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Authorize(req)
+	resp, err := c.config.TLS.HTTPClient(0).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func clusterInfo(t *testing.T, c *Cluster) centralserver.ClusterInfo {
+	resp := adminRequest(t, c, "GET", "cluster", c.config.Secret)
+	defer resp.Body.Close()
+	var info centralserver.ClusterInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func adminAction(t *testing.T, c *Cluster, action string) {
+	resp := adminRequest(t, c, "POST", action, c.config.Secret)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("Admin action %s failed: %s", action, body)
+	}
+}
+
+// waitForNewGame waits until every client is at the start of a game with the
+// given seed.
+func waitForNewGame(t *testing.T, c *Cluster, seed uint32) {
+	for i, cli := range c.Clients() {
+		_, err := cli.WaitForState(func(s cmdlineclient.Snapshot) bool {
+			return s.Options.Seed == seed && s.MoveCount == 0
+		}, settleTimeout)
+		if err != nil {
+			t.Fatalf("Client %d did not start a game with seed %d: %s", i, seed, err)
+		}
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3})
+	makeMoves(t, c, rand.New(rand.NewSource(10)), 2)
+	checkConsistent(t, c)
+	moves := c.Clients()[0].GetSnapshot().MoveCount // fewer than 2 if a move changed nothing
+
+	info := clusterInfo(t, c)
+	if info.NumGameServers != 3 || len(info.GameServers) != 3 {
+		t.Fatalf("Expected 3 game servers, got %+v", info)
+	}
+	clients := 0
+	for _, gs := range info.GameServers {
+		if !gs.Healthy {
+			t.Fatalf("Game server %d is unhealthy: %s", gs.ID, gs.Error)
+		}
+		st := gs.Status
+		if st.ID != gs.ID || st.HostPort != gs.HostPort {
+			t.Errorf("Game server %d reported itself as %d at %s", gs.ID, st.ID, st.HostPort)
+		}
+		if st.GameNumber != 1 || st.Game == nil || st.Game.MoveCount != moves {
+			t.Errorf("Game server %d is at game %d with %+v", gs.ID, st.GameNumber, st.Game)
+		}
+		// The first game, and at least one vote for each move
+		if st.DecidedSlots < 3 {
+			t.Errorf("Game server %d has only decided %d slots", gs.ID, st.DecidedSlots)
+		}
+		clients += st.Clients
+	}
+	if clients != 3 {
+		t.Errorf("Expected 3 clients, got %d", clients)
+	}
+
+	c.Kill(1)
+	for _, gs := range clusterInfo(t, c).GameServers {
+		if healthy := gs.ID != c.GameServerID(1); gs.Healthy != healthy {
+			t.Errorf("Game server %d: got healthy %v, expected %v", gs.ID, gs.Healthy, healthy)
+		}
+	}
+}
+
+func TestAdminNewGame(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3})
+	r := rand.New(rand.NewSource(11))
+	makeMoves(t, c, r, 2)
+
+	adminAction(t, c, "seed?seed=42")
+	waitForNewGame(t, c, 42)
+	makeMoves(t, c, r, 2)
+
+	// Without a configured seed, a reset picks a random one, so look for any
+	// game after the second
+	adminAction(t, c, "reset")
+	for i, cli := range c.Clients() {
+		if _, err := cli.WaitForState(func(s cmdlineclient.Snapshot) bool { return s.Options.Seed != 42 }, settleTimeout); err != nil {
+			t.Fatalf("Client %d did not start a new game: %s", i, err)
+		}
+	}
+	for i := 0; i < c.NumGameServers(); i++ {
+		if n := c.GameServer(i).Status().GameNumber; n != 3 {
+			t.Errorf("Game server %d is at game %d, expected 3", i, n)
+		}
+	}
+	makeMoves(t, c, r, 2)
+	checkConsistent(t, c)
+}
+
+func TestAdminDrain(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3})
+	adminAction(t, c, "drain?id="+strconv.FormatUint(uint64(c.GameServerID(0)), 10))
+	if !c.GameServer(0).Status().Draining {
+		t.Fatal("Game server 0 is not draining")
+	}
+
+	for i := 0; i < 4; i++ {
+		cli, err := c.AddClient("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server := cli.GetSnapshot().Server; server == c.GameServerHostPort(0) {
+			t.Fatalf("Client %d was sent to the game server being drained", i)
+		}
+	}
+	makeMoves(t, c, rand.New(rand.NewSource(12)), 2)
+	checkConsistent(t, c)
+}
@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	"code.google.com/p/go.net/websocket"
+	"distributed2048/cmdlineclient"
+	"distributed2048/lib2048"
+	"distributed2048/util"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// askForHint asks for a hint on ws and returns it, skipping the boards that
+// the game server sends in the meantime.
+func askForHint(t *testing.T, ws *websocket.Conn) util.HintMessage {
+	if err := websocket.JSON.Send(ws, util.ClientMove{0, true, nil}); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(settleTimeout))
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			t.Fatalf("Got no hint: %s", err)
+		}
+		var hint util.HintMessage
+		if strings.Contains(msg, `"Hint"`) && json.Unmarshal([]byte(msg), &hint) == nil {
+			return hint
+		}
+	}
+}
+
+func TestHints(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1})
+	hostport := c.GameServerHostPort(0)
+	first := dialVoter(t, hostport, "first")
+	second := dialVoter(t, hostport, "second")
+
+	hints := []util.HintMessage{askForHint(t, first), askForHint(t, second)}
+	for i := 0; i < 10; i++ {
+		hints = append(hints, askForHint(t, second))
+	}
+	for _, hint := range hints[1:] {
+		if hint != hints[0] {
+			t.Errorf("Got different hints for the same board: %v", hints)
+			break
+		}
+	}
+	if n := metricSum(t, scrape(t, hostport), "gameserver_hints_computed_total"); n != 1 {
+		t.Errorf("Worked out %v hints for one board", n)
+	}
+}
+
+// stuckAdvisor does not suggest a move until released.
+type stuckAdvisor struct {
+	searching chan struct{} // closed once it is first asked
+	once      sync.Once
+	release   chan struct{}
+}
+
+func (a *stuckAdvisor) SuggestMove(game lib2048.Game2048) (lib2048.Direction, bool) {
+	a.once.Do(func() { close(a.searching) })
+	<-a.release
+	return lib2048.Up, true
+}
+
+func TestBotKeepsReceiving(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1, NumClients: 2})
+	bot, other := c.Clients()[0], c.Clients()[1]
+	advisor := &stuckAdvisor{searching: make(chan struct{}), release: make(chan struct{})}
+	defer close(advisor.release)
+	bot.SetBot(advisor)
+	select {
+	case <-advisor.searching:
+	case <-time.After(settleTimeout):
+		t.Fatal("The bot was never asked for a move")
+	}
+
+	seq := other.GetSnapshot().Seq
+	if err := MakeMove([]cmdlineclient.Cclient{other}, lib2048.Left, moveTimeout); err != nil {
+		t.Fatal(err)
+	}
+	// Waiting takes the client's mutex, so it hangs if the search holds it
+	received := make(chan error, 1)
+	go func() {
+		_, err := bot.WaitForSeq(seq+1, settleTimeout)
+		received <- err
+	}()
+	select {
+	case err := <-received:
+		if err != nil {
+			t.Error("The bot stopped receiving states while searching:", err)
+		}
+	case <-time.After(settleTimeout + time.Second):
+		t.Error("The bot's search blocked the client")
+	}
+}
@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"distributed2048/centralserver"
+	"distributed2048/gameserver"
+	"distributed2048/lib2048"
+	"distributed2048/libauth"
+	"distributed2048/libtrace"
+	"distributed2048/rpc/adminrpc"
+	"distributed2048/rpc/centralrpc"
+	"distributed2048/rpc/faultrpc"
+	"distributed2048/rpc/paxosrpc"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/rpc"
+	"testing"
+)
+
+var secret = libauth.Secret("the cluster's secret")
+
+func TestAuth(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3, Secret: secret})
+	r := rand.New(rand.NewSource(49))
+	makeMoves(t, c, r, 3)
+	checkConsistent(t, c)
+}
+
+// Registering, or sending Paxos messages, takes args signed with the
+// cluster's secret.
+func TestAuthRejectsUnknownPeers(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1, Secret: secret})
+	rejected := func(err error) bool {
+		return err != nil && err.Error() == libauth.ErrUnauthenticated.Error()
+	}
+
+	central, err := rpc.DialHTTP("tcp", c.central.HostPort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer central.Close()
+	var reply centralrpc.RegisterGameServerReply
+	if err := central.Call("CentralServer.RegisterGameServer", &centralrpc.RegisterGameServerArgs{"localhost:1", nil}, &reply); !rejected(err) {
+		t.Error("Registering without a MAC got", err)
+	}
+
+	args := &centralrpc.RegisterGameServerArgs{"localhost:1", nil}
+	if err := libauth.Secret("another secret, as long").Sign("CentralServer.RegisterGameServer", args); err != nil {
+		t.Fatal(err)
+	}
+	if err := central.Call("CentralServer.RegisterGameServer", args, &reply); !rejected(err) {
+		t.Error("Registering with another secret got", err)
+	}
+	if err := secret.Sign("CentralServer.RegisterGameServer", args); err != nil {
+		t.Fatal(err)
+	}
+	args.HostPort = "localhost:2"
+	if err := central.Call("CentralServer.RegisterGameServer", args, &reply); !rejected(err) {
+		t.Error("Registering with tampered args got", err)
+	}
+	drain := &centralrpc.DrainGameServerArgs{c.GameServerHostPort(0), nil}
+	if err := secret.Sign("CentralServer.RegisterGameServer", drain); err != nil {
+		t.Fatal(err)
+	}
+	if err := central.Call("CentralServer.DrainGameServer", drain, &centralrpc.DrainGameServerReply{}); !rejected(err) {
+		t.Error("Draining with args signed for another method got", err)
+	}
+	info := clusterInfo(t, c)
+	if len(info.GameServers) != 1 || info.GameServers[0].Draining {
+		t.Errorf("Game servers are %+v, expected the one that started, not draining", info.GameServers)
+	}
+
+	gs, err := rpc.DialHTTP("tcp", c.GameServerHostPort(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gs.Close()
+	value := paxosrpc.ProposalValue{Moves: []lib2048.Move{*lib2048.NewMove(lib2048.Left)}}
+	decide := &paxosrpc.ReceiveDecideArgs{*paxosrpc.NewProposal(1000, 1000, 1000, value), libtrace.SpanContext{}, nil}
+	if err := gs.Call("PaxosNode.ReceiveDecide", decide, &paxosrpc.ReceiveDecideReply{}); !rejected(err) {
+		t.Error("Deciding without a MAC got", err)
+	}
+}
+
+// The admin API takes the secret, and the central server signs its calls to
+// the game servers' admin service, which turns away calls that are not
+// signed, as the fault injector does.
+func TestAuthAdmin(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 2, NumClients: 1, Secret: secret})
+	for _, gs := range clusterInfo(t, c).GameServers {
+		if !gs.Healthy {
+			t.Errorf("Game server %d is not healthy: %s", gs.ID, gs.Error)
+		}
+	}
+	adminAction(t, c, "seed?seed=49")
+	waitForNewGame(t, c, 49)
+
+	// The admin API itself takes the secret
+	for _, s := range []libauth.Secret{nil, libauth.Secret("another secret, as long")} {
+		resp := adminRequest(t, c, "POST", "seed?seed=50", s)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Admin API replied %s to a POST without the secret", resp.Status)
+		}
+		resp = adminRequest(t, c, "GET", "cluster", s)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Admin API replied %s to a GET without the secret", resp.Status)
+		}
+	}
+	if n := c.GameServer(0).Status().GameNumber; n != 2 {
+		t.Errorf("Game server is at game %d, expected 2", n)
+	}
+
+	if err := c.GameServer(0).ServeFaultAdmin(); err != nil {
+		t.Fatal(err)
+	}
+	gs, err := rpc.DialHTTP("tcp", c.GameServerHostPort(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gs.Close()
+	rejected := func(method string, args, reply interface{}) {
+		if err := gs.Call(method, args, reply); err == nil || err.Error() != libauth.ErrUnauthenticated.Error() {
+			t.Errorf("Unsigned call to %s got %v", method, err)
+		}
+	}
+	rejected("GameServerAdmin.GetStatus", &adminrpc.GetStatusArgs{nil}, &adminrpc.GetStatusReply{})
+	rejected("GameServerAdmin.Drain", &adminrpc.DrainArgs{true, nil}, &adminrpc.DrainReply{})
+	rejected("GameServerAdmin.NewGame", &adminrpc.NewGameArgs{1, nil}, &adminrpc.NewGameReply{})
+	rejected("FaultInjector.Partition", &faultrpc.PartitionArgs{[]uint32{c.GameServerID(1)}, nil}, &faultrpc.PartitionReply{})
+	rejected("FaultInjector.ClearFaults", &faultrpc.ClearFaultsArgs{nil}, &faultrpc.ClearFaultsReply{})
+
+	args := &faultrpc.ListFaultsArgs{nil}
+	if err := secret.Sign("FaultInjector.ListFaults", args); err != nil {
+		t.Fatal(err)
+	}
+	if err := gs.Call("FaultInjector.ListFaults", args, &faultrpc.ListFaultsReply{}); err != nil {
+		t.Error("Signed call to the fault injector failed:", err)
+	}
+	for _, info := range clusterInfo(t, c).GameServers {
+		if info.Draining {
+			t.Errorf("Game server %d is draining", info.ID)
+		}
+	}
+}
+
+// Log levels can only be changed with the secret, on the central server as
+// on the game servers.
+func TestAuthLogLevels(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1, Secret: secret})
+	for _, hostport := range []string{c.central.HostPort(), c.GameServerHostPort(0)} {
+		for _, s := range []libauth.Secret{nil, secret} {
+			req, err := http.NewRequest("POST", "http://"+hostport+"/debug/log?level=info", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Authorize(req)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			want := http.StatusUnauthorized
+			if s.Enabled() {
+				want = http.StatusOK
+			}
+			if resp.StatusCode != want {
+				t.Errorf("%s/debug/log replied %s with the secret given: %t", hostport, resp.Status, s.Enabled())
+			}
+		}
+	}
+}
+
+// A game server given another secret cannot join a central server that has
+// room for it.
+func TestAuthWrongSecret(t *testing.T) {
+	l, err := net.Listen("tcp", hostname+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	central, err := centralserver.NewCentralServerWithListener(l, 1, nil, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer central.Close()
+
+	l, err = net.Listen("tcp", hostname+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, err := gameserver.NewGameServerWithListener(l, central.HostPort(), hostname, pattern, "", lib2048.Options{}, nil, libauth.Secret("another secret, as long"))
+	if err == nil {
+		gs.Close()
+		t.Fatal("Registered with another secret")
+	}
+}
@@ -0,0 +1,397 @@
+// Package cluster runs a whole deployment (a central server, game servers
+// and command line clients) inside one process, on ephemeral ports, so that
+// end-to-end tests can be written as ordinary go tests. Game servers can be
+// killed and restarted, or cut off from each other, to test how the rest of
+// the cluster copes.
+package cluster
+
+import (
+	"context"
+	"distributed2048/centralserver"
+	"distributed2048/cmdlineclient"
+	"distributed2048/gameserver"
+	"distributed2048/lib2048"
+	"distributed2048/libauth"
+	"distributed2048/libhistory"
+	"distributed2048/libpaxos"
+	"distributed2048/libtls"
+	"distributed2048/util"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	hostname = "localhost"
+	pattern  = util.WSPATTERN
+)
+
+type Config struct {
+	NumGameServers int
+	NumClients     int             // clients connected through the central server at the start
+	GameOptions    lib2048.Options // options for every new game
+	ClientInterval int             // milliseconds between client ticks, util.DEFAULTINTERVAL if 0
+	ReplayDir      string          // if set, every game server records replays into it
+	TLS            *libtls.Config  // given to every node and client, nil for plain connections
+	Secret         libauth.Secret  // given to every node, nil to leave authentication off
+}
+
+type Cluster struct {
+	config  Config
+	central centralserver.CentralServer
+	ctx     context.Context // cancelled when the cluster is closed, which stops every client
+	cancel  context.CancelFunc
+
+	mutex     sync.Mutex
+	servers   []gameserver.GameServer // nil while a game server is killed
+	hostports []string
+	ids       []uint32 // Paxos node IDs, which stay the same on restart
+	clients   []cmdlineclient.Cclient
+	histories [][]libhistory.Entry // histories of the game servers killed so far
+}
+
+// New starts a cluster and waits until all its game servers have joined the
+// ring and all its clients have received the first game.
+func New(config Config) (*Cluster, error) {
+	if config.NumGameServers < 1 {
+		return nil, errors.New("a cluster needs at least one game server")
+	}
+	if config.ClientInterval == 0 {
+		config.ClientInterval = util.DEFAULTINTERVAL
+	}
+
+	l, err := net.Listen("tcp", hostname+":0")
+	if err != nil {
+		return nil, err
+	}
+	central, err := centralserver.NewCentralServerWithListener(l, config.NumGameServers, config.TLS, config.Secret)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Cluster{
+		config:    config,
+		central:   central,
+		ctx:       ctx,
+		cancel:    cancel,
+		servers:   make([]gameserver.GameServer, config.NumGameServers),
+		hostports: make([]string, config.NumGameServers),
+		ids:       make([]uint32, config.NumGameServers),
+	}
+
+	// Game servers only finish starting once all of them have registered,
+	// so start them all at once
+	errs := make(chan error, config.NumGameServers)
+	for i := range c.servers {
+		go func(i int) {
+			errs <- c.startGameServer(i, hostname+":0")
+		}(i)
+	}
+	for range c.servers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	for i := 0; i < config.NumClients; i++ {
+		if _, err := c.AddClient(""); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// startGameServer starts game server i, listening on addr.
+func (c *Cluster) startGameServer(i int, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	gs, err := gameserver.NewGameServerWithListener(l, c.central.HostPort(), hostname, pattern, c.config.ReplayDir, c.config.GameOptions, c.config.TLS, c.config.Secret)
+	if err != nil {
+		l.Close()
+		return fmt.Errorf("could not start game server %d: %s", i, err)
+	}
+	c.mutex.Lock()
+	c.servers[i] = gs
+	c.hostports[i] = gs.HostPort()
+	c.ids[i] = gs.ID()
+	c.mutex.Unlock()
+	return nil
+}
+
+// CentralAddr returns the URL that clients ask for a game server.
+func (c *Cluster) CentralAddr() string {
+	return c.config.TLS.URL(c.central.HostPort())
+}
+
+func (c *Cluster) NumGameServers() int {
+	return len(c.servers)
+}
+
+// GameServer returns game server i, or nil if it has been killed.
+func (c *Cluster) GameServer(i int) gameserver.GameServer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.servers[i]
+}
+
+// GameServerHostPort returns the address of game server i, which stays the
+// same when it is restarted.
+func (c *Cluster) GameServerHostPort(i int) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hostports[i]
+}
+
+// GameServerID returns the ID that the central server gave game server i.
+func (c *Cluster) GameServerID(i int) uint32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ids[i]
+}
+
+// AddClient connects a new client to the given game server, or to the one
+// the central server assigns if gameServHostPort is empty. The client records
+// the states it receives for CheckHistory, and is closed with the cluster.
+func (c *Cluster) AddClient(gameServHostPort string) (cmdlineclient.Cclient, error) {
+	cli, err := cmdlineclient.NewCClient(c.ctx, c.CentralAddr(), gameServHostPort, c.config.ClientInterval, c.config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	cli.RecordStates()
+	c.mutex.Lock()
+	c.clients = append(c.clients, cli)
+	c.mutex.Unlock()
+	return cli, nil
+}
+
+// Clients returns every client added to the cluster so far.
+func (c *Cluster) Clients() []cmdlineclient.Cclient {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]cmdlineclient.Cclient(nil), c.clients...)
+}
+
+// Kill stops game server i as if it had crashed. Its clients reconnect to
+// another game server through the central server.
+func (c *Cluster) Kill(i int) error {
+	c.mutex.Lock()
+	gs := c.servers[i]
+	c.servers[i] = nil
+	c.mutex.Unlock()
+	if gs == nil {
+		return fmt.Errorf("game server %d is not running", i)
+	}
+	var err error
+	select {
+	case <-gs.Drained():
+		// Already closed by a graceful shutdown
+	default:
+		err = gs.Close()
+	}
+	c.mutex.Lock()
+	c.histories = append(c.histories, gs.History())
+	c.mutex.Unlock()
+	return err
+}
+
+// Shutdown stops game server i gracefully, as SIGTERM does, giving it up to
+// timeout to get the votes it received decided. Its clients are told to
+// reconnect to another game server.
+func (c *Cluster) Shutdown(i int, timeout time.Duration) error {
+	gs := c.GameServer(i)
+	if gs == nil {
+		return fmt.Errorf("game server %d is not running", i)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := gs.Shutdown(ctx)
+	if kerr := c.Kill(i); err == nil {
+		err = kerr
+	}
+	return err
+}
+
+// Restart starts killed game server i again, on the same address. It starts
+// with no state, and catches up on the games played so far through Paxos.
+func (c *Cluster) Restart(i int) error {
+	c.mutex.Lock()
+	running := c.servers[i] != nil
+	hostport := c.hostports[i]
+	c.mutex.Unlock()
+	if running {
+		return fmt.Errorf("game server %d is already running", i)
+	}
+	return c.startGameServer(i, hostport)
+}
+
+// Partition splits the game servers, given by index, into groups: the Paxos
+// messages between game servers in different groups are lost. Game servers
+// in no group can still reach everybody. Clients stay connected to their game
+// servers.
+func (c *Cluster) Partition(groups ...[]int) error {
+	for g, group := range groups {
+		var others []uint32
+		for h, other := range groups {
+			if h == g {
+				continue
+			}
+			for _, j := range other {
+				others = append(others, c.GameServerID(j))
+			}
+		}
+		for _, i := range group {
+			gs := c.GameServer(i)
+			if gs == nil {
+				return fmt.Errorf("game server %d is not running", i)
+			}
+			gs.GetLibpaxos().Faults().Partition(others)
+		}
+	}
+	return nil
+}
+
+// CutLink loses the Paxos messages that game server from sends to game
+// server to, while the messages that to sends to from, and their replies,
+// still get through.
+func (c *Cluster) CutLink(from, to int) error {
+	gs := c.GameServer(from)
+	if gs == nil {
+		return fmt.Errorf("game server %d is not running", from)
+	}
+	gs.GetLibpaxos().Faults().AddRule(libpaxos.FaultRule{
+		Kind:      libpaxos.FaultDrop,
+		Direction: libpaxos.FaultOutgoing,
+		Peers:     []uint32{c.GameServerID(to)},
+	})
+	return nil
+}
+
+// Heal removes every fault injected into the running game servers, which
+// undoes Partition and CutLink.
+func (c *Cluster) Heal() {
+	for i := range c.servers {
+		if gs := c.GameServer(i); gs != nil {
+			gs.GetLibpaxos().Faults().ClearRules()
+		}
+	}
+}
+
+// Close stops every client, game server and the central server.
+func (c *Cluster) Close() error {
+	c.cancel()
+	var err error
+	for _, cli := range c.Clients() {
+		if e := cli.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	for i := range c.servers {
+		if gs := c.GameServer(i); gs != nil {
+			if e := c.Kill(i); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	if e := c.central.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// CheckHistory checks that every game server, including those killed so far,
+// did the same with each decided value, and that no client ever received a
+// state that the game servers did not go through. It returns a
+// *libhistory.Divergence describing the first disagreement, if any. In it,
+// game servers are numbered as they are in the cluster, followed by the
+// killed game servers in the order they were killed.
+func (c *Cluster) CheckHistory() error {
+	// Game servers record each state before sending it, so collecting the
+	// clients' states first means the histories have all of them
+	var states [][]util.Game2048State
+	for _, cli := range c.Clients() {
+		states = append(states, cli.RecordedStates())
+	}
+
+	var histories [][]libhistory.Entry
+	for i := range c.servers {
+		if gs := c.GameServer(i); gs != nil {
+			histories = append(histories, gs.History())
+		} else {
+			histories = append(histories, nil)
+		}
+	}
+	c.mutex.Lock()
+	histories = append(histories, c.histories...)
+	c.mutex.Unlock()
+	return libhistory.Check(histories, states)
+}
+
+// CheckConsistent waits for every client to catch up with the one that has
+// seen the most state changes, and checks that they all ended up with the
+// same game. Each client gets up to timeout to catch up.
+func CheckConsistent(clients []cmdlineclient.Cclient, timeout time.Duration) error {
+	for {
+		var target uint64
+		for _, cli := range clients {
+			if seq := cli.GetSnapshot().Seq; seq > target {
+				target = seq
+			}
+		}
+		snapshots := make([]cmdlineclient.Snapshot, len(clients))
+		for i, cli := range clients {
+			var err error
+			if snapshots[i], err = cli.WaitForSeq(target, timeout); err != nil {
+				return fmt.Errorf("game client %d did not catch up: %s", i, err)
+			}
+		}
+
+		settled := true
+		for _, s := range snapshots {
+			if s.Seq != target {
+				settled = false
+			}
+		}
+		if !settled {
+			// Moves were still being applied, so try again
+			continue
+		}
+		for i, s := range snapshots {
+			if s.State != snapshots[0].State {
+				return fmt.Errorf("game client %d differs in state from game client 0\nClient %d's state:\n%s\nClient 0's state:\n%s",
+					i, i, s.String(), snapshots[0].String())
+			}
+		}
+		return nil
+	}
+}
+
+// MakeMove has every client vote for dir until the cluster applies a move,
+// or timeout passes. Not every round of votes makes a move, since game
+// servers estimate how many votes to wait for from the votes they get.
+func MakeMove(clients []cmdlineclient.Cclient, dir lib2048.Direction, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	seq := clients[0].GetSnapshot().Seq
+	for time.Now().Before(deadline) {
+		for _, cli := range clients {
+			cli.InputMove(dir)
+		}
+		wait := time.Until(deadline)
+		if wait > time.Second {
+			wait = time.Second
+		}
+		if _, err := clients[0].WaitForSeq(seq+1, wait); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no move was applied within %s", timeout)
+}
@@ -0,0 +1,186 @@
+package cluster
+
+import (
+	"distributed2048/cmdlineclient"
+	"distributed2048/lib2048"
+	"distributed2048/libpaxos"
+	"distributed2048/util"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	moveTimeout   = 15 * time.Second
+	settleTimeout = 15 * time.Second
+)
+
+func newCluster(t *testing.T, config Config) *Cluster {
+	if config.ClientInterval == 0 {
+		config.ClientInterval = 50
+	}
+	c, err := New(config)
+	if err != nil {
+		t.Fatal("Could not start cluster:", err)
+	}
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Error("Could not close cluster:", err)
+		}
+	})
+	return c
+}
+
+// makeMoves has every client vote for n random directions, one move at a
+// time.
+func makeMoves(t *testing.T, c *Cluster, r *rand.Rand, n int) {
+	for i := 0; i < n; i++ {
+		dir := lib2048.Up + lib2048.Direction(r.Intn(4))
+		if err := MakeMove(c.Clients(), dir, moveTimeout); err != nil {
+			t.Fatalf("Move %d (%s): %s", i, dir, err)
+		}
+	}
+}
+
+func checkConsistent(t *testing.T, c *Cluster) {
+	if err := CheckConsistent(c.Clients(), settleTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckHistory(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOneServerOneClient(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1, NumClients: 1})
+	cli := c.Clients()[0]
+
+	moves := []*lib2048.Move{
+		lib2048.NewMove(lib2048.Left),
+		lib2048.NewMove(lib2048.Right),
+		lib2048.NewMove(lib2048.Left),
+		lib2048.NewMove(lib2048.Up),
+		lib2048.NewMove(lib2048.Up),
+		lib2048.NewMove(lib2048.Down),
+		lib2048.NewMove(lib2048.Right),
+		lib2048.NewMove(lib2048.Left),
+	}
+	want := util.CalculateGameState(cli.GetGameState(), moves)
+	for _, m := range moves {
+		if err := MakeMove(c.Clients(), m.Direction, moveTimeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := cli.GetGameState(); !got.Equals(want) {
+		t.Fatalf("Got state:\n%s\nwant:\n%s", got, want)
+	}
+	if err := c.CheckHistory(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestNoOpMove checks that a move that leaves the board as it was still
+// moves the state on, without counting as a move.
+func TestNoOpMove(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1, NumClients: 1})
+	cli := c.Clients()[0]
+
+	// Pile the tiles up until some direction no longer changes the board
+	var noop lib2048.Direction
+	for found := false; !found; {
+		for dir := lib2048.Up; dir <= lib2048.Right; dir++ {
+			if _, _, moved := cli.GetGameState().Slide(dir); !moved {
+				noop, found = dir, true
+				break
+			}
+		}
+		if !found {
+			if err := MakeMove(c.Clients(), lib2048.Up, moveTimeout); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	before := cli.GetSnapshot()
+	if err := MakeMove(c.Clients(), noop, moveTimeout); err != nil {
+		t.Fatal(err)
+	}
+	after, err := cli.WaitForSeq(before.Seq+1, settleTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.MoveCount != before.MoveCount || after.Grid != before.Grid {
+		t.Errorf("Moving %s changed the game from %v to %v", noop, before.State, after.State)
+	}
+	if _, err := cli.WaitForMoveCount(before.MoveCount+1, time.Second); err == nil {
+		t.Error("A move that changed nothing was counted")
+	}
+}
+
+func TestManyServersManyClients(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 6})
+	makeMoves(t, c, rand.New(rand.NewSource(1)), 10)
+	checkConsistent(t, c)
+}
+
+func TestKillGameServer(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3})
+	r := rand.New(rand.NewSource(2))
+	makeMoves(t, c, r, 3)
+
+	killed := c.GameServerHostPort(0)
+	if err := c.Kill(0); err != nil {
+		t.Fatal(err)
+	}
+	// Every client should end up on one of the game servers left
+	for i, cli := range c.Clients() {
+		_, err := cli.WaitForState(func(s cmdlineclient.Snapshot) bool {
+			return s.Connected && s.Server != killed
+		}, settleTimeout)
+		if err != nil {
+			t.Fatalf("Client %d did not reconnect: %s", i, err)
+		}
+	}
+
+	makeMoves(t, c, r, 5)
+	checkConsistent(t, c)
+}
+
+func TestRestartGameServer(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3})
+	r := rand.New(rand.NewSource(3))
+	makeMoves(t, c, r, 3)
+
+	if err := c.Kill(2); err != nil {
+		t.Fatal(err)
+	}
+	makeMoves(t, c, r, 3)
+	if err := c.Restart(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A client of the restarted server only sees the same game as everybody
+	// else once the server has caught up on the moves it missed
+	if _, err := c.AddClient(c.GameServerHostPort(2)); err != nil {
+		t.Fatal(err)
+	}
+	makeMoves(t, c, r, 3)
+	checkConsistent(t, c)
+}
+
+func TestLaggingGameServer(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3})
+	r := rand.New(rand.NewSource(4))
+
+	// Lag every fourth Paxos message that game server 1 receives, for long
+	// enough that the RPC times out
+	var received int32
+	c.GameServer(1).GetLibpaxos().SetInterruptFunc(func(id uint32, action libpaxos.PaxosAction, slotNumber uint32) {
+		if atomic.AddInt32(&received, 1)%4 == 0 {
+			time.Sleep(2 * libpaxos.RPCTimeout)
+		}
+	})
+	makeMoves(t, c, r, 6)
+	checkConsistent(t, c)
+}
@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"distributed2048/cmdlineclient"
+	"distributed2048/lib2048"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitMoved waits until every client is connected to a game server other
+// than the one at hostport.
+func waitMoved(t *testing.T, c *Cluster, hostport string) {
+	for i, cli := range c.Clients() {
+		_, err := cli.WaitForState(func(s cmdlineclient.Snapshot) bool {
+			return s.Connected && s.Server != hostport
+		}, settleTimeout)
+		if err != nil {
+			t.Fatalf("Client %d did not reconnect: %s", i, err)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 6})
+	r := rand.New(rand.NewSource(20))
+	makeMoves(t, c, r, 2)
+
+	// Shut down while votes are coming in, so that some are still buffered
+	for _, cli := range c.Clients() {
+		cli.InputMove(lib2048.Left)
+	}
+	stopped := c.GameServerHostPort(0)
+	id := c.GameServerID(0)
+	if err := c.Shutdown(0, settleTimeout); err != nil {
+		t.Fatal("Could not shut down gracefully:", err)
+	}
+	waitMoved(t, c, stopped)
+
+	for _, gs := range clusterInfo(t, c).GameServers {
+		if gs.ID == id && !gs.Draining {
+			t.Error("The central server still sends clients to the game server shut down")
+		}
+	}
+	for i := 0; i < 3; i++ {
+		cli, err := c.AddClient("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server := cli.GetSnapshot().Server; server == stopped {
+			t.Fatalf("Client %d was sent to the game server shut down", i)
+		}
+	}
+	makeMoves(t, c, r, 3)
+	checkConsistent(t, c)
+}
+
+func TestAdminShutdown(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3})
+	r := rand.New(rand.NewSource(21))
+	makeMoves(t, c, r, 2)
+
+	stopped := c.GameServerHostPort(1)
+	gs := c.GameServer(1)
+	adminAction(t, c, "drain?shutdown=true&id="+strconv.FormatUint(uint64(c.GameServerID(1)), 10))
+	select {
+	case <-gs.Drained():
+	case <-time.After(settleTimeout):
+		t.Fatal("Game server 1 did not shut down")
+	}
+	if err := c.Kill(1); err != nil {
+		t.Fatal(err)
+	}
+	waitMoved(t, c, stopped)
+
+	makeMoves(t, c, r, 3)
+	checkConsistent(t, c)
+}
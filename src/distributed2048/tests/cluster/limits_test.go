@@ -0,0 +1,292 @@
+package cluster
+
+import (
+	"code.google.com/p/go.net/websocket"
+	"distributed2048/gameserver"
+	"distributed2048/lib2048"
+	"distributed2048/util"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricSum returns the sum of the samples in metrics whose lines start with
+// prefix.
+func metricSum(t *testing.T, metrics, prefix string) float64 {
+	sum := 0.0
+	for _, line := range strings.Split(metrics, "\n") {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[strings.LastIndex(line, " ")+1:], 64)
+		if err != nil {
+			t.Fatalf("Could not parse %q: %s", line, err)
+		}
+		sum += value
+	}
+	return sum
+}
+
+// dialVoter connects to the game server at hostport as the given session,
+// bypassing the command line client to vote as fast as it likes.
+func dialVoter(t *testing.T, hostport, session string) *websocket.Conn {
+	ws, err := websocket.Dial("ws://"+hostport+util.WSPATTERN+"?session="+session, "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// flood sends n votes over ws as fast as it can, stopping if the game server
+// hangs up.
+func flood(ws *websocket.Conn, n int) {
+	for i := 0; i < n; i++ {
+		if err := websocket.JSON.Send(ws, util.ClientMove{util.DirectionToClient(lib2048.Up), false, nil}); err != nil {
+			return
+		}
+	}
+}
+
+// hungUp returns whether the game server closes ws within timeout, reading
+// whatever it sends until then.
+func hungUp(ws *websocket.Conn, timeout time.Duration) bool {
+	ws.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		var s string
+		if err := websocket.Message.Receive(ws, &s); err != nil {
+			return !strings.Contains(err.Error(), "timeout")
+		}
+	}
+}
+
+// useVoteLimits sets the vote limits of the game servers started after it,
+// until the test ends.
+func useVoteLimits(t *testing.T, perClient, perIP int, penalty gameserver.Penalty) {
+	oldClient, oldIP, oldPenalty := gameserver.VotesPerClient, gameserver.VotesPerIP, gameserver.LimitPenalty
+	gameserver.VotesPerClient, gameserver.VotesPerIP, gameserver.LimitPenalty = perClient, perIP, penalty
+	t.Cleanup(func() {
+		gameserver.VotesPerClient, gameserver.VotesPerIP, gameserver.LimitPenalty = oldClient, oldIP, oldPenalty
+	})
+}
+
+// waitForVotes scrapes the game server at hostport until n votes have been
+// counted or rejected, and returns its metrics.
+func waitForVotes(t *testing.T, hostport string, n float64) string {
+	var metrics string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		metrics = scrape(t, hostport)
+		if metricSum(t, metrics, "gameserver_votes_total")+metricSum(t, metrics, "gameserver_votes_rejected_total") >= n {
+			break
+		}
+	}
+	return metrics
+}
+
+// A client that floods votes only gets one counted in each round.
+func TestVoteLimit(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1, NumClients: 1})
+	hostport := c.GameServerHostPort(0)
+	const n = 50
+	flood(dialVoter(t, hostport, "flooder"), n)
+
+	var metrics string
+	var accepted, rejected float64
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		metrics = scrape(t, hostport)
+		accepted = metricSum(t, metrics, "gameserver_votes_total")
+		rejected = metricSum(t, metrics, `gameserver_votes_rejected_total{reason="client_limit"}`)
+		if accepted+rejected >= n {
+			break
+		}
+	}
+	if accepted+rejected != n {
+		t.Fatalf("%g votes were counted and %g rejected, expected %d in all:\n%s", accepted, rejected, n, metrics)
+	}
+	if accepted > n/5 {
+		t.Errorf("%g of the %d votes flooded were counted", accepted, n)
+	}
+
+	// The limit is per session, so the cluster's own client still votes
+	makeMoves(t, c, rand.New(rand.NewSource(50)), 2)
+	checkConsistent(t, c)
+}
+
+func TestVoteLimitPerIP(t *testing.T) {
+	useVoteLimits(t, 0, 2, gameserver.PenaltyNone)
+	c := newCluster(t, Config{NumGameServers: 1})
+	hostport := c.GameServerHostPort(0)
+	for _, session := range []string{"a", "b", "c"} {
+		flood(dialVoter(t, hostport, session), 10)
+	}
+
+	metrics := waitForVotes(t, hostport, 30)
+	if metricSum(t, metrics, `gameserver_votes_rejected_total{reason="ip_limit"}`) == 0 {
+		t.Errorf("No votes were rejected for the IP address's limit:\n%s", metrics)
+	}
+	if metricSum(t, metrics, `gameserver_votes_rejected_total{reason="client_limit"}`) != 0 {
+		t.Errorf("Votes were rejected for a client limit that is off:\n%s", metrics)
+	}
+}
+
+// Many connections from one IP address, each with a session of its own, get
+// no more than the default limit of the IP address counted in a round.
+func TestVoteLimitManyConnections(t *testing.T) {
+	useVoteLimits(t, gameserver.VOTES_PER_CLIENT, gameserver.VOTES_PER_IP, gameserver.PenaltyNone)
+	c := newCluster(t, Config{NumGameServers: 1})
+	hostport := c.GameServerHostPort(0)
+	const n = 3 * gameserver.VOTES_PER_IP
+	conns := make([]*websocket.Conn, n)
+	for i := range conns {
+		conns[i] = dialVoter(t, hostport, "sybil"+strconv.Itoa(i))
+	}
+	for _, ws := range conns {
+		flood(ws, 1)
+	}
+
+	metrics := waitForVotes(t, hostport, n)
+	if rejected := metricSum(t, metrics, `gameserver_votes_rejected_total{reason="ip_limit"}`); rejected == 0 {
+		t.Errorf("None of %d sessions at one IP address were held to its limit:\n%s", n, metrics)
+	}
+	if accepted := metricSum(t, metrics, "gameserver_votes_total"); accepted >= n {
+		t.Errorf("All %g votes of %d sessions at one IP address were counted", accepted, n)
+	}
+}
+
+// Clients that give no session share one, however many connections they
+// open, and do not hang up on each other.
+func TestVoteLimitWithoutSessions(t *testing.T) {
+	useVoteLimits(t, 1, 0, gameserver.PenaltyNone)
+	c := newCluster(t, Config{NumGameServers: 1})
+	hostport := c.GameServerHostPort(0)
+	const n = 30
+	conns := make([]*websocket.Conn, n)
+	for i := range conns {
+		conns[i] = dialVoter(t, hostport, "")
+	}
+	for _, ws := range conns {
+		flood(ws, 1)
+	}
+
+	metrics := waitForVotes(t, hostport, n)
+	if accepted := metricSum(t, metrics, "gameserver_votes_total"); accepted > n/5 {
+		t.Errorf("%g of the votes of %d connections without a session were counted:\n%s", accepted, n, metrics)
+	}
+	if metricSum(t, metrics, "gameserver_duplicate_sessions_total") != 0 {
+		t.Errorf("Connections without a session replaced each other:\n%s", metrics)
+	}
+	if hungUp(conns[0], 200*time.Millisecond) {
+		t.Error("The first connection without a session was hung up on")
+	}
+}
+
+// A session that connects again replaces its old connection.
+func TestDuplicateSessions(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1})
+	hostport := c.GameServerHostPort(0)
+	old := dialVoter(t, hostport, "twice")
+	hungUp(old, 200*time.Millisecond) // so that the game server has it
+	current := dialVoter(t, hostport, "twice")
+	if !hungUp(old, 5*time.Second) {
+		t.Error("The old connection of a session is still open")
+	}
+	if hungUp(current, 500*time.Millisecond) {
+		t.Error("The new connection of a session was hung up on")
+	}
+	metrics := scrape(t, hostport)
+	if metricSum(t, metrics, "gameserver_duplicate_sessions_total") != 1 {
+		t.Errorf("Expected one duplicate session:\n%s", metrics)
+	}
+}
+
+func TestVoteLimitDrop(t *testing.T) {
+	useVoteLimits(t, 1, 0, gameserver.PenaltyDrop)
+	c := newCluster(t, Config{NumGameServers: 1, NumClients: 1})
+	hostport := c.GameServerHostPort(0)
+	ws := dialVoter(t, hostport, "flooder")
+	flood(ws, 10)
+	if !hungUp(ws, 5*time.Second) {
+		t.Fatal("A client over its vote limit was not hung up on")
+	}
+	metrics := scrape(t, hostport)
+	if metricSum(t, metrics, "gameserver_clients_dropped_total") != 1 {
+		t.Errorf("Expected one client dropped:\n%s", metrics)
+	}
+	checkConsistent(t, c)
+}
+
+// Once a client goes over its limit, its votes count for less.
+func TestVoteLimitDownweight(t *testing.T) {
+	useVoteLimits(t, 1, 0, gameserver.PenaltyDownweight)
+	c := newCluster(t, Config{NumGameServers: 1})
+	hostport := c.GameServerHostPort(0)
+	ws := dialVoter(t, hostport, "flooder")
+	flood(ws, 10)
+	time.Sleep(3 * gameserver.ProposeInterval)
+	flood(ws, 1)
+
+	var metrics string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		metrics = scrape(t, hostport)
+		if metricSum(t, metrics, "gameserver_votes_downweighted_total") > 0 {
+			break
+		}
+	}
+	if metricSum(t, metrics, "gameserver_votes_downweighted_total") != 1 {
+		t.Errorf("Expected the vote after the flood to be down-weighted:\n%s", metrics)
+	}
+	if hungUp(ws, 200*time.Millisecond) {
+		t.Error("A down-weighted client was hung up on")
+	}
+}
+
+// A down-weighted session stays down-weighted when it connects again.
+func TestVoteLimitDownweightReconnect(t *testing.T) {
+	useVoteLimits(t, 1, 0, gameserver.PenaltyDownweight)
+	c := newCluster(t, Config{NumGameServers: 1})
+	hostport := c.GameServerHostPort(0)
+	ws := dialVoter(t, hostport, "flooder")
+	flood(ws, 10)
+	waitForVotes(t, hostport, 10)
+	ws.Close()
+	time.Sleep(3 * gameserver.ProposeInterval)
+	flood(dialVoter(t, hostport, "flooder"), 1)
+
+	var metrics string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		metrics = scrape(t, hostport)
+		if metricSum(t, metrics, "gameserver_votes_downweighted_total") > 0 {
+			break
+		}
+	}
+	if metricSum(t, metrics, "gameserver_votes_downweighted_total") != 1 {
+		t.Errorf("Expected the vote after connecting again to be down-weighted:\n%s", metrics)
+	}
+}
+
+// Clients without a session are down-weighted one connection at a time, so
+// that one flooding does not get the others at its IP address down-weighted.
+func TestVoteLimitDownweightWithoutSessions(t *testing.T) {
+	useVoteLimits(t, 1, 0, gameserver.PenaltyDownweight)
+	c := newCluster(t, Config{NumGameServers: 1})
+	hostport := c.GameServerHostPort(0)
+	flooder, other := dialVoter(t, hostport, ""), dialVoter(t, hostport, "")
+	flood(flooder, 10)
+	waitForVotes(t, hostport, 10)
+	time.Sleep(3 * gameserver.ProposeInterval)
+	before := metricSum(t, scrape(t, hostport), "gameserver_votes_total")
+	flood(other, 1)
+
+	metrics := waitForVotes(t, hostport, 11)
+	if metricSum(t, metrics, "gameserver_votes_total") != before+1 {
+		t.Errorf("Expected the vote of the other client to be counted in full:\n%s", metrics)
+	}
+	if metricSum(t, metrics, "gameserver_votes_downweighted_total") != 0 {
+		t.Errorf("The other client without a session was down-weighted:\n%s", metrics)
+	}
+}
+
+// A client that floods hint requests gets an answer to each, all for the same
+// board, without the game server working one out for each request.
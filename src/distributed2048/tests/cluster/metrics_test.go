@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, hostport string) string {
+	resp, err := http.Get("http://" + hostport + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3})
+	makeMoves(t, c, rand.New(rand.NewSource(9)), 3)
+	checkConsistent(t, c)
+
+	for i := 0; i < c.NumGameServers(); i++ {
+		metrics := scrape(t, c.GameServerHostPort(i))
+		for _, want := range []string{
+			"gameserver_moves_total 3\n",
+			"gameserver_websocket_clients 1\n",
+			"gameserver_vote_round_seconds_count",
+			`paxos_phase_succeeded_total{phase="decide"}`,
+			"paxos_decided_slot ",
+		} {
+			if !strings.Contains(metrics, want) {
+				t.Errorf("Game server %d's metrics have no %q:\n%s", i, want, metrics)
+			}
+		}
+	}
+	metrics := scrape(t, c.central.HostPort())
+	for _, want := range []string{"centralserver_game_servers 3\n", "centralserver_assignments_total{game_server="} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("The central server's metrics have no %q:\n%s", want, metrics)
+		}
+	}
+}
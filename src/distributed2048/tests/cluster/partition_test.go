@@ -0,0 +1,192 @@
+package cluster
+
+import (
+	"distributed2048/cmdlineclient"
+	"distributed2048/lib2048"
+	"distributed2048/libpaxos"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// pinnedClients connects n clients straight to each game server, and
+// returns them by game server.
+func pinnedClients(t *testing.T, c *Cluster, n int) [][]cmdlineclient.Cclient {
+	clients := make([][]cmdlineclient.Cclient, c.NumGameServers())
+	for i := range clients {
+		for j := 0; j < n; j++ {
+			cli, err := c.AddClient(c.GameServerHostPort(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clients[i] = append(clients[i], cli)
+		}
+	}
+	return clients
+}
+
+func join(groups ...[]cmdlineclient.Cclient) []cmdlineclient.Cclient {
+	var clients []cmdlineclient.Cclient
+	for _, g := range groups {
+		clients = append(clients, g...)
+	}
+	return clients
+}
+
+// makeMovesWith is like makeMoves, but only the given clients vote.
+func makeMovesWith(t *testing.T, clients []cmdlineclient.Cclient, r *rand.Rand, n int) {
+	for i := 0; i < n; i++ {
+		dir := lib2048.Up + lib2048.Direction(r.Intn(4))
+		if err := MakeMove(clients, dir, moveTimeout); err != nil {
+			t.Fatalf("Move %d (%s): %s", i, dir, err)
+		}
+	}
+}
+
+// seqs returns the sequence number each client is at.
+func seqs(clients []cmdlineclient.Cclient) []uint64 {
+	s := make([]uint64, len(clients))
+	for i, cli := range clients {
+		s[i] = cli.GetSnapshot().Seq
+	}
+	return s
+}
+
+// healAndConverge heals the cluster, makes a few more moves with every client
+// so that the game servers that were cut off learn what they missed, and
+// checks that every board converged.
+func healAndConverge(t *testing.T, c *Cluster, r *rand.Rand) {
+	c.Heal()
+	makeMoves(t, c, r, 2)
+	checkConsistent(t, c)
+}
+
+func TestPartitionMinority(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 5})
+	clients := pinnedClients(t, c, 1)
+	r := rand.New(rand.NewSource(5))
+	makeMoves(t, c, r, 2)
+	checkConsistent(t, c)
+
+	if err := c.Partition([]int{0, 1}, []int{2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	minority, majority := join(clients[:2]...), join(clients[2:]...)
+	before := seqs(minority)
+
+	// The minority's clients keep voting, but only the majority side can get
+	// anything decided
+	for _, cli := range minority {
+		cli.InputMove(lib2048.Left)
+	}
+	makeMovesWith(t, majority, r, 3)
+	for i, seq := range seqs(minority) {
+		if seq != before[i] {
+			t.Fatalf("Minority client %d went from seq %d to %d while cut off", i, before[i], seq)
+		}
+	}
+	if err := c.CheckHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	healAndConverge(t, c, r)
+}
+
+// Every game server proposes its own clients' votes, so there is no fixed
+// leader to isolate. Instead, this isolates a proposer right after a majority
+// accepted its value, before its decide messages reach anybody. The majority
+// side must then decide that same value for the slot, and not one of its own.
+func TestPartitionProposer(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3})
+	clients := pinnedClients(t, c, 1)
+	r := rand.New(rand.NewSource(6))
+	makeMoves(t, c, r, 2)
+
+	c.GameServer(0).GetLibpaxos().Faults().AddRule(libpaxos.FaultRule{
+		Kind:      libpaxos.FaultDrop,
+		Direction: libpaxos.FaultOutgoing,
+		Action:    libpaxos.Decide,
+	})
+	makeMovesWith(t, clients[0], r, 1)
+	if err := c.Partition([]int{0}, []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	makeMovesWith(t, join(clients[1:]...), r, 3)
+	if err := c.CheckHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	healAndConverge(t, c, r)
+}
+
+func TestPartitionFlapping(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3})
+	clients := pinnedClients(t, c, 1)
+	r := rand.New(rand.NewSource(7))
+	makeMoves(t, c, r, 2)
+
+	// Game server 0 keeps losing and regaining its links to the others
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			c.Partition([]int{0}, []int{1, 2})
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Duration(100+rand.Intn(300)) * time.Millisecond):
+			}
+			c.Heal()
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Duration(100+rand.Intn(300)) * time.Millisecond):
+			}
+		}
+	}()
+
+	// The other two always have a majority between them, whatever game
+	// server 0's clients vote for
+	makeMovesWith(t, join(clients[1], clients[2], clients[0]), r, 5)
+	close(stop)
+	<-stopped
+	if err := c.CheckHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	healAndConverge(t, c, r)
+}
+
+func TestPartitionAsymmetric(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3})
+	clients := pinnedClients(t, c, 1)
+	r := rand.New(rand.NewSource(8))
+	makeMoves(t, c, r, 2)
+
+	// Game server 0 can reach the others, but not the other way around
+	c.CutLink(1, 0)
+	c.CutLink(2, 0)
+	makeMoves(t, c, r, 3)
+	if err := c.CheckHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The others can reach game server 0, but not the other way around. Its
+	// own votes never get decided, but its clients still follow the game,
+	// since it hears about every decision
+	c.Heal()
+	c.CutLink(0, 1)
+	c.CutLink(0, 2)
+	clients[0][0].InputMove(lib2048.Left)
+	makeMovesWith(t, join(clients[1:]...), r, 3)
+	target := clients[1][0].GetSnapshot().Seq
+	if _, err := clients[0][0].WaitForSeq(target, settleTimeout); err != nil {
+		t.Fatalf("Game server 0's client did not follow the game: %s", err)
+	}
+	if err := c.CheckHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	healAndConverge(t, c, r)
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"distributed2048/centralserver"
+	"distributed2048/gameserver"
+	"distributed2048/lib2048"
+	"distributed2048/libtls"
+	"distributed2048/rpc/centralrpc"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"testing"
+)
+
+// newTLS returns a TLS config with a CA and a certificate made for the test.
+// Configs made in different directories do not trust each other.
+func newTLS(t *testing.T, dir string) *libtls.Config {
+	ca, err := libtls.GenerateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := libtls.GenerateCert(dir, "node", []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := libtls.Load(ca, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestTLS(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3, TLS: newTLS(t, t.TempDir())})
+	r := rand.New(rand.NewSource(48))
+	makeMoves(t, c, r, 3)
+	checkConsistent(t, c)
+
+	// The central server reaches the game servers' admin service too
+	for _, gs := range clusterInfo(t, c).GameServers {
+		if !gs.Healthy {
+			t.Errorf("Game server %d is not healthy: %s", gs.ID, gs.Error)
+		}
+	}
+}
+
+// Game servers have to present a certificate signed by the cluster's CA to
+// register, or to send Paxos messages.
+func TestTLSRejectsUnknownPeers(t *testing.T) {
+	c := newCluster(t, Config{NumGameServers: 1, TLS: newTLS(t, t.TempDir())})
+	central := c.central.HostPort()
+	register := func(client *rpc.Client) error {
+		defer client.Close()
+		var reply centralrpc.RegisterGameServerReply
+		return client.Call("CentralServer.RegisterGameServer", &centralrpc.RegisterGameServerArgs{"localhost:1", nil}, &reply)
+	}
+
+	if client, err := rpc.DialHTTP("tcp", central); err == nil {
+		if err := register(client); err == nil {
+			t.Error("Registered without TLS")
+		}
+	}
+
+	// Over TLS, trusting the CA, but without a certificate
+	config := c.config.TLS.ClientConfig()
+	config.Certificates = nil
+	conn, err := tls.Dial("tcp", central, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client, err := libtls.NewRPCClient(conn); err == nil {
+		if err := register(client); err == nil {
+			t.Error("Registered without a certificate")
+		}
+	}
+	conn.Close()
+
+	if n := len(clusterInfo(t, c).GameServers); n != 1 {
+		t.Errorf("%d game servers registered, expected 1", n)
+	}
+
+	// Paxos is not reachable without a certificate either
+	config.Certificates = nil
+	conn, err = tls.Dial("tcp", c.GameServerHostPort(0), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := libtls.NewRPCClient(conn); err == nil {
+		t.Error("Reached the Paxos RPC server without a certificate")
+	}
+}
+
+// A game server given a certificate from another CA cannot join a central
+// server that has room for it.
+func TestTLSWrongCA(t *testing.T) {
+	l, err := net.Listen("tcp", hostname+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	central, err := centralserver.NewCentralServerWithListener(l, 1, newTLS(t, t.TempDir()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer central.Close()
+
+	l, err = net.Listen("tcp", hostname+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, err := gameserver.NewGameServerWithListener(l, central.HostPort(), hostname, pattern, "", lib2048.Options{}, newTLS(t, t.TempDir()), nil)
+	if err == nil {
+		gs.Close()
+		t.Fatal("Registered with a certificate from another CA")
+	}
+}
@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"bytes"
+	"distributed2048/libtrace"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	if err := libtrace.Configure(path); err != nil {
+		t.Fatal(err)
+	}
+	defer libtrace.SetExporter(nil)
+
+	c := newCluster(t, Config{NumGameServers: 3, NumClients: 3})
+	makeMoves(t, c, rand.New(rand.NewSource(13)), 3)
+	checkConsistent(t, c)
+	if err := libtrace.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans, err := libtrace.ReadSpans(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every move, on every game server, can be followed back to the votes
+	// of the clients
+	moves := 0
+	for _, s := range spans {
+		if s.Name != "gameserver.move" {
+			continue
+		}
+		moves++
+		var buf bytes.Buffer
+		if err := libtrace.Explain(&buf, spans, s.SpanID); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{
+			"gameserver.bucket", "gameserver.propose", "paxos.queue", "paxos.round",
+			"paxos.prepare", "paxos.accept", "paxos.receive_accept", "paxos.decide",
+			"gameserver.buffer", "client.vote",
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Fatalf("The explanation of move %s on %s has no %s:\n%s", s.Attrs["seq"], s.Service, want, buf.String())
+			}
+		}
+	}
+	if moves != 3*c.NumGameServers() {
+		t.Errorf("Expected %d move spans, got %d", 3*c.NumGameServers(), moves)
+	}
+}
@@ -6,9 +6,12 @@ package main
 // Der client be making 8 simple moves
 // ======================================================================== //
 import (
+	"context"
 	"distributed2048/cmdlineclient"
 	"distributed2048/lib2048"
+	"distributed2048/libconfig"
 	"distributed2048/util"
+	"flag"
 	"fmt"
 	"os"
 	"time"
@@ -16,6 +19,13 @@ import (
 
 var LOGV = util.NewLogger(false, "LIBSTORETEST", os.Stdout)
 
+// How long to wait for each move to be applied
+const MOVE_TIMEOUT = 10 * time.Second
+
+// conf holds the settings given with -config, such as the central server's
+// address and the certificates to connect with.
+var conf = libconfig.Default()
+
 var (
 	passCount int
 	failCount int
@@ -36,9 +46,10 @@ func processError(err error, msg string) {
 
 func testOneCentralOneClientOneGameserv() {
 	// Step 1: Boot Testing Client
-	cservAddr := "http://" + util.CENTRALHOSTPOST
-	cli, err := cmdlineclient.NewCClient(cservAddr, "", util.DEFAULTINTERVAL)
+	cservAddr := conf.TLSConfig().URL(conf.Central.HostPort())
+	cli, err := cmdlineclient.NewCClient(context.Background(), cservAddr, "", util.DEFAULTINTERVAL, conf.TLSConfig())
 	processError(err, util.CFAIL)
+	defer cli.Close()
 
 	// Step 2: Initialize moves + obtain correct answer
 	movelist := []*lib2048.Move{
@@ -55,8 +66,15 @@ func testOneCentralOneClientOneGameserv() {
 
 	// Step 3: Test
 	for _, m := range movelist {
+		// Wait for each move to be applied before sending the next, so
+		// that there is one move per paxos round
+		seq := cli.GetSnapshot().Seq
 		cli.InputMove(m.Direction)
-		time.Sleep(1000 * time.Millisecond) // has to be large enough so one move per paxos round
+		if _, err := cli.WaitForSeq(seq+1, MOVE_TIMEOUT); err != nil {
+			fmt.Println("PHAIL: MOVE WAS NOT APPLIED:", err)
+			failCount++
+			return
+		}
 	}
 
 	LOGV.Println("CLI's game state")
@@ -74,6 +92,15 @@ func testOneCentralOneClientOneGameserv() {
 }
 
 func main() {
+	if err := libconfig.ParseFlags(flag.CommandLine, os.Args[1:], conf); err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(1)
+	}
+	if err := conf.Apply(); err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(1)
+	}
+
 	tests := []testFunc{
 		{"testOneCentralOneClientOneGameserv", testOneCentralOneClientOneGameserv},
 	}
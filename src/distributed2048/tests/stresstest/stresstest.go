@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"distributed2048/cmdlineclient"
 	"distributed2048/lib2048"
+	"distributed2048/libai"
+	"distributed2048/libconfig"
+	"distributed2048/tests/cluster"
 	"distributed2048/util"
 	"flag"
 	"fmt"
@@ -13,23 +17,41 @@ import (
 
 var LOGV = util.NewLogger(false, "LIBSTORETEST", os.Stdout)
 
+// conf holds the settings given with -config, such as the central server's
+// address and the certificates to connect with.
+var conf = libconfig.Default()
+
 var (
 	numClients = flag.Int("numClients", 1, "number of game clients")
 	// numGameServers        = flag.Int("numGameServers", 1, "number of game servers")
 	gameServerHostPorts   = flag.String("gsHostPorts", "", "comma separated list of host:port for each game server")
-	centralServerHostPort = flag.String("csHostPort", fmt.Sprintf("localhost:%d", util.CENTRALPORT), "host:port of central server")
+	centralServerHostPort = flag.String("csHostPort", "", "host:port of central server (the one in -config if empty)")
 	numMoves              = flag.Int("numMoves", 10, "number of random moves to generate")
 	numSendingClients     = flag.Int("numSendingClients", 1, "number of clients that will be sending moves")
-	sendMoveInterval      = flag.Int("sendMoveInterval", 1000, "number of milliseconds between sending moves")
+	sendMoveInterval      = flag.Int("sendMoveInterval", 1000, "longest number of milliseconds to wait for a round of moves to be applied before sending the next")
+	useAI                 = flag.Bool("ai", false, "whether sending clients ask the AI for moves instead of playing random ones")
 )
 
+// How long every client gets to catch up with the others at the end
+const SETTLE_TIMEOUT = 10 * time.Second
+
 type testFunc struct {
 	name string
 	f    func()
 }
 
 func test() bool {
-	flag.Parse()
+	if err := libconfig.ParseFlags(flag.CommandLine, os.Args[1:], conf); err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(-1)
+	}
+	if err := conf.Apply(); err != nil {
+		fmt.Println("ERROR:", err)
+		os.Exit(-1)
+	}
+	if *centralServerHostPort == "" {
+		*centralServerHostPort = conf.Central.HostPort()
+	}
 	useCentral := len(*gameServerHostPorts) == 0
 	gsHostPortsSlice := strings.Split(*gameServerHostPorts, ",")
 	if len(gsHostPortsSlice) == 0 && *centralServerHostPort == "" {
@@ -44,7 +66,7 @@ func test() bool {
 	}
 
 	// Start up all command line clients
-	cservAddr := "http://" + *centralServerHostPort
+	cservAddr := conf.TLSConfig().URL(*centralServerHostPort)
 	clients := make([]cmdlineclient.Cclient, *numClients)
 	gsHostPort := ""
 	for i := 0; i < *numClients; i++ {
@@ -53,7 +75,7 @@ func test() bool {
 			cservAddr = ""
 		}
 		var err error
-		clients[i], err = cmdlineclient.NewCClient(cservAddr, gsHostPort, util.DEFAULTINTERVAL)
+		clients[i], err = cmdlineclient.NewCClient(context.Background(), cservAddr, gsHostPort, util.DEFAULTINTERVAL, conf.TLSConfig())
 		if err != nil {
 			fmt.Println("FAIL: Command line client could not start:", err)
 			return false
@@ -61,22 +83,31 @@ func test() bool {
 	}
 
 	// Test the moves
+	advisor := libai.NewExpectimax(libai.DefaultDepth)
 	for i, m := range moveList {
 		fmt.Printf("Sending moves (%d/%d)...\n", i+1, len(moveList))
+		dir := m.Direction
+		if *useAI {
+			// Every client should have the same board, so ask for a move
+			// on the first one's
+			dir, _ = advisor.SuggestMove(clients[0].GetGameState())
+		}
+		seq := clients[0].GetSnapshot().Seq
 		for i := 0; i < *numSendingClients; i++ {
-			clients[i].InputMove(m.Direction)
+			clients[i].InputMove(dir)
 		}
-		time.Sleep(time.Duration(*sendMoveInterval) * time.Millisecond)
+		// Not every round of votes makes a move, so only wait so long
+		clients[0].WaitForSeq(seq+1, time.Duration(*sendMoveInterval)*time.Millisecond)
 	}
 
 	// Check that all clients have the same set of moves
-	time.Sleep(7 * time.Second) // Let all network operations complete
+	if err := cluster.CheckConsistent(clients, SETTLE_TIMEOUT); err != nil {
+		fmt.Println("FAIL:", err)
+		return false
+	}
 	for i, c := range clients {
-		if !c.GetGameState().Equals(clients[0].GetGameState()) {
-			fmt.Println("FAIL: Game client", i, "differs in state from game client", 0)
-			fmt.Printf("Client %d's state:\n%s\n", i, c.GetGameState().String())
-
-			fmt.Printf("Client %d's state:\n%s\n", 0, clients[0].GetGameState().String())
+		if err := c.Close(); err != nil {
+			fmt.Println("FAIL: Game client", i, "stopped with an error:", err)
 			return false
 		}
 	}
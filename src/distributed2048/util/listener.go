@@ -0,0 +1,55 @@
+package util
+
+import (
+	"net"
+	"sync"
+)
+
+// TrackingListener remembers every connection it accepts until it is closed.
+// http.Server.Close does not close connections that have been hijacked from
+// it, which both net/rpc and websockets do, so servers that need to hang up
+// on everybody close them through CloseConns.
+type TrackingListener struct {
+	net.Listener
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func NewTrackingListener(l net.Listener) *TrackingListener {
+	return &TrackingListener{Listener: l, conns: make(map[net.Conn]struct{})}
+}
+
+func (l *TrackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c := &trackedConn{conn, l}
+	l.mutex.Lock()
+	l.conns[c] = struct{}{}
+	l.mutex.Unlock()
+	return c, nil
+}
+
+// CloseConns closes every accepted connection that is still open.
+func (l *TrackingListener) CloseConns() {
+	l.mutex.Lock()
+	conns := l.conns
+	l.conns = make(map[net.Conn]struct{})
+	l.mutex.Unlock()
+	for c := range conns {
+		c.Close()
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	l *TrackingListener
+}
+
+func (c *trackedConn) Close() error {
+	c.l.mutex.Lock()
+	delete(c.l.conns, c)
+	c.l.mutex.Unlock()
+	return c.Conn.Close()
+}
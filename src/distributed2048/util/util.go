@@ -2,6 +2,7 @@ package util
 
 import (
 	"distributed2048/lib2048"
+	"distributed2048/libtrace"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,6 +18,7 @@ const (
 	LOCALHOST       = "localhost"
 	DEFAULTINTERVAL = 500
 	DEFAULTPATTERN  = "/"
+	WSPATTERN       = "/abc" // the path game servers serve websockets on, by default
 	CSFAIL          = "PHAIL: COULD NOT START CENTRAL SERVER"
 	GSFAIL          = "PHAIL: COULD NOT START GAME SERVER"
 	CFAIL           = "PHAIL: COULD NOT START CLIENT"
@@ -25,23 +27,79 @@ const (
 
 var r = rand.New(rand.NewSource(time.Now().UnixNano()))
 
+// ClientMove is sent by clients to vote for a direction, numbered as in
+// DirectionToClient, or to ask for a hint.
 type ClientMove struct {
 	Direction int
+	Hint      bool
+	Trace     *libtrace.SpanContext `json:",omitempty"` // the client's span for the vote, if traced
 }
 
+// HintMessage is sent back to a client that asked for a hint.
+type HintMessage struct {
+	Hint int
+	Seq  uint64 // sequence number of the state the hint is for
+}
+
+// ReconnectMessage is sent to the clients of a game server that is shutting
+// down, just before it hangs up on them, so that they ask the central server
+// for another game server straight away.
+type ReconnectMessage struct {
+	Reconnect bool
+}
+
+// DirectionFromClient converts the direction numbers used by clients (0 up,
+// 1 right, 2 down, 3 left) into a lib2048 direction.
+func DirectionFromClient(dir int) lib2048.Direction {
+	switch dir {
+	case 0:
+		return lib2048.Up
+	case 1:
+		return lib2048.Right
+	case 2:
+		return lib2048.Down
+	case 3:
+		return lib2048.Left
+	}
+	return 0
+}
+
+// DirectionToClient is the inverse of DirectionFromClient.
+func DirectionToClient(dir lib2048.Direction) int {
+	switch dir {
+	case lib2048.Up:
+		return 0
+	case lib2048.Right:
+		return 1
+	case lib2048.Down:
+		return 2
+	case lib2048.Left:
+		return 3
+	}
+	return -1
+}
+
+// Game2048State is what game servers send to their clients. The snapshot of
+// the game is embedded, so that Grid and Score are top level JSON fields.
+//
+// Seq counts the state changes the cluster has applied, both new games and
+// moves. Every game server applies the same decided proposals in the same
+// order, so two states with the same Seq are the same state, whichever
+// server sent them.
 type Game2048State struct {
+	lib2048.State
 	Won   bool
 	Over  bool
-	Grid  lib2048.Grid
-	Score int
 	Consensus string
+	Seq   uint64
 }
 
 func (s *Game2048State) String() string {
-	game := lib2048.NewGame2048()
-	game.SetGrid(s.Grid)
-	game.SetScore(s.Score)
-	return fmt.Sprintf("%sWon: %s\nOver: %s\n", game.String(), s.Won, s.Over)
+	game, err := lib2048.NewGame2048FromState(s.State)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%sWon: %t\nOver: %t\n", game.String(), s.Won, s.Over)
 }
 
 func NewLogger(enabled bool, prefix string, out io.Writer) *log.Logger {
@@ -6,11 +6,9 @@ if [ -z $GOPATH ]; then
 fi
 
 # Params
-CENTRAL_SERVER_PKG="distributed2048/runners/cservrunner"
-GAME_SERVER_PKG="distributed2048/runners/grunner"
+CONFIG="$(dirname "$0")/../d2048.toml" # the ports and other settings of every process
+D2048_PKG="distributed2048/runners/d2048"
 TEST_PKG="distributed2048/tests/stresstest"
-CENTRAL_PORT=25340
-CENTRAL_HOSTPORT="localhost:$CENTRAL_PORT"
 PASS_RETURN_VAL=7
 
 # Game Server Arguments
@@ -37,13 +35,13 @@ NUM_TO_KILL=1
 KILL_INTERVAL=10
 
 # Commands
-CENTRAL_SERVER=$GOPATH/bin/cservrunner
-GAME_SERVER=$GOPATH/bin/grunner
+CENTRAL_SERVER="$GOPATH/bin/d2048 central"
+GAME_SERVER="$GOPATH/bin/d2048 game"
 TEST=$GOPATH/bin/stresstest
 
 function startCentralServer {
-  # echo "[TESTSCRIPT] Starting central server on ${CENTRAL_PORT}"
-  ${CENTRAL_SERVER} -port=${CENTRAL_PORT} -gameservers=${NUM_GAME_SERVERS} &
+  # echo "[TESTSCRIPT] Starting central server"
+  ${CENTRAL_SERVER} -config=${CONFIG} -gameservers=${NUM_GAME_SERVERS} &
   CENTRAL_SERVER_PID=$!
   sleep 1
 }
@@ -61,7 +59,7 @@ function startGameServers {
   COUNTER=0
   # Start up the faulty servers
   for (( i=0; i < $NUM_FAULTY_GAME_SERVERS; i++ )); do
-    ${GAME_SERVER} -port=${GAME_SERVER_PORT} -central=${CENTRAL_HOSTPORT} -faulty=true -faultyPercent=${FAULTY_PERCENT} -lagDuration=${LAG_DURATION} -lagPrepare=${LAG_PREPARE} -lagAccept=${LAG_ACCEPT} -lagDecide=${LAG_DECIDE} -maxLagSlotNumber=${MAX_LAG_SLOTNUMBER} &
+    ${GAME_SERVER} -config=${CONFIG} -port=${GAME_SERVER_PORT} -faulty=true -faultyPercent=${FAULTY_PERCENT} -lagDuration=${LAG_DURATION} -lagPrepare=${LAG_PREPARE} -lagAccept=${LAG_ACCEPT} -lagDecide=${LAG_DECIDE} -maxLagSlotNumber=${MAX_LAG_SLOTNUMBER} &
     GAME_SERVER_PID[$COUNTER]=$!
     COUNTER=$((COUNTER + 1))
     GAME_SERVER_PORT=$((GAME_SERVER_PORT + 1))
@@ -71,7 +69,7 @@ function startGameServers {
   REMAINING_SERVERS=$((NUM_GAME_SERVERS - NUM_FAULTY_GAME_SERVERS))
 
   for (( i=0; i < $REMAINING_SERVERS; i++)); do
-    ${GAME_SERVER} -port=${GAME_SERVER_PORT} -central=${CENTRAL_HOSTPORT} &
+    ${GAME_SERVER} -config=${CONFIG} -port=${GAME_SERVER_PORT} &
     GAME_SERVER_PID[$COUNTER]=$!
     COUNTER=$((COUNTER + 1))
     GAME_SERVER_PORT=$((GAME_SERVER_PORT + 1))
@@ -97,7 +95,7 @@ function doTest {
   startGameServers
   sleep 3 # wait for the game servers to all join
 
-  ${TEST} -numClients=${NUM_GAME_CLIENTS} -csHostPort=${CENTRAL_HOSTPORT} -numMoves=${NUM_MOVES} -numSendingClients=${NUM_SENDING_GAME_CLIENTS} -sendMoveInterval=${SEND_MOVE_INTERVAL} &
+  ${TEST} -config=${CONFIG} -numClients=${NUM_GAME_CLIENTS} -numMoves=${NUM_MOVES} -numSendingClients=${NUM_SENDING_GAME_CLIENTS} -sendMoveInterval=${SEND_MOVE_INTERVAL} &
   TEST_PID=$!
   TIMEDOUT=false
   sleep ${TIMEOUT} && kill -9 ${TEST_PID} &> /dev/null && TIMEDOUT=true &
@@ -204,15 +202,8 @@ function testTwentyClientFiveGameTwoFailure {
   doTest
 }
 
-# Build and install the Central Server binary
-go install ${CENTRAL_SERVER_PKG}
-if [ $? -ne 0 ]; then
-   echo "FAIL: code does not compile"
-   exit $?
-fi
-
-# Build and install the Game Server binary
-go install ${GAME_SERVER_PKG}
+# Build and install the d2048 binary
+go install ${D2048_PKG}
 if [ $? -ne 0 ]; then
    echo "FAIL: code does not compile"
    exit $?
@@ -1,5 +1,5 @@
-pkill -f cservrunner
-pkill -f grunner
+pkill -f "d2048 central"
+pkill -f "d2048 game"
 pkill -f cmdlineclient
-pkill -f crunner
-pkill -f stresstest
\ No newline at end of file
+pkill -f "d2048 web"
+pkill -f stresstest
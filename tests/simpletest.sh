@@ -6,22 +6,13 @@ if [ -z $GOPATH ]; then
 fi
 
 # Params
-CENTRAL_SERVER_PKG="distributed2048/runners/cservrunner"
-GAME_SERVER_PKG="distributed2048/runners/grunner"
+CONFIG="$(dirname "$0")/../d2048.toml" # the ports and other settings of every process
+D2048_PKG="distributed2048/runners/d2048"
 TEST_PKG="distributed2048/tests/simpletests"
-CENTRAL_PORT=25340
-GAME_SERVER_PORT=15551
 NUM_GAME_SERVERS=1
 
-# Build and install the Central Server binary
-go install ${CENTRAL_SERVER_PKG}
-if [ $? -ne 0 ]; then
-   echo "FAIL: code does not compile"
-   exit $?
-fi
-
-# Build and install the Game Server binary
-go install ${GAME_SERVER_PKG}
+# Build and install the d2048 binary
+go install ${D2048_PKG}
 if [ $? -ne 0 ]; then
    echo "FAIL: code does not compile"
    exit $?
@@ -34,26 +25,26 @@ if [ $? -ne 0 ]; then
    exit $?
 fi
 
-CENTRAL_SERVER=$GOPATH/bin/cservrunner
-GAME_SERVER=$GOPATH/bin/grunner
+CENTRAL_SERVER="$GOPATH/bin/d2048 central"
+GAME_SERVER="$GOPATH/bin/d2048 game"
 TEST=$GOPATH/bin/simpletests
 
-echo "SCRIPT STARTING CENTRAL SERVER ON PORT ${CENTRAL_PORT}"
-${CENTRAL_SERVER} -port=${CENTRAL_PORT} -gameservers=${NUM_GAME_SERVERS} &
+echo "SCRIPT STARTING CENTRAL SERVER"
+${CENTRAL_SERVER} -config=${CONFIG} -gameservers=${NUM_GAME_SERVERS} &
 CENTRAL_SERVER_PID=$!
 sleep 2
 
 for (( i=0; i < $NUM_GAME_SERVERS; i++))
 do
-    echo "SCRIPT STARTING GAME SERVER ON PORT ${GAME_SERVER_PORT}"
-    ${GAME_SERVER} -port=${GAME_SERVER_PORT} -central=localhost:${CENTRAL_PORT} &
+    echo "SCRIPT STARTING GAME SERVER"
+    ${GAME_SERVER} -config=${CONFIG} &
     GAME_SERVER_PID[$i]=$!
     sleep 1
 done
 
 sleep 2
 echo "SCRIPT STARTING TEST"
-${TEST}
+${TEST} -config=${CONFIG}
 
 # Kill the game servers
 for (( i=0; i < $NUM_GAME_SERVERS; i++))
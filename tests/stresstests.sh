@@ -6,10 +6,9 @@ if [ -z $GOPATH ]; then
 fi
 
 # Params
-CENTRAL_SERVER_PKG="distributed2048/runners/cservrunner"
-GAME_SERVER_PKG="distributed2048/runners/grunner"
+CONFIG="$(dirname "$0")/../d2048.toml" # the ports and other settings of every process
+D2048_PKG="distributed2048/runners/d2048"
 TEST_PKG="distributed2048/tests/stresstest"
-CENTRAL_PORT=25340
 PASS_RETURN_VAL=7
 
 # Stress Test Arguments
@@ -23,13 +22,13 @@ SEND_MOVE_INTERVAL=1000
 TIMEOUT=15
 
 # Commands
-CENTRAL_SERVER=$GOPATH/bin/cservrunner
-GAME_SERVER=$GOPATH/bin/grunner
+CENTRAL_SERVER="$GOPATH/bin/d2048 central"
+GAME_SERVER="$GOPATH/bin/d2048 game"
 TEST=$GOPATH/bin/stresstest
 
 function startCentralServer {
-  # echo "[TESTSCRIPT] Starting central server on ${CENTRAL_PORT}"
-  ${CENTRAL_SERVER} -port=${CENTRAL_PORT} -gameservers=${NUM_GAME_SERVERS} &
+  # echo "[TESTSCRIPT] Starting central server"
+  ${CENTRAL_SERVER} -config=${CONFIG} -gameservers=${NUM_GAME_SERVERS} &
   CENTRAL_SERVER_PID=$!
   sleep 1
 }
@@ -47,7 +46,7 @@ function startGameServers {
   for (( i=0; i < $NUM_GAME_SERVERS; i++)); do
     GAME_SERVER_PORT=$(($FIRST_GAME_SERVER_PORT + $i))
     # echo "[TESTSCRIPT] Starting game server on ${GAME_SERVER_PORT}"
-    ${GAME_SERVER} -port=${GAME_SERVER_PORT} -central=localhost:${CENTRAL_PORT} &
+    ${GAME_SERVER} -config=${CONFIG} -port=${GAME_SERVER_PORT} &
     GAME_SERVER_PID[$i]=$!
     if [ $i != 0 ]; then
       GS_HOSTPORTS_STRING="$GS_HOSTPORTS_STRING,"
@@ -76,12 +75,9 @@ function doStressTest {
 
   if $USE_CENTRAL; then
     GS_HOSTPORTS_STRING=""
-    CENTRAL_HOSTPORT="localhost:$CENTRAL_PORT"
-  else
-    CENTRAL_HOSTPORT=""
   fi
 
-  ${TEST} -numClients=${NUM_GAME_CLIENTS} -gsHostPorts=${GS_HOSTPORTS_STRING} -csHostPort=${CENTRAL_HOSTPORT} -numMoves=${NUM_MOVES} -numSendingClients=${NUM_SENDING_GAME_CLIENTS} -sendMoveInterval=${SEND_MOVE_INTERVAL} &
+  ${TEST} -config=${CONFIG} -numClients=${NUM_GAME_CLIENTS} -gsHostPorts=${GS_HOSTPORTS_STRING} -numMoves=${NUM_MOVES} -numSendingClients=${NUM_SENDING_GAME_CLIENTS} -sendMoveInterval=${SEND_MOVE_INTERVAL} &
   TEST_PID=$!
   sleep ${TIMEOUT} && kill -9 ${TEST_PID} &> /dev/null && TIMEDOUT=true &
 
@@ -189,15 +185,8 @@ function testHundredClientTwentyFiveGameYesCentralThousandMoves {
   doStressTest
 }
 
-# Build and install the Central Server binary
-go install ${CENTRAL_SERVER_PKG}
-if [ $? -ne 0 ]; then
-   echo "FAIL: code does not compile"
-   exit $?
-fi
-
-# Build and install the Game Server binary
-go install ${GAME_SERVER_PKG}
+# Build and install the d2048 binary
+go install ${D2048_PKG}
 if [ $? -ne 0 ]; then
    echo "FAIL: code does not compile"
    exit $?